@@ -0,0 +1,190 @@
+// Package audit keeps an append-only log of every actuation, one json object per line
+//
+//	{"time":"2017-08-02T10:00:00Z","user":"alice","auth":"session","ip":"10.0.0.5","device":"front-door","pin":"18","action":"timer","params":{"delay":"2s"},"result":"ok"}
+package audit
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Results of an actuation
+const (
+	OK     = "ok"
+	Denied = "denied"
+	Failed = "failed"
+)
+
+// Entry is a single actuation
+type Entry struct {
+	Time time.Time `json:"time"`
+	// User is the user name or the token used to authenticate
+	User string `json:"user"`
+	// Auth is how the user was authenticated
+	Auth   string            `json:"auth,omitempty"`
+	IP     string            `json:"ip,omitempty"`
+	Device string            `json:"device,omitempty"`
+	Pin    string            `json:"pin"`
+	Action string            `json:"action"`
+	Params map[string]string `json:"params,omitempty"`
+	Result string            `json:"result"`
+	Error  string            `json:"error,omitempty"`
+	// Job is the id of the job started or cancelled
+	Job string `json:"job,omitempty"`
+}
+
+// Filter selects entries, the zero values match everything
+type Filter struct {
+	From   time.Time
+	To     time.Time
+	User   string
+	Device string
+	// Limit keeps only the newest entries
+	Limit int
+}
+
+func (f Filter) match(e Entry) bool {
+	switch {
+	case !f.From.IsZero() && e.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && e.Time.After(f.To):
+		return false
+	case f.User != "" && e.User != f.User:
+		return false
+	case f.Device != "" && e.Device != f.Device && e.Pin != f.Device:
+		return false
+	}
+	return true
+}
+
+// Log is the audit log file
+type Log struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Open opens the log for appending, the file is created when missing
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{path: path, f: f}, nil
+}
+
+// Record appends the entry, a failure is only logged so that a full disk doesn't stop the door from opening
+func (l *Log) Record(e Entry) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	d, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Couldn't encode the audit entry:%v", err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(d, '\n')); err != nil {
+		log.Printf("Couldn't write the audit log %v:%v", l.path, err)
+	}
+}
+
+// Query returns the matching entries, the newest first
+func (l *Log) Query(f Filter) ([]Entry, error) {
+	entries := []Entry{}
+	if l == nil {
+		return entries, nil
+	}
+	r, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; s.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			// a line cut by a power loss shouldn't hide the rest of the log
+			log.Printf("Skipping invalid audit log line %v:%v", line, err)
+			continue
+		}
+		if f.match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[:f.Limit]
+	}
+	return entries, nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// WriteCSV writes the entries as csv with a header row
+func WriteCSV(w io.Writer, entries []Entry) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"time", "user", "auth", "ip", "device", "pin", "action", "params", "result", "error", "job"})
+	for _, e := range entries {
+		row := []string{e.User, e.Auth, e.IP, e.Device, e.Pin, e.Action, params(e.Params), e.Result, e.Error, e.Job}
+		for i := range row {
+			row[i] = cell(row[i])
+		}
+		c.Write(append([]string{e.Time.Format(time.RFC3339)}, row...))
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// cell keeps a spreadsheet from running a value as a formula,
+// the user names, the device names and the errors come from the requests
+func cell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// params formats the parameters as sorted key=value pairs
+func params(p map[string]string) string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var s string
+	for i, k := range keys {
+		if i > 0 {
+			s += " "
+		}
+		s += fmt.Sprintf("%v=%v", k, p[k])
+	}
+	return s
+}
@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2017, 8, 2, 10, 0, 0, 0, time.UTC)
+
+func openLog(t *testing.T) *Log {
+	t.Helper()
+	l, err := Open(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestRecord(t *testing.T) {
+	l := openLog(t)
+	l.Record(Entry{User: "alice", Pin: "18", Action: "timer", Params: map[string]string{"delay": "2s"}, Result: OK})
+	l.Record(Entry{Time: start, User: "bob", Device: "front-door", Pin: "17", Action: "set", Result: Denied, Error: "no"})
+	l.Close()
+
+	fi, err := os.Stat(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("the log has the mode %v", fi.Mode().Perm())
+	}
+	d, _ := ioutil.ReadFile(l.path)
+	lines := strings.Split(strings.TrimSuffix(string(d), "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"user":"alice"`) || !strings.Contains(lines[1], `"time":"2017-08-02T10:00:00Z"`) {
+		t.Fatalf("unexpected log:\n%s", d)
+	}
+
+	// reopening appends to the entries of the previous run
+	l, err = Open(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Record(Entry{User: "carol", Pin: "18", Action: "toggle", Result: OK})
+	entries, err := l.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].User != "carol" || entries[1].Params["delay"] != "2s" || entries[2].User != "bob" {
+		t.Fatalf("unexpected entries after reopening:%+v", entries)
+	}
+
+	// a nil log records nothing and finds nothing
+	var none *Log
+	none.Record(Entry{User: "alice"})
+	if entries, err := none.Query(Filter{}); err != nil || len(entries) != 0 {
+		t.Fatalf("a nil log returned %v:%v", entries, err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	l := openLog(t)
+	for i, e := range []Entry{
+		{User: "alice", Device: "front-door", Pin: "18", Action: "timer"},
+		{User: "bob", Device: "front-door", Pin: "18", Action: "timer"},
+		{User: "alice", Device: "garage", Pin: "17", Action: "set"},
+		{User: "alice", Pin: "22", Action: "set"},
+		{User: "bob", Device: "garage", Pin: "17", Action: "set"},
+	} {
+		e.Time = start.Add(time.Duration(i) * time.Hour)
+		l.Record(e)
+	}
+	// a line cut by a power loss is skipped
+	l.mu.Lock()
+	l.f.WriteString(`{"time":"2017-08-02T16:00:00Z","user":"ali` + "\n")
+	l.mu.Unlock()
+
+	for _, tt := range []struct {
+		name   string
+		filter Filter
+		// hours are the hours after the start of the matching entries, the newest first
+		hours []int
+	}{
+		{"all", Filter{}, []int{4, 3, 2, 1, 0}},
+		{"user", Filter{User: "alice"}, []int{3, 2, 0}},
+		{"device", Filter{Device: "garage"}, []int{4, 2}},
+		{"device by pin", Filter{Device: "22"}, []int{3}},
+		{"from", Filter{From: start.Add(3 * time.Hour)}, []int{4, 3}},
+		{"to", Filter{To: start.Add(time.Hour)}, []int{1, 0}},
+		{"range", Filter{From: start.Add(time.Hour), To: start.Add(3 * time.Hour), User: "alice"}, []int{3, 2}},
+		{"limit", Filter{Limit: 2}, []int{4, 3}},
+		{"limit of user", Filter{User: "bob", Limit: 1}, []int{4}},
+		{"nothing", Filter{User: "mallory"}, []int{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := l.Query(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var hours []int
+			for _, e := range entries {
+				hours = append(hours, int(e.Time.Sub(start)/time.Hour))
+			}
+			if len(hours) != len(tt.hours) {
+				t.Fatalf("entries of the hours %v, expected %v", hours, tt.hours)
+			}
+			for i := range hours {
+				if hours[i] != tt.hours[i] {
+					t.Fatalf("entries of the hours %v, expected %v", hours, tt.hours)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var b bytes.Buffer
+	err := WriteCSV(&b, []Entry{
+		{Time: start, User: "alice", Auth: "session", IP: "10.0.0.5", Device: "front-door", Pin: "18", Action: "timer",
+			Params: map[string]string{"delay": "2s", "conflict": "extend"}, Result: OK, Job: "j1"},
+		// the values that come from a request can't become spreadsheet formulas
+		{Time: start, User: "=HYPERLINK(\"http://x\")", Device: "+1", Pin: "-2", Action: "@SUM(A1)", Result: Failed, Error: "\tcmd", Job: "\r"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"time", "user", "auth", "ip", "device", "pin", "action", "params", "result", "error", "job"},
+		{"2017-08-02T10:00:00Z", "alice", "session", "10.0.0.5", "front-door", "18", "timer", "conflict=extend delay=2s", "ok", "", "j1"},
+		{"2017-08-02T10:00:00Z", "'=HYPERLINK(\"http://x\")", "", "", "'+1", "'-2", "'@SUM(A1)", "", "failed", "'\tcmd", "'\r"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("%v rows, expected %v", len(rows), len(want))
+	}
+	for i := range want {
+		if strings.Join(rows[i], ",") != strings.Join(want[i], ",") {
+			t.Fatalf("row %v is %q, expected %q", i, rows[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,333 @@
+// Package config loads the TOML config file that declares the named devices
+//
+//	[[device]]
+//	name = "front-door"
+//	label = "Front door"
+//	icon = "🚪"
+//	pin = 18
+//	type = "pulse"
+//	delay = "2s"
+//	active_low = true
+//	conflict = "extend"
+//	safe_state = "off"
+//	role = "guest"
+//	users = ["alice"]
+//	max_on = "1h"
+//	max_delay = "10m"
+//	min_off = "30s"
+//	max_per_hour = 20
+//
+//	[[device]]
+//	name = "gate"
+//	pin = 24
+//	type = "sequence"
+//	sequence = "on 500ms off 200ms on 500ms"
+//
+//	[[interlock]]
+//	name = "roller-door"
+//	devices = ["door-up", "door-down"]
+//	dead_time = "500ms"
+//
+//	[location]
+//	latitude = 42.5048
+//	longitude = 27.4626
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/krasi-georgiev/rpi-web-control/rpiGpio"
+)
+
+var deviceName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// Device types
+const (
+	Pulse    = "pulse"
+	Toggle   = "toggle"
+	Sequence = "sequence"
+)
+
+// Safe states applied at startup and on shutdown
+const (
+	SafeOff  = "off"
+	SafeOn   = "on"
+	SafeNone = "none"
+)
+
+// User roles, every role can do everything the lower roles can
+const (
+	Guest = "guest"
+	Host  = "host"
+	Admin = "admin"
+)
+
+var roleRank = map[string]int{Guest: 1, Host: 2, Admin: 3}
+
+// ValidRole reports if the role is one of guest, host or admin
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// HasRole reports if the role is at least the required role
+func HasRole(role, required string) bool {
+	return ValidRole(role) && roleRank[role] >= roleRank[required]
+}
+
+// Device is a named output connected to a pin
+type Device struct {
+	Name  string `toml:"name" json:"name"`
+	Label string `toml:"label" json:"label"`
+	Icon  string `toml:"icon" json:"icon,omitempty"`
+	Pin   string `toml:"pin" json:"pin"`
+	// Type is pulse(on for the delay and off again), toggle or sequence
+	Type string `toml:"type" json:"type"`
+	// Sequence are the steps of the sequence type like "(on 100ms off 100ms)x3"
+	Sequence  string        `toml:"sequence" json:"sequence,omitempty"`
+	Delay     time.Duration `toml:"delay" json:"-"`
+	ActiveLow bool          `toml:"active_low" json:"active_low"`
+	// Initial is the level written when the pin is exported so the output doesn't glitch - off or on,
+	// the default is on for the devices with the safe state on
+	Initial string `toml:"initial" json:"initial"`
+	// Conflict is what happens when the device is pulsed while its timer is running - restart(the default), extend, reject or queue
+	Conflict string `toml:"conflict" json:"conflict,omitempty"`
+	// SafeState is the level applied at startup and on shutdown - off(the default), on or none to leave the pin alone
+	SafeState string `toml:"safe_state" json:"safe_state"`
+	// Role is the lowest role allowed to control the device, the default is guest
+	Role string `toml:"role" json:"role"`
+	// Users can control the device whatever their role is
+	Users []string `toml:"users" json:"-"`
+	// MaxOn switches the device off when it stays on longer, MaxDelay is the longest pulse,
+	// MinOff is how long it must stay off before it is switched on again and MaxPerHour how often it can be switched on
+	MaxOn      time.Duration `toml:"max_on" json:"-"`
+	MaxDelay   time.Duration `toml:"max_delay" json:"-"`
+	MinOff     time.Duration `toml:"min_off" json:"-"`
+	MaxPerHour int           `toml:"max_per_hour" json:"-"`
+}
+
+// Limits returns the safety limits of the device
+func (d Device) Limits() rpiGpio.Limits {
+	return rpiGpio.Limits{MaxOn: d.MaxOn, MaxDelay: d.MaxDelay, MinOff: d.MinOff, MaxPerHour: d.MaxPerHour}
+}
+
+// Allowed reports if the user with the role can control the device
+func (d Device) Allowed(user, role string) bool {
+	if HasRole(role, d.Role) {
+		return true
+	}
+	for _, u := range d.Users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// ControlType is the rpiGpio control type of the device
+func (d Device) ControlType() string {
+	switch d.Type {
+	case Toggle:
+		return "toggle"
+	case Sequence:
+		return "sequence"
+	}
+	return "timer"
+}
+
+// Config is the content of the config file
+type Config struct {
+	Devices []Device `toml:"device"`
+	// Location is needed for the sunrise and sunset schedules
+	Location *Location `toml:"location"`
+	// Interlocks are the groups of devices where at most one may be on
+	Interlocks []Interlock `toml:"interlock"`
+}
+
+// Interlock is a group of devices or pins where at most one may be on at a time
+type Interlock struct {
+	Name    string   `toml:"name"`
+	Devices []string `toml:"devices"`
+	// DeadTime is how long the others must be off before one of them is switched on
+	DeadTime time.Duration `toml:"dead_time"`
+}
+
+// Location is where the controller is, the latitude is positive to the north and the longitude to the east
+type Location struct {
+	Latitude  float64 `toml:"latitude"`
+	Longitude float64 `toml:"longitude"`
+}
+
+// Load reads and validates the config file
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := parseTOML(f)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config file %v:%v", path, err)
+	}
+	c := &Config{}
+	if err := decode(m, c); err != nil {
+		return nil, fmt.Errorf("Invalid config file %v:%v", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid config file %v:%v", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	if l := c.Location; l != nil {
+		if l.Latitude < -90 || l.Latitude > 90 {
+			return fmt.Errorf("Invalid latitude:%v, use -90 to 90 degrees with the south negative", l.Latitude)
+		}
+		if l.Longitude < -180 || l.Longitude > 180 {
+			return fmt.Errorf("Invalid longitude:%v, use -180 to 180 degrees with the west negative", l.Longitude)
+		}
+	}
+	// a sequence can name the devices declared after it
+	devices := make(map[string]bool)
+	for _, d := range c.Devices {
+		devices[d.Name] = true
+	}
+	names := make(map[string]bool)
+	for i := range c.Devices {
+		d := &c.Devices[i]
+		if !deviceName.MatchString(d.Name) {
+			return fmt.Errorf("Invalid device name:%q, use lower case letters, digits, - and _", d.Name)
+		}
+		if names[d.Name] {
+			return fmt.Errorf("Duplicate device name:%v", d.Name)
+		}
+		names[d.Name] = true
+
+		// pins like phys:12 or wpi:1 are stored as gpio numbers
+		pin, err := rpiGpio.ResolvePin(d.Pin)
+		if err != nil || d.Pin == "" {
+			return fmt.Errorf("Invalid pin for device %v:%q", d.Name, d.Pin)
+		}
+		d.Pin = pin
+		switch d.Type {
+		case "":
+			d.Type = Pulse
+		case Pulse, Toggle:
+		case Sequence:
+			// the other pins are only checked, the devices are resolved when the sequence runs
+			// so their names aren't looked up as gpio line names
+			check := func(pin string) (*rpiGpio.Control, error) {
+				if devices[pin] {
+					return rpiGpio.NewControl()
+				}
+				return rpiGpio.NewControl(rpiGpio.SetPin(pin))
+			}
+			if _, err := rpiGpio.NewControl(rpiGpio.SetSequence(d.Sequence, check)); err != nil {
+				return fmt.Errorf("Invalid sequence for device %v:%v", d.Name, err)
+			}
+		default:
+			return fmt.Errorf("Invalid type for device %v:%v, use pulse, toggle or sequence", d.Name, d.Type)
+		}
+		if d.Delay < 0 {
+			return fmt.Errorf("Invalid delay for device %v:%v", d.Name, d.Delay)
+		}
+		if d.MaxOn < 0 || d.MaxDelay < 0 || d.MinOff < 0 || d.MaxPerHour < 0 {
+			return fmt.Errorf("Invalid limits for device %v, they can't be negative", d.Name)
+		}
+		if d.MaxDelay > 0 && d.Delay > d.MaxDelay {
+			return fmt.Errorf("The delay of device %v is longer than its max_delay %v", d.Name, d.MaxDelay)
+		}
+		if _, err := rpiGpio.NewControl(rpiGpio.SetConflict(d.Conflict)); err != nil {
+			return fmt.Errorf("Invalid conflict for device %v:%v, use restart, extend, reject or queue", d.Name, d.Conflict)
+		}
+		switch d.SafeState {
+		case "":
+			d.SafeState = SafeOff
+		case SafeOff, SafeOn, SafeNone:
+		default:
+			return fmt.Errorf("Invalid safe state for device %v:%v, use off, on or none", d.Name, d.SafeState)
+		}
+		switch d.Initial {
+		case "":
+			d.Initial = SafeOff
+			if d.SafeState == SafeOn {
+				d.Initial = SafeOn
+			}
+		case SafeOff, SafeOn:
+		default:
+			return fmt.Errorf("Invalid initial level for device %v:%v, use off or on", d.Name, d.Initial)
+		}
+		switch {
+		case d.Role == "":
+			d.Role = Guest
+		case !ValidRole(d.Role):
+			return fmt.Errorf("Invalid role for device %v:%v, use guest, host or admin", d.Name, d.Role)
+		}
+		if d.Label == "" {
+			d.Label = d.Name
+		}
+	}
+	for i, l := range c.Interlocks {
+		if l.Name == "" {
+			return fmt.Errorf("Interlock %v has no name", i+1)
+		}
+		if len(l.Devices) < 2 {
+			return fmt.Errorf("Interlock %v needs at least 2 devices", l.Name)
+		}
+		pins := make(map[string]bool)
+		on := 0
+		for _, name := range l.Devices {
+			pin := name
+			if d, ok := c.Device(name); ok {
+				pin = d.Pin
+				if d.SafeState == SafeOn || d.Initial == SafeOn {
+					on++
+				}
+			} else if p, err := rpiGpio.ResolvePin(name); err == nil {
+				pin = p
+			} else {
+				return fmt.Errorf("Interlock %v:%v isn't a device or a pin", l.Name, name)
+			}
+			if pins[pin] {
+				return fmt.Errorf("Interlock %v has pin %v more than once", l.Name, pin)
+			}
+			pins[pin] = true
+		}
+		if on > 1 {
+			return fmt.Errorf("Interlock %v has more than one device with the safe state or the initial level on", l.Name)
+		}
+		if l.DeadTime < 0 {
+			return fmt.Errorf("Invalid dead time for interlock %v:%v", l.Name, l.DeadTime)
+		}
+	}
+	return nil
+}
+
+// Interlock returns the rpiGpio interlock with the devices replaced by their pins
+func (c *Config) Interlock(l Interlock) rpiGpio.Interlock {
+	g := rpiGpio.Interlock{Name: l.Name, DeadTime: l.DeadTime}
+	for _, name := range l.Devices {
+		pin := name
+		if d, ok := c.Device(name); ok {
+			pin = d.Pin
+		} else if p, err := rpiGpio.ResolvePin(name); err == nil {
+			pin = p
+		}
+		g.Pins = append(g.Pins, pin)
+	}
+	return g
+}
+
+// Device finds a device by its name
+func (c *Config) Device(name string) (Device, bool) {
+	for _, d := range c.Devices {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Device{}, false
+}
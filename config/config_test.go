@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/krasi-georgiev/rpi-web-control/rpiGpio"
+)
+
+// lineBackend resolves gpio line names like the chip backend
+type lineBackend struct {
+	*rpiGpio.Sim
+	names map[string]string
+}
+
+func (b lineBackend) Line(name string) (string, error) {
+	o, ok := b.names[name]
+	if !ok {
+		return "", fmt.Errorf("No line named %v", name)
+	}
+	return o, nil
+}
+
+func loadConfig(t *testing.T, s string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestSequenceDevices(t *testing.T) {
+	old := rpiGpio.DefaultBackend
+	rpiGpio.DefaultBackend = lineBackend{Sim: rpiGpio.NewSim(), names: map[string]string{"RELAY_LINE": "17"}}
+	defer func() { rpiGpio.DefaultBackend = old }()
+
+	devices := `
+[[device]]
+name = "open-close"
+pin = 24
+type = "sequence"
+sequence = "%v"
+
+[[device]]
+name = "door-up"
+pin = 22
+
+[[device]]
+name = "door-down"
+pin = "RELAY_LINE"
+`
+	for _, tt := range []struct {
+		sequence string
+		err      string
+	}{
+		// the devices are declared after the sequence
+		{"door-up:on 1s door-up:off door-down:on 1s", ""},
+		{"(door-up:on 100ms door-up:off 100ms)x3 on 1s", ""},
+		{"RELAY_LINE:on 1s 23:on 1s phys:12:toggle", ""},
+		{"garage:on 1s", "garage"},
+		{"door-up:open", "invalid level"},
+	} {
+		t.Run(tt.sequence, func(t *testing.T) {
+			c, err := loadConfig(t, fmt.Sprintf(devices, tt.sequence))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if d, _ := c.Device("door-down"); d.Pin != "17" {
+					t.Fatalf("the line name of the device resolved to %v", d.Pin)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected an error with %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
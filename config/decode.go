@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decode copies the parsed TOML into v using the toml struct tags.
+// Unknown keys are an error so that typos in the config file don't go unnoticed.
+func decode(m map[string]interface{}, v interface{}) error {
+	return decodeTable("", m, reflect.ValueOf(v).Elem())
+}
+
+func decodeTable(path string, m map[string]interface{}, v reflect.Value) error {
+	fields := make(map[string]reflect.Value)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("toml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = v.Field(i)
+	}
+	for k, val := range m {
+		f, ok := fields[k]
+		if !ok {
+			return fmt.Errorf("unknown key:%v", path+k)
+		}
+		if err := decodeValue(path+k, val, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeValue(path string, val interface{}, f reflect.Value) error {
+	switch {
+	case f.Type() == durationType:
+		s, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("%v should be a duration like \"2s\"", path)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%v:%v", path, err)
+		}
+		f.SetInt(int64(d))
+		return nil
+	case f.Kind() == reflect.Ptr:
+		p := reflect.New(f.Type().Elem())
+		if err := decodeValue(path, val, p.Elem()); err != nil {
+			return err
+		}
+		f.Set(p)
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		switch v := val.(type) {
+		case string:
+			f.SetString(v)
+		case int64:
+			// allows pin = 18 as well as pin = "18"
+			f.SetString(fmt.Sprint(v))
+		default:
+			return fmt.Errorf("%v should be a string", path)
+		}
+	case reflect.Bool:
+		v, ok := val.(bool)
+		if !ok {
+			return fmt.Errorf("%v should be true or false", path)
+		}
+		f.SetBool(v)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		v, ok := val.(int64)
+		if !ok {
+			return fmt.Errorf("%v should be an integer", path)
+		}
+		f.SetInt(v)
+	case reflect.Float64:
+		switch v := val.(type) {
+		case float64:
+			f.SetFloat(v)
+		case int64:
+			f.SetFloat(float64(v))
+		default:
+			return fmt.Errorf("%v should be a number", path)
+		}
+	case reflect.Struct:
+		t, ok := val.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v should be a table", path)
+		}
+		return decodeTable(path+".", t, f)
+	case reflect.Map:
+		t, ok := val.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v should be a table", path)
+		}
+		if f.IsNil() {
+			f.Set(reflect.MakeMap(f.Type()))
+		}
+		for k, v := range t {
+			e := reflect.New(f.Type().Elem()).Elem()
+			if err := decodeValue(path+"."+k, v, e); err != nil {
+				return err
+			}
+			f.SetMapIndex(reflect.ValueOf(k), e)
+		}
+	case reflect.Slice:
+		var items []interface{}
+		switch v := val.(type) {
+		case []interface{}:
+			items = v
+		case []map[string]interface{}:
+			for _, t := range v {
+				items = append(items, t)
+			}
+		default:
+			return fmt.Errorf("%v should be an array", path)
+		}
+		s := reflect.MakeSlice(f.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeValue(fmt.Sprintf("%v[%v]", path, i), item, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+	default:
+		return fmt.Errorf("%v has an unsupported type %v", path, f.Type())
+	}
+	return nil
+}
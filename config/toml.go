@@ -0,0 +1,289 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the subset of TOML used by the config file:
+// tables, arrays of tables, dotted keys and tables, strings, integers, floats, booleans and arrays of them.
+// Multiline strings, inline tables and dates are not supported.
+func parseTOML(r io.Reader) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	current := root
+	// headers are the tables defined with a [table] header, each can be defined once
+	headers := make(map[uintptr]bool)
+
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		l := strings.TrimSpace(s.Text())
+		// arrays can continue on the following lines
+		for openBrackets(stripComment(l)) > 0 && !strings.HasPrefix(l, "[") && s.Scan() {
+			line++
+			l = stripComment(l) + " " + strings.TrimSpace(s.Text())
+		}
+		l = strings.TrimSpace(stripComment(l))
+		if l == "" {
+			continue
+		}
+
+		var err error
+		switch {
+		case strings.HasPrefix(l, "[["):
+			if !strings.HasSuffix(l, "]]") {
+				return nil, fmt.Errorf("line %v: invalid array of tables:%v", line, l)
+			}
+			current, err = arrayTable(root, strings.TrimSpace(l[2:len(l)-2]))
+		case strings.HasPrefix(l, "["):
+			if !strings.HasSuffix(l, "]") {
+				return nil, fmt.Errorf("line %v: invalid table:%v", line, l)
+			}
+			name := strings.TrimSpace(l[1 : len(l)-1])
+			if current, err = table(root, name); err == nil {
+				p := reflect.ValueOf(current).Pointer()
+				if headers[p] {
+					err = fmt.Errorf("duplicate table:%v", name)
+				}
+				headers[p] = true
+			}
+		default:
+			err = keyValue(current, l)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+	}
+	return root, s.Err()
+}
+
+// stripComment removes a # comment that isn't inside a string
+func stripComment(l string) string {
+	var quote rune
+	escaped := false
+	for i, c := range l {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return l[:i]
+		}
+	}
+	return l
+}
+
+// openBrackets counts the [ that aren't closed yet outside the strings
+func openBrackets(l string) int {
+	n := 0
+	var quote rune
+	escaped := false
+	for _, c := range l {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			n++
+		case c == ']':
+			n--
+		}
+	}
+	return n
+}
+
+// splitKey splits a dotted key, the dots in the quoted parts belong to the key
+func splitKey(k string) ([]string, error) {
+	var parts []string
+	var quote rune
+	start := 0
+	for i, c := range k {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '.':
+			parts = append(parts, k[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, k[start:])
+
+	var keys []string
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if len(p) > 1 && (p[0] == '"' || p[0] == '\'') && p[len(p)-1] == p[0] {
+			p = p[1 : len(p)-1]
+		}
+		if p == "" {
+			return nil, fmt.Errorf("invalid key:%v", k)
+		}
+		keys = append(keys, p)
+	}
+	return keys, nil
+}
+
+// descend returns the table at the path, creating the missing ones.
+// For arrays of tables it continues in the last element.
+func descend(m map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case nil:
+			t := make(map[string]interface{})
+			m[k] = t
+			m = t
+		case map[string]interface{}:
+			m = v
+		case []map[string]interface{}:
+			m = v[len(v)-1]
+		default:
+			return nil, fmt.Errorf("key %v is already defined as a value", k)
+		}
+	}
+	return m, nil
+}
+
+func table(root map[string]interface{}, name string) (map[string]interface{}, error) {
+	keys, err := splitKey(name)
+	if err != nil {
+		return nil, err
+	}
+	return descend(root, keys)
+}
+
+func arrayTable(root map[string]interface{}, name string) (map[string]interface{}, error) {
+	keys, err := splitKey(name)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := descend(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	k := keys[len(keys)-1]
+	t := make(map[string]interface{})
+	switch v := parent[k].(type) {
+	case nil:
+		parent[k] = []map[string]interface{}{t}
+	case []map[string]interface{}:
+		parent[k] = append(v, t)
+	default:
+		return nil, fmt.Errorf("key %v is already defined and is not an array of tables", k)
+	}
+	return t, nil
+}
+
+func keyValue(m map[string]interface{}, l string) error {
+	i := strings.Index(l, "=")
+	if i < 0 {
+		return fmt.Errorf("expected key = value:%v", l)
+	}
+	keys, err := splitKey(l[:i])
+	if err != nil {
+		return err
+	}
+	t, err := descend(m, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	k := keys[len(keys)-1]
+	if _, ok := t[k]; ok {
+		return fmt.Errorf("duplicate key:%v", k)
+	}
+	v, rest, err := parseValue(strings.TrimSpace(l[i+1:]))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return fmt.Errorf("unexpected text after the value:%v", rest)
+	}
+	t[k] = v
+	return nil
+}
+
+// parseValue parses the value at the start of s and returns the rest of s
+func parseValue(s string) (interface{}, string, error) {
+	switch {
+	case s == "":
+		return nil, "", fmt.Errorf("missing value")
+	case s[0] == '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				v, err := strconv.Unquote(s[:i+1])
+				return v, s[i+1:], err
+			}
+		}
+		return nil, "", fmt.Errorf("unterminated string:%v", s)
+	case s[0] == '\'':
+		i := strings.Index(s[1:], "'")
+		if i < 0 {
+			return nil, "", fmt.Errorf("unterminated string:%v", s)
+		}
+		return s[1 : i+1], s[i+2:], nil
+	case s[0] == '[':
+		var a []interface{}
+		s = strings.TrimSpace(s[1:])
+		for {
+			if strings.HasPrefix(s, "]") {
+				return a, s[1:], nil
+			}
+			v, rest, err := parseValue(s)
+			if err != nil {
+				return nil, "", err
+			}
+			a = append(a, v)
+			s = strings.TrimSpace(rest)
+			if strings.HasPrefix(s, ",") {
+				s = strings.TrimSpace(s[1:])
+			} else if !strings.HasPrefix(s, "]") {
+				return nil, "", fmt.Errorf("expected , or ] in the array:%v", s)
+			}
+		}
+	}
+
+	end := strings.IndexAny(s, ",]")
+	if end < 0 {
+		end = len(s)
+	}
+	tok := strings.TrimSpace(s[:end])
+	rest := s[end:]
+	switch tok {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	n := strings.Replace(tok, "_", "", -1)
+	if i, err := strconv.ParseInt(n, 0, 64); err == nil {
+		return i, rest, nil
+	}
+	if f, err := strconv.ParseFloat(n, 64); err == nil {
+		return f, rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid value:%v", tok)
+}
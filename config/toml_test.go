@@ -0,0 +1,171 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want map[string]interface{}
+	}{
+		{
+			name: "values",
+			in: `
+s = "text"
+literal = 'C:\path'
+i = 42
+big = 1_000
+hex = 0x1f
+f = 1.5
+yes = true
+no = false
+`,
+			want: map[string]interface{}{
+				"s": "text", "literal": `C:\path`, "i": int64(42), "big": int64(1000), "hex": int64(31),
+				"f": 1.5, "yes": true, "no": false,
+			},
+		},
+		{
+			name: "string escapes",
+			in:   `s = "tab\tquote\"back\\slash\nline \u00e9 \U0001F6AA"`,
+			want: map[string]interface{}{"s": "tab\tquote\"back\\slash\nline é 🚪"},
+		},
+		{
+			name: "comments",
+			in: `
+# a comment line
+a = "x # not a comment" # a comment
+b = 'y # not either'    # another one
+c = 1#no space
+`,
+			want: map[string]interface{}{"a": "x # not a comment", "b": "y # not either", "c": int64(1)},
+		},
+		{
+			name: "brackets in strings",
+			in: `
+a = "[not an array"
+b = "x]"
+`,
+			want: map[string]interface{}{"a": "[not an array", "b": "x]"},
+		},
+		{
+			name: "arrays",
+			in: `
+empty = []
+ints = [1, 2, 3]
+strings = ["a", 'b', "c,d"]
+nested = [[1, 2], ["x"]]
+multiline = [
+  "one",   # first
+  "two",
+]
+`,
+			want: map[string]interface{}{
+				"empty":     []interface{}(nil),
+				"ints":      []interface{}{int64(1), int64(2), int64(3)},
+				"strings":   []interface{}{"a", "b", "c,d"},
+				"nested":    []interface{}{[]interface{}{int64(1), int64(2)}, []interface{}{"x"}},
+				"multiline": []interface{}{"one", "two"},
+			},
+		},
+		{
+			name: "tables and dotted keys",
+			in: `
+top = 1
+[server]
+port = 80
+tls.cert = "cert.pem"
+[server.limits]
+max = 5
+["quoted.name"]
+x = true
+`,
+			want: map[string]interface{}{
+				"top": int64(1),
+				"server": map[string]interface{}{
+					"port":   int64(80),
+					"tls":    map[string]interface{}{"cert": "cert.pem"},
+					"limits": map[string]interface{}{"max": int64(5)},
+				},
+				"quoted.name": map[string]interface{}{"x": true},
+			},
+		},
+		{
+			name: "arrays of tables",
+			in: `
+[[device]]
+name = "door"
+[[device]]
+name = "light"
+[device.extra]
+watts = 60
+[[interlock]]
+devices = ["door", "light"]
+`,
+			want: map[string]interface{}{
+				"device": []map[string]interface{}{
+					{"name": "door"},
+					{"name": "light", "extra": map[string]interface{}{"watts": int64(60)}},
+				},
+				"interlock": []map[string]interface{}{
+					{"devices": []interface{}{"door", "light"}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got  %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		// err is the start of the error with the line number
+		err string
+	}{
+		{"duplicate key", "a = 1\nb = 2\na = 3", "line 3: duplicate key:a"},
+		{"duplicate key in a table", "[t]\na = 1\n\na = 2", "line 4: duplicate key:a"},
+		{"duplicate dotted key", "a.b = 1\na.b = 2", "line 2: duplicate key:b"},
+		{"duplicate table", "[t]\na = 1\n[t]\nb = 2", "line 3: duplicate table:t"},
+		{"value redefined as a table", "a = 1\n[a]", "line 2: key a is already defined as a value"},
+		{"table redefined as an array of tables", "[a]\n[[a]]", "line 2: key a is already defined and is not an array of tables"},
+		{"missing equals", "\n\njust a key", "line 3: expected key = value"},
+		{"missing value", "a =", "line 1: missing value"},
+		{"empty key", " = 1", "line 1: invalid key"},
+		{"empty dotted key part", "a..b = 1", "line 1: invalid key"},
+		{"unterminated string", `a = "open`, "line 1: unterminated string"},
+		{"unterminated literal string", `a = 'open`, "line 1: unterminated string"},
+		{"invalid escape", `a = "\q"`, "line 1: "},
+		{"text after the value", `a = "x" y`, "line 1: unexpected text after the value"},
+		{"invalid value", "a = yes", "line 1: invalid value:yes"},
+		{"array without a comma", "a = [1 2]", "line 1: "},
+		{"unterminated table", "[table", "line 1: invalid table"},
+		{"unterminated array of tables", "[[table]", "line 1: invalid array of tables"},
+		{"error after a multiline array", "a = [\n1,\n2,\n]\nb = ?", "line 5: invalid value:?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTOML(strings.NewReader(tt.in))
+			if err == nil {
+				t.Fatalf("expected an error starting with %q", tt.err)
+			}
+			if !strings.HasPrefix(err.Error(), tt.err) {
+				t.Fatalf("got error %q, expected it to start with %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -1,27 +1,39 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/krasi-georgiev/rpi-web-control/config"
+	"github.com/krasi-georgiev/rpi-web-control/metrics"
+	"github.com/krasi-georgiev/rpi-web-control/rpiGpio"
 	"github.com/krasi-georgiev/rpi-web-control/server"
-	"github.com/krasi-georgiev/rpiGpio"
 
 	"github.com/coreos/go-systemd/daemon"
 	"github.com/urfave/cli"
 )
 
 var (
-	hanldeSignals = []os.Signal{syscall.SIGINT, syscall.SIGKILL}
+	hanldeSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
 	srvConfig     = server.NewConfig()
+	api           = server.NewAPI(srvConfig)
 	app           = cli.NewApp()
+	inputs        []*rpiGpio.Input
 )
 
 func main() {
@@ -38,8 +50,144 @@ func main() {
 			Usage: "port for the webserver",
 		},
 		cli.StringFlag{
-			Name:  "pp,password",
-			Usage: "required password for the web server",
+			Name:   "pp,password",
+			Usage:  "required password for the web server",
+			EnvVar: passwordEnv,
+		},
+		cli.StringFlag{
+			Name:  "gpio-root",
+			Value: rpiGpio.DefaultSysfsRoot,
+			Usage: "root of the sysfs gpio tree, point it to a fake directory for testing",
+		},
+		cli.StringFlag{
+			Name:  "gpio-backend",
+			Value: "sysfs",
+			Usage: "how to access the gpio pins: sysfs or chip(the gpio character device)",
+		},
+		cli.StringFlag{
+			Name:  "gpio-chip",
+			Value: rpiGpio.DefaultChip,
+			Usage: "the gpio character device used by the chip backend",
+		},
+		cli.StringFlag{
+			Name:  "board",
+			Usage: "the board model when it isn't detected right, for example 3b, zero-w or b-rev1",
+		},
+		cli.StringSliceFlag{
+			Name:  "allow-function",
+			Usage: "use the pins of the i2c or the uart as gpio when the bus or the serial console is disabled, can be repeated",
+		},
+		cli.StringFlag{
+			Name:  "pwm-root",
+			Value: rpiGpio.DefaultPWMRoot,
+			Usage: "the sysfs pwm chip of the hardware PWM pins, empty uses the software PWM on all pins",
+		},
+		cli.StringFlag{
+			Name:  "pwm-pins",
+			Value: "18,19",
+			Usage: "the pins of the PWM channels 0 and 1 as the pwm-2chan overlay routes them, 12,13 for pin=12 pin2=13",
+		},
+		cli.BoolFlag{
+			Name:  "simulate",
+			Usage: "run with an in-memory simulated board instead of real gpio pins",
+		},
+		cli.StringSliceFlag{
+			Name:  "input",
+			Usage: "watch an input pin - pin[:edge[:pull[:debounce]]] for example 17:both:up:50ms or phys:11:both, can be repeated",
+		},
+		cli.StringFlag{
+			Name:  "c,config",
+			Usage: "TOML config file with the named devices",
+		},
+		cli.BoolFlag{
+			Name:  "allow-raw-pins",
+			Usage: "allow controlling any pin by number, not only the devices from the config file",
+		},
+		cli.StringFlag{
+			Name:  "users",
+			Usage: "json file with the user accounts, manage it with the users command",
+		},
+		cli.DurationFlag{
+			Name:  "session-ttl",
+			Value: server.DefaultSessionTTL,
+			Usage: "how long a login is valid",
+		},
+		cli.StringFlag{
+			Name:  "audit-log",
+			Usage: "append-only file that records every actuation",
+		},
+		cli.StringSliceFlag{
+			Name:  "trusted-proxy",
+			Usage: "ip or cidr of a reverse proxy trusted to set X-Forwarded-For, can be repeated",
+		},
+		cli.StringFlag{
+			Name:  "timer-journal",
+			Usage: "file that keeps the pending timers so they are finished after a crash or a restart",
+		},
+		cli.StringFlag{
+			Name:  "schedules",
+			Usage: "json file that keeps the schedules across restarts, without it they are lost on exit",
+		},
+		cli.StringFlag{
+			Name:  "metrics-addr",
+			Usage: "serve /metrics on a separate address like 127.0.0.1:9110 instead of the web server port",
+		},
+		cli.StringFlag{
+			Name:  "admin-socket",
+			Usage: "unix socket like /run/rpi-web-control/admin.sock that serves everything as an admin without a password",
+		},
+	}
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "users",
+			Usage: "manage the user accounts in the --users file",
+			Subcommands: []cli.Command{
+				{
+					Name:      "add",
+					Usage:     "add a user or change the password and the role of an existing one",
+					ArgsUsage: "name",
+					Action:    usersAdd,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "role",
+							Value: config.Guest,
+							Usage: "guest, host or admin",
+						},
+					},
+				},
+				{
+					Name:      "remove",
+					Usage:     "revoke a user",
+					ArgsUsage: "name",
+					Action:    usersRemove,
+				},
+				{
+					Name:   "list",
+					Usage:  "list the users",
+					Action: usersList,
+				},
+			},
+		},
+		{
+			Name:   "units",
+			Usage:  "write the systemd service and socket units that start the command before units",
+			Action: units,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "dir",
+					Usage: "directory for the units like /etc/systemd/system, without it they are printed",
+				},
+				cli.StringFlag{
+					Name:  "user",
+					Usage: "run the service as this user instead of root, systemd binds the sockets",
+				},
+				cli.StringFlag{
+					Name:  "env-file",
+					Value: "/etc/default/" + unitName,
+					Usage: "file for the password, only root can read it, the unit files can be read by everybody",
+				},
+			},
 		},
 	}
 
@@ -51,26 +199,136 @@ func main() {
 
 		var err error
 
+		// the pins of the config file are checked against the board
+		if err = rpiGpio.SetBoard(c.String("board")); err != nil {
+			fmt.Println("Incorrect Usage!")
+			cli.ShowCommandHelp(c, "")
+			return err
+		}
+		log.Printf("Running on a %v", rpiGpio.CurrentBoard().Model)
+		if err = rpiGpio.AllowFunctions(c.StringSlice("allow-function")...); err != nil {
+			return err
+		}
+
 		if err = srvConfig.SetPort(c); err != nil {
 			fmt.Println("Incorrect Usage!")
 			cli.ShowCommandHelp(c, "")
 			return err
 		}
+		if err = srvConfig.SetUsers(c); err != nil {
+			return err
+		}
 		if err = srvConfig.SetPass(c); err != nil {
 			fmt.Println("Incorrect Usage!")
 			cli.ShowCommandHelp(c, "")
 			return err
 		}
+		// the backend resolves the gpio line names of the devices
+		if rpiGpio.DefaultBackend, err = newBackend(c); err != nil {
+			fmt.Println("Incorrect Usage!")
+			cli.ShowCommandHelp(c, "")
+			return err
+		}
+		if err = srvConfig.SetDevices(c); err != nil {
+			return err
+		}
+		// the inputs are checked against the backend before any pin is switched, they start later
+		for _, spec := range c.StringSlice("input") {
+			in, err := newInput(spec)
+			if err != nil {
+				fmt.Println("Incorrect Usage!")
+				cli.ShowCommandHelp(c, "")
+				return err
+			}
+			inputs = append(inputs, in)
+		}
+		if err = srvConfig.SetAudit(c); err != nil {
+			return err
+		}
+		rpiGpio.PWMRoot = c.String("pwm-root")
+		if err := rpiGpio.SetPWMPins(strings.Split(c.String("pwm-pins"), ",")...); err != nil {
+			return err
+		}
+		if c.Bool("simulate") {
+			rpiGpio.PWMRoot = ""
+		}
+
+		// before the journal and the safe state can switch the pins
+		api.RecordSafety()
+		if c.String("timer-journal") != "" {
+			if err := rpiGpio.OpenJournal(c.String("timer-journal")); err != nil {
+				return err
+			}
+		}
+		// a crash or a power loss could have left a relay on
+		api.SafeState()
+
+		if err := api.OpenSchedules(c.String("schedules")); err != nil {
+			return err
+		}
+
+		for _, in := range inputs {
+			if err := in.Start(); err != nil {
+				return fmt.Errorf("Couldn't start input pin %v:%v", in.Pin(), err)
+			}
+			go logInput(in)
+		}
+
+		listeners, err := listen(c)
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{}
 
-		srv := &http.Server{Addr: ":" + srvConfig.Port}
+		http.Handle("/control", server.Instrument("control", http.HandlerFunc(control)))
+		http.Handle("/login", server.Instrument("login", http.HandlerFunc(srvConfig.Login)))
+		http.Handle("/logout", server.Instrument("logout", http.HandlerFunc(srvConfig.Logout)))
+		http.Handle(server.APIPrefix, server.Instrument("api", api))
+		http.HandleFunc("/events", authenticated(api.Events))
+		http.HandleFunc("/ws", authenticated(api.WebSocket))
+		http.Handle("/audit", server.Instrument("audit", http.HandlerFunc(auditPage)))
+		http.Handle("/schedules", server.Instrument("schedules", http.HandlerFunc(schedulesPage)))
+		http.Handle("/", server.Instrument("home", http.HandlerFunc(home)))
+		if sim, ok := rpiGpio.DefaultBackend.(*rpiGpio.Sim); ok {
+			log.Print("Running with a simulated board, no gpio pins will be changed")
+			http.HandleFunc("/simulate", simulate(sim))
+		}
 
-		http.HandleFunc("/control", control)
-		http.HandleFunc("/", home)
+		metrics.NewGaugeFunc("rpi_build_info", "The version of the controller", func() []metrics.Sample {
+			return []metrics.Sample{{Labels: []string{app.Version, runtime.Version()}, Value: 1}}
+		}, "version", "goversion")
+		var metricsSrv *http.Server
+		if l := listeners[socketMetrics]; l != nil {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			metricsSrv = &http.Server{Handler: mux}
+			go func() {
+				log.Print("Started metrics server on ", l.Addr())
+				if err := metricsSrv.Serve(l); err != http.ErrServerClosed {
+					log.Printf("Metrics server: Serve() error: %s", err)
+					os.Exit(1)
+				}
+			}()
+		} else {
+			http.Handle("/metrics", metrics.Handler())
+		}
+
+		var adminSrv *http.Server
+		if l := listeners[socketAdmin]; l != nil {
+			adminSrv = &http.Server{Handler: admin(http.DefaultServeMux)}
+			go func() {
+				log.Print("Started admin server on ", l.Addr())
+				if err := adminSrv.Serve(l); err != http.ErrServerClosed {
+					log.Printf("Admin server: Serve() error: %s", err)
+					os.Exit(1)
+				}
+			}()
+		}
 
 		go func() {
-			log.Print("Started web server on port ", srvConfig.Port)
-			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-				log.Printf("Httpserver: ListenAndServe() error: %s", err)
+			log.Print("Started web server on ", listeners[socketHTTP].Addr())
+			if err := srv.Serve(listeners[socketHTTP]); err != http.ErrServerClosed {
+				log.Printf("Httpserver: Serve() error: %s", err)
 				os.Exit(1)
 			}
 		}()
@@ -82,18 +340,20 @@ func main() {
 				log.Print("Watchdog not enabled for this service!")
 				return
 			}
+			// the web server can listen on a socket passed by systemd so it is checked through its listener
+			client := localClient(listeners[socketHTTP])
 			for {
 				time.Sleep(interval / 3)
-				res, err := http.Get("http://127.0.0.1:" + srvConfig.Port)
+				res, err := client.Get("http://localhost/")
 				if err == nil {
 					daemon.SdNotify(false, "WATCHDOG=1")
+					res.Body.Close()
 				} else {
 					log.Printf("RPi Controller watchdog error: %v", err)
 				}
-				res.Body.Close()
 			}
 		}()
-		return shutdown(quit, srv)
+		return shutdown(quit, rpiGpio.DefaultBackend, srv, metricsSrv, adminSrv)
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -102,16 +362,18 @@ func main() {
 	}
 }
 
+// control is the legacy endpoint kept for the existing bookmarks, it runs the action through the api
 func control(w http.ResponseWriter, r *http.Request) {
 	u, _ := url.Parse(r.RequestURI)
 	v := u.Query()
 
-	if err := srvConfig.Authenticate(v); err != nil {
+	id, err := srvConfig.Identify(r)
+	if err != nil {
 		fmt.Fprint(w, err.Error())
 		return
 	}
 
-	var ctype, delay, pin string
+	var ctype, delay, pin, conflict, sequence string
 	if d, ok := v["type"]; ok {
 		ctype = d[0]
 	}
@@ -124,33 +386,159 @@ func control(w http.ResponseWriter, r *http.Request) {
 		pin = d[0]
 	}
 
-	t, err := rpiGpio.NewControl(rpiGpio.SetType(ctype), rpiGpio.SetDelay(delay), rpiGpio.SetPin(pin))
-	if err != nil {
-		log.Printf(err.Error())
-		fmt.Fprint(w, err)
-		return
+	if d, ok := v["conflict"]; ok {
+		conflict = d[0]
+	}
+
+	if d, ok := v["sequence"]; ok {
+		sequence = d[0]
 	}
 
-	if err := t.Run(); err != nil {
+	if _, err := api.Run(id, server.Action{Type: ctype, Delay: delay, Pin: pin, Conflict: conflict, Sequence: sequence}); err != nil {
+		if rpiGpio.ErrorCode(err) == server.CodeForbidden {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if rpiGpio.ErrorCode(err) != rpiGpio.CodeGPIO {
+			log.Print(err)
+			fmt.Fprint(w, err)
+			return
+		}
 		r := fmt.Sprintf("Huston we have a problem : %v", err)
-		log.Printf(r)
+		log.Print(r)
 		fmt.Fprint(w, r)
 	} else {
 		fmt.Fprint(w, "done")
 	}
 }
 
-func shutdown(quit chan os.Signal, srv *http.Server) error {
+// newBackend creates the gpio backend selected with the command line flags
+func newBackend(c *cli.Context) (rpiGpio.Backend, error) {
+	if c.Bool("simulate") {
+		return rpiGpio.NewSim(), nil
+	}
+	switch c.String("gpio-backend") {
+	case "sysfs":
+		return rpiGpio.NewSysfs(c.String("gpio-root")), nil
+	case "chip":
+		return rpiGpio.NewChip(c.String("gpio-chip"))
+	}
+	return nil, errors.New("Invalid gpio backend:" + c.String("gpio-backend") + ", use sysfs or chip")
+}
+
+// authenticated allows only requests from a logged in user or with the correct password
+func authenticated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := srvConfig.Identify(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h(w, server.WithIdentity(r, id))
+	}
+}
+
+// newInput creates an input from its command line spec - pin[:edge[:pull[:debounce]]]
+func newInput(spec string) (*rpiGpio.Input, error) {
+	p := strings.Split(spec, ":")
+	// the pin can have a numbering prefix like phys:11
+	switch p[0] {
+	case rpiGpio.NumberingBCM, rpiGpio.NumberingPhys, rpiGpio.NumberingWPi:
+		if len(p) > 1 {
+			p = append([]string{p[0] + ":" + p[1]}, p[2:]...)
+		}
+	}
+	if len(p) > 4 {
+		return nil, errors.New("Invalid input:" + spec + ", use pin[:edge[:pull[:debounce]]]")
+	}
+	p = append(p, make([]string, 4-len(p))...)
+	return rpiGpio.NewInput(p[0], rpiGpio.SetEdge(p[1]), rpiGpio.SetPull(p[2]), rpiGpio.SetDebounce(p[3]))
+}
+
+func logInput(in *rpiGpio.Input) {
+	events, _ := in.Subscribe()
+	for e := range events {
+		log.Printf("Input pin %v %v", e.Pin, e.Edge)
+	}
+}
+
+// simulate shows the simulated board with GET and injects input levels with POST so scripts can drive simulated sensors
+// curl -X POST "http://localhost/simulate?pass=password&pin=17&value=1"
+func simulate(sim *rpiGpio.Sim) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query()
+		if _, err := srvConfig.Identify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(struct {
+				Pins    []rpiGpio.SimPin     `json:"pins"`
+				History []rpiGpio.Transition `json:"history"`
+			}{sim.Pins(), sim.History()})
+		case http.MethodPost:
+			value, err := strconv.Atoi(v.Get("value"))
+			if err != nil || (value != 0 && value != 1) {
+				http.Error(w, "Invalid value:"+v.Get("value")+", use 0 or 1", http.StatusBadRequest)
+				return
+			}
+			if err := sim.Inject(v.Get("pin"), value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, "done")
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func shutdown(quit chan os.Signal, backend rpiGpio.Backend, servers ...*http.Server) error {
 	log.Print("Received signal: ", <-quit)
 
-	if err := srv.Shutdown(context.Background()); err != nil {
-		return err
+	server.CloseStreams()
+	// the optional metrics and admin servers are nil when they aren't used
+	for _, srv := range servers {
+		if srv == nil {
+			continue
+		}
+		if err := srv.Shutdown(context.Background()); err != nil {
+			return err
+		}
+	}
+	for _, in := range inputs {
+		in.Close()
+	}
+	api.StopSchedules()
+	rpiGpio.FinishTimers()
+	rpiGpio.StopPWM()
+	api.SafeState()
+	// release the pins held by backends like the gpio character device
+	if c, ok := backend.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("Couldn't release the gpio pins:%v", err)
+		}
+	}
+	if err := srvConfig.Audit().Close(); err != nil {
+		log.Printf("Couldn't close the audit log:%v", err)
 	}
 	log.Print("gracefull shutdown!")
 	return nil
 }
 
 func home(w http.ResponseWriter, r *http.Request) {
+	var id *server.Identity
+	if srvConfig.UsesSessions() {
+		var err error
+		if id, err = srvConfig.Identify(r); err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+	}
 	fmt.Fprintf(w, `
 		<html lang='en'>
 		<head>
@@ -165,6 +553,16 @@ func home(w http.ResponseWriter, r *http.Request) {
 					}
 				body {font-size: 20px;font-family: Arial;}
 				input,select {padding: 10px;font-size: 14px;width:100%%; margin:10px 0px}
+				#devices button {
+						cursor: pointer;
+						width: 100%%;
+						padding: 15px 10px;
+						margin: 5px 0px;
+						font-size: 24px;
+						color: #fff;
+						border: 0px;
+						background-color: #5c9fcd;
+				}
 
 				input[type=submit] {
 						cursor: pointer;
@@ -180,6 +578,28 @@ func home(w http.ResponseWriter, r *http.Request) {
 						font-weight:bold;
 						text-align:center;
 				}
+				#user {
+					width: 80%%;
+					margin: 0 auto;
+					max-width: 400px;
+					text-align: right;
+					font-size: 14px;
+				}
+				#user button {cursor: pointer; font-size: 14px;}
+				#pins {
+						width: 80%%;
+						margin: 20px auto;
+						max-width: 400px;
+						border-collapse: collapse;
+				}
+				#pins td, #pins th, #jobs td, #jobs th {padding: 5px; border-bottom: 1px solid #ddd; text-align:left;}
+				#jobs {
+						width: 80%%;
+						margin: 20px auto;
+						max-width: 400px;
+						border-collapse: collapse;
+				}
+				.on {color: #fff; background-color: #6b963c;}
 				#loaderWrapper {
 					width:30px;
 					margin:0 auto;
@@ -201,27 +621,43 @@ func home(w http.ResponseWriter, r *http.Request) {
 		</head>
 
 		<body>
+		%v
 		<form id="controllerForm">
-		<fieldset>
+		%v
+		<div id="devices">%v</div>
+		<fieldset %v>
 			<legend>Control Options</legend>
 			<select id="type">
 				<option value="timer">timer</option>
 				<option value="toggle">toggle</option>
+				<option value="sequence">sequence</option>
 			</select>
-			<input type="password" id="pass" placeholder="password" />
-			<input type="text" id="pin" placeholder="Pin (optional, default is %v)" >
+			<input type="text" id="pin" placeholder="Pin like 18, phys:12 or wpi:1 (optional, default is %v)" >
+			<input type="text" id="sequence" placeholder="Sequence like (on 100ms off 100ms)x3">
 			<input type="text" id="delay" placeholder="Delay (optional, default is %v)">
+			<select id="conflict" title="when the pin already has a timer">
+				<option value="restart">restart a running timer</option>
+				<option value="extend">extend a running timer</option>
+				<option value="reject">reject if a timer is running</option>
+				<option value="queue">queue after a running timer</option>
+			</select>
 			<input type="submit" value="GO">
 		</fieldset>
 		</form>
 		<div id="loaderWrapper"></div>
 		<div id="result"></div>
+		<table id="pins"></table>
+		<table id="jobs"></table>
 
 		<script type="text/javascript">
 
-		var pass = getCookie("pass");
-		if (pass != "") {
-				document.getElementById("pass").value = pass;
+		// logged in users send the session cookie instead of the password
+		var usePass = document.getElementById("pass") != null;
+		if (usePass) {
+			var pass = getCookie("pass");
+			if (pass != "") {
+					document.getElementById("pass").value = pass;
+			}
 		}
 		var pin = getCookie("pin");
 		if (pin != "") {
@@ -232,6 +668,131 @@ func home(w http.ResponseWriter, r *http.Request) {
 				document.getElementById("delay").value = delay;
 		}
 
+		// pinLabels show every number of the gpio pins
+		var pinLabels = %v;
+
+		function pinLabel(pin) {
+			return pinLabels[pin] || pin;
+		}
+
+		// live pin states from the /events stream
+		var states = {};
+		var source;
+
+		function updateState(e) {
+			if (e.type == "job") {
+				loadJobs();
+				return;
+			}
+			if (e.type == "limit" || e.type == "forced-off") {
+				document.getElementById("result").textContent = e.reason;
+				return;
+			}
+			var s = states[e.pin] || {};
+			switch (e.type) {
+				case "state":
+					s = {value: e.value, deadline: e.deadline, duty: e.duty};
+					break;
+				case "output":
+					delete s.duty;
+					s.value = e.value;
+					break;
+				case "edge":
+					s.value = e.value;
+					break;
+				case "pwm":
+					s.duty = e.duty || 0;
+					break;
+				case "timer-start":
+					s.deadline = e.deadline;
+					break;
+				case "timer-end":
+					delete s.deadline;
+					break;
+			}
+			states[e.pin] = s;
+			renderStates();
+			if (e.job) {
+				loadJobs();
+			}
+		}
+
+		// request calls the json api with the password when not logged in with a session
+		function request(method, path, done) {
+			var xhttp = new XMLHttpRequest();
+			xhttp.open(method, "/api/v1/" + path, true);
+			if (usePass) {
+				xhttp.setRequestHeader("Authorization", "Bearer " + document.getElementById("pass").value);
+			}
+			xhttp.onload = function() { done(xhttp.status, JSON.parse(xhttp.responseText)); };
+			xhttp.send();
+		}
+
+		// loadJobs shows the running and queued timers with a button to cancel them
+		function loadJobs() {
+			request("GET", "jobs", function(status, jobs) {
+				if (status != 200) {
+					return;
+				}
+				var rows = "";
+				jobs.forEach(function(j) {
+					if (j.state != "active" && j.state != "pending") {
+						return;
+					}
+					rows += "<tr><td>" + pinLabel(j.pin) + "</td><td>" + j.state + (j.steps ? " step " + j.step + "/" + j.steps : "") + "</td>" +
+						"<td>" + (j.deadline ? "until " + new Date(j.deadline).toLocaleTimeString() : "") + "</td>" +
+						"<td><button type='button' onclick='cancelJob(\"" + j.id + "\")'>cancel</button></td></tr>";
+				});
+				document.getElementById("jobs").innerHTML = rows == "" ? "" : "<tr><th>pin</th><th>job</th><th>timer</th><th></th></tr>" + rows;
+			});
+		}
+
+		function cancelJob(id) {
+			request("POST", "jobs/" + id + "/cancel", function(status, res) {
+				document.getElementById("result").innerHTML = status == 200 ? "cancelled" : res.error.message;
+				loadJobs();
+			});
+		}
+
+		function renderStates() {
+			var rows = "<tr><th>pin</th><th>level</th><th>timer</th></tr>";
+			Object.keys(states).sort(function(a, b) { return a - b; }).forEach(function(pin) {
+				var s = states[pin];
+				var level = s.value == 1 ? "on" : "off";
+				if (s.duty !== undefined) {
+					level = s.duty > 0 ? "on" : "off";
+				}
+				rows += "<tr><td>" + pinLabel(pin) + "</td>" +
+					"<td class='" + level + "'>" + (s.duty !== undefined ? "pwm " + Math.round(s.duty) + "%%" : level) + "</td>" +
+					"<td>" + (s.deadline ? "until " + new Date(s.deadline).toLocaleTimeString() : "") + "</td></tr>";
+			});
+			document.getElementById("pins").innerHTML = rows;
+		}
+
+		function connect() {
+			if (!window.EventSource) {
+				return;
+			}
+			var url = "/events";
+			if (usePass) {
+				var pass = document.getElementById("pass").value;
+				if (pass == "") {
+					return;
+				}
+				url += "?pass=" + encodeURIComponent(pass);
+			}
+			if (source) {
+				source.close();
+			}
+			states = {};
+			source = new EventSource(url);
+			["state", "output", "edge", "timer-start", "timer-end", "pwm", "limit", "forced-off", "job"].forEach(function(t) {
+				source.addEventListener(t, function(m) { updateState(JSON.parse(m.data)); });
+			});
+			loadJobs();
+		}
+		connect();
+
 		var controllerForm = document.forms["controllerForm"];
 
 		controllerForm.onsubmit = function(event){
@@ -239,17 +800,38 @@ func home(w http.ResponseWriter, r *http.Request) {
 
 			var today = new Date();
 			today.setMonth(today.getMonth()+12);
-			document.cookie = "pass="+document.getElementById("pass").value + ';expires=' + today.toGMTString();
 			document.cookie = "pin="+document.getElementById("pin").value + ';expires=' + today.toGMTString();
 			document.cookie = "delay="+document.getElementById("delay").value + ';expires=' + today.toGMTString();
 
-			var pass="pass="+document.getElementById("pass").value;
-			var type="&type="+document.getElementById("type").value;
+			var type="type="+document.getElementById("type").value;
 			var pin="&pin="+document.getElementById("pin").value;
 			var delay="&delay="+document.getElementById("delay").value;
+			var conflict="&conflict="+document.getElementById("conflict").value;
+			var sequence="&sequence="+encodeURIComponent(document.getElementById("sequence").value);
+			send(type+pin+delay+conflict+sequence);
+		}
+
+		// the device buttons use the device settings from the config file
+		Array.prototype.forEach.call(document.querySelectorAll("#devices button"), function(b) {
+			b.onclick = function() {
+				send("pin=" + encodeURIComponent(b.getAttribute("data-device")));
+			};
+		});
+
+		function send(params) {
+			if (usePass) {
+				var today = new Date();
+				today.setMonth(today.getMonth()+12);
+				document.cookie = "pass="+document.getElementById("pass").value + ';expires=' + today.toGMTString();
+				params = "pass="+encodeURIComponent(document.getElementById("pass").value)+"&"+params;
+			}
+
+			if (!source || source.readyState == 2) {
+				connect();
+			}
 
 			var xhttp = new XMLHttpRequest();
-			xhttp.open("GET","/control?"+pass+type+pin+delay,true);
+			xhttp.open("GET","/control?"+params,true);
 
 			document.getElementById("result").innerHTML = "";
 			document.getElementById("loaderWrapper").classList.add('loader');
@@ -257,7 +839,7 @@ func home(w http.ResponseWriter, r *http.Request) {
 			xhttp.onload = function() {
 				document.getElementById("loaderWrapper").classList.remove('loader');
 
-				if (xhttp.status == 200) {
+				if (xhttp.status == 200 || xhttp.status == 403) {
 						document.getElementById("result").innerHTML = this.responseText;
 				}
 				else{
@@ -299,5 +881,325 @@ func home(w http.ResponseWriter, r *http.Request) {
 
 		</body>
 		</html>
-		`, rpiGpio.DefaultPin, rpiGpio.DefaultDelay)
+		`, userBar(id), passInput(id), deviceButtons(id), rawControls(id), rpiGpio.DefaultPin, rpiGpio.DefaultDelay, pinLabels())
+}
+
+// userBar shows the logged in user with a logout button
+func userBar(id *server.Identity) string {
+	if id == nil || id.Method != server.MethodSession {
+		return `<div id="user"><a href="/schedules">schedules</a> <a href="/audit">audit log</a></div>`
+	}
+	var audit string
+	if config.HasRole(id.Role, config.Admin) {
+		audit = `<a href="/audit">audit log</a> `
+	}
+	return fmt.Sprintf(`<form id="user" method="post" action="/logout"><a href="/schedules">schedules</a> %v%v <button type="submit">logout</button></form>`, audit, html.EscapeString(id.Name))
+}
+
+// passInput is the field for the shared password, not needed when logged in with a session
+func passInput(id *server.Identity) string {
+	if id != nil && id.Method == server.MethodSession {
+		return ""
+	}
+	return `<input type="password" id="pass" placeholder="password" />`
+}
+
+// deviceButtons renders a button for each device from the config file that the user can use,
+// without a login the password isn't known yet so all are shown
+func deviceButtons(id *server.Identity) string {
+	var b bytes.Buffer
+	for _, d := range api.Devices(id) {
+		if id != nil && !d.Allowed {
+			continue
+		}
+		fmt.Fprintf(&b, `<button type="button" data-device="%v" title="pin %v">%v %v</button>`,
+			html.EscapeString(d.Name), html.EscapeString(pinLabel(d.Pin)), html.EscapeString(d.Icon), html.EscapeString(d.Label))
+	}
+	return b.String()
+}
+
+// pinLabel shows the gpio number of the pin with its header and wiringPi numbers like 18 (phys 12, wpi 1)
+func pinLabel(pin string) string {
+	n, ok := rpiGpio.PinNumbers(pin)
+	if !ok {
+		return pin
+	}
+	var other []string
+	if n.Phys != 0 {
+		other = append(other, fmt.Sprintf("phys %v", n.Phys))
+	}
+	if n.WPi != nil {
+		other = append(other, fmt.Sprintf("wpi %v", *n.WPi))
+	}
+	if len(other) == 0 {
+		return pin
+	}
+	return fmt.Sprintf("%v (%v)", pin, strings.Join(other, ", "))
+}
+
+// pinLabels are the labels of all pins of the board for the javascript of the home page
+func pinLabels() string {
+	labels := make(map[string]string)
+	for _, p := range rpiGpio.CurrentBoard().Pins {
+		pin := strconv.Itoa(p.GPIO)
+		labels[pin] = pinLabel(pin)
+	}
+	b, _ := json.Marshal(labels)
+	return string(b)
+}
+
+// rawControls hides the controls for raw pins when only the devices from the config file are allowed
+// or the user isn't an admin
+func rawControls(id *server.Identity) string {
+	if srvConfig.AllowRaw() && (id == nil || config.HasRole(id.Role, config.Admin)) {
+		return ""
+	}
+	return `style="display:none"`
+}
+
+// auditPage queries the audit log through the api
+func auditPage(w http.ResponseWriter, r *http.Request) {
+	if srvConfig.UsesSessions() {
+		if _, err := srvConfig.Identify(r); err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+	}
+	fmt.Fprint(w, `
+		<html lang='en'>
+		<head>
+				<meta name='viewport' content='width=device-width, initial-scale=1, maximum-scale=1'>
+				<title>RPi Web controller - audit log</title>
+				<style>
+				body {font-size: 16px;font-family: Arial;}
+				form {margin: 10px auto; max-width: 900px;}
+				input {padding: 5px;font-size: 14px; margin:5px 0px}
+				table {margin: 10px auto; max-width: 900px; width: 100%; border-collapse: collapse;}
+				td, th {padding: 5px; border-bottom: 1px solid #ddd; text-align:left; font-size: 14px;}
+				.denied, .failed {color: #c00;}
+				#result {font-weight:bold; text-align:center;}
+				</style>
+		</head>
+		<body>
+		<form id="filter">
+			<a href="/">back</a>
+			<input type="datetime-local" id="from" title="from">
+			<input type="datetime-local" id="to" title="to">
+			<input type="text" id="user" placeholder="user">
+			<input type="text" id="device" placeholder="device or pin">
+			<input type="submit" value="search">
+			<a id="csv" href="#">csv</a>
+			<a id="json" href="#">json</a>
+		</form>
+		<div id="result"></div>
+		<table id="entries"></table>
+
+		<script type="text/javascript">
+		// the password saved by the home page when not logged in with a session
+		var pass = (document.cookie.match(/(?:^|; )pass=([^;]*)/) || [])[1];
+
+		function query(format) {
+			var q = ["format=" + format];
+			["from", "to", "user", "device"].forEach(function(f) {
+				var v = document.getElementById(f).value;
+				if (v != "") {
+					q.push(f + "=" + encodeURIComponent(v));
+				}
+			});
+			if (pass) {
+				q.push("pass=" + encodeURIComponent(decodeURIComponent(pass)));
+			}
+			return "/api/v1/audit?" + q.join("&");
+		}
+
+		function esc(s) {
+			var d = document.createElement("div");
+			d.textContent = s == null ? "" : s;
+			return d.innerHTML;
+		}
+
+		function search() {
+			document.getElementById("csv").href = query("csv");
+			document.getElementById("json").href = query("json");
+
+			var xhttp = new XMLHttpRequest();
+			xhttp.open("GET", query("json") + "&limit=500", true);
+			xhttp.onload = function() {
+				if (xhttp.status != 200) {
+					document.getElementById("result").innerHTML = esc(JSON.parse(xhttp.responseText).error.message);
+					document.getElementById("entries").innerHTML = "";
+					return;
+				}
+				document.getElementById("result").innerHTML = "";
+				var rows = "<tr><th>time</th><th>user</th><th>ip</th><th>device</th><th>pin</th><th>action</th><th>params</th><th>result</th></tr>";
+				JSON.parse(xhttp.responseText).forEach(function(e) {
+					var params = Object.keys(e.params || {}).map(function(k) { return k + "=" + e.params[k]; }).join(" ");
+					rows += "<tr><td>" + new Date(e.time).toLocaleString() + "</td><td>" + esc(e.user) + "</td><td>" + esc(e.ip) +
+						"</td><td>" + esc(e.device) + "</td><td>" + esc(e.pin) + "</td><td>" + esc(e.action) + "</td><td>" + esc(params) +
+						"</td><td class='" + esc(e.result) + "' title='" + esc(e.error) + "'>" + esc(e.result) + "</td></tr>";
+				});
+				document.getElementById("entries").innerHTML = rows;
+			};
+			xhttp.send();
+		}
+
+		document.forms["filter"].onsubmit = function(event) {
+			event.preventDefault();
+			search();
+		};
+		search();
+		</script>
+		</body>
+		</html>
+		`)
+}
+
+// schedulesPage lists, adds, pauses and deletes the schedules through the api
+func schedulesPage(w http.ResponseWriter, r *http.Request) {
+	var id *server.Identity
+	if srvConfig.UsesSessions() {
+		var err error
+		if id, err = srvConfig.Identify(r); err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+	}
+	var devices bytes.Buffer
+	for _, d := range api.Devices(id) {
+		if id != nil && !d.Allowed {
+			continue
+		}
+		fmt.Fprintf(&devices, `<option value="%v">%v</option>`, html.EscapeString(d.Name), html.EscapeString(d.Label))
+	}
+	fmt.Fprintf(w, `
+		<html lang='en'>
+		<head>
+				<meta name='viewport' content='width=device-width, initial-scale=1, maximum-scale=1'>
+				<title>RPi Web controller - schedules</title>
+				<style>
+				body {font-size: 16px;font-family: Arial;}
+				form {margin: 10px auto; max-width: 900px;}
+				input,select {padding: 5px;font-size: 14px; margin:5px 0px}
+				table {margin: 10px auto; max-width: 900px; width: 100%%; border-collapse: collapse;}
+				td, th {padding: 5px; border-bottom: 1px solid #ddd; text-align:left; font-size: 14px;}
+				.failed {color: #c00;}
+				.paused {color: #999;}
+				#result {font-weight:bold; text-align:center;}
+				</style>
+		</head>
+		<body>
+		<form id="add">
+			<a href="/">back</a>
+			<input type="text" id="name" placeholder="name">
+			<input type="text" id="device" list="devices" placeholder="device">
+			<datalist id="devices">%v</datalist>
+			<select id="action">
+				<option value="">device default</option>
+				<option value="on">on</option>
+				<option value="off">off</option>
+				<option value="toggle">toggle</option>
+				<option value="pulse">pulse</option>
+			</select>
+			<input type="text" id="delay" placeholder="pulse delay">
+			<br>
+			<input type="text" id="cron" placeholder="cron like 0 18 * * mon-fri">
+			or <input type="datetime-local" id="at" title="once at">
+			or <select id="sun">
+				<option value="">sun event</option>
+				<option value="sunrise">sunrise</option>
+				<option value="sunset">sunset</option>
+				<option value="civil-dawn">civil dawn</option>
+				<option value="civil-dusk">civil dusk</option>
+				<option value="nautical-dawn">nautical dawn</option>
+				<option value="nautical-dusk">nautical dusk</option>
+			</select>
+			<input type="text" id="offset" placeholder="offset like 30m or -15m">
+			<input type="text" id="timezone" placeholder="time zone like Europe/Sofia, default %v">
+			<select id="missed" title="runs missed while the controller was down">
+				<option value="skip">skip missed runs</option>
+				<option value="run-once">run missed runs once</option>
+			</select>
+			<input type="submit" value="add">
+		</form>
+		<div id="result"></div>
+		<table id="schedules"></table>
+
+		<script type="text/javascript">
+		// the password saved by the home page when not logged in with a session
+		var pass = (document.cookie.match(/(?:^|; )pass=([^;]*)/) || [])[1];
+
+		function request(method, path, body, done) {
+			var xhttp = new XMLHttpRequest();
+			xhttp.open(method, "/api/v1/" + path, true);
+			if (pass) {
+				xhttp.setRequestHeader("Authorization", "Bearer " + decodeURIComponent(pass));
+			}
+			xhttp.onload = function() {
+				var res = JSON.parse(xhttp.responseText);
+				if (xhttp.status != 200) {
+					document.getElementById("result").innerHTML = esc(res.error.message);
+					return;
+				}
+				document.getElementById("result").innerHTML = "";
+				done(res);
+			};
+			xhttp.send(body ? JSON.stringify(body) : null);
+		}
+
+		function esc(s) {
+			var d = document.createElement("div");
+			d.textContent = s == null ? "" : s;
+			return d.innerHTML;
+		}
+
+		function time(t) {
+			return t ? new Date(t).toLocaleString() : "";
+		}
+
+		function load() {
+			request("GET", "schedules", null, function(schedules) {
+				var rows = "<tr><th>name</th><th>device</th><th>action</th><th>when</th><th>next run</th><th>last run</th><th></th></tr>";
+				schedules.forEach(function(s) {
+					var sun = s.sun ? s.sun + (s.offset ? (s.offset.charAt(0) == "-" ? " " : " +") + s.offset : "") : "";
+					var when = (s.cron || s.at || sun) + (s.timezone ? " " + s.timezone : "") + (s.missed == "run-once" ? " (runs missed once)" : "");
+					var state = s.done ? "done" : (s.paused ? "paused" : "");
+					rows += "<tr class='" + state + "'><td>" + esc(s.name) + "</td><td>" + esc(s.device) + "</td><td>" + esc(s.action || "default") +
+						(s.delay ? " " + esc(s.delay) : "") + "</td><td>" + esc(when) + "</td><td>" + (state || time(s.next)) +
+						"</td><td class='" + (s.last_error ? "failed" : "") + "' title='" + esc(s.last_error) + "'>" + time(s.last_run) + "</td><td>" +
+						(s.done ? "" : "<button type='button' onclick='pause(\"" + s.id + "\", " + !s.paused + ")'>" + (s.paused ? "resume" : "pause") + "</button> ") +
+						"<button type='button' onclick='remove(\"" + s.id + "\")'>delete</button></td></tr>";
+				});
+				document.getElementById("schedules").innerHTML = rows;
+			});
+		}
+
+		function pause(id, paused) {
+			request("POST", "schedules/" + id + (paused ? "/pause" : "/resume"), null, load);
+		}
+
+		function remove(id) {
+			if (confirm("Delete the schedule?")) {
+				request("DELETE", "schedules/" + id, null, load);
+			}
+		}
+
+		document.forms["add"].onsubmit = function(event) {
+			event.preventDefault();
+			var s = {};
+			["name", "device", "action", "delay", "cron", "at", "sun", "offset", "timezone", "missed"].forEach(function(f) {
+				s[f] = document.getElementById(f).value;
+			});
+			request("POST", "schedules", s, function() {
+				document.getElementById("cron").value = "";
+				document.getElementById("at").value = "";
+				document.getElementById("sun").value = "";
+				document.getElementById("offset").value = "";
+				load();
+			});
+		};
+		load();
+		</script>
+		</body>
+		</html>
+		`, devices.String(), html.EscapeString(time.Now().Format("MST")))
 }
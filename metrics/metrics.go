@@ -0,0 +1,235 @@
+// Package metrics exposes counters, gauges and histograms in the Prometheus text format
+//
+//	# HELP rpi_actuations_total Pin actuations by pin and type
+//	# TYPE rpi_actuations_total counter
+//	rpi_actuations_total{pin="18",type="timer"} 3
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	mu         sync.Mutex
+	collectors []collector
+)
+
+func register(c collector) {
+	mu.Lock()
+	defer mu.Unlock()
+	collectors = append(collectors, c)
+}
+
+// Handler serves all metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		cs := append([]collector(nil), collectors...)
+		mu.Unlock()
+
+		var b bytes.Buffer
+		for _, c := range cs {
+			c.write(&b)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := b.WriteTo(w); err != nil {
+			log.Printf("Couldn't write the metrics:%v", err)
+		}
+	})
+}
+
+// Counter only goes up
+type Counter struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter creates and registers a counter, the label values are given to Inc in the same order
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the series with the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the series with the label values
+func (c *Counter) Add(v float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key(values)] += v
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	header(w, c.name, c.help, "counter")
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%v%v %v\n", c.name, labels(c.labels, split(k)), format(c.values[k]))
+	}
+}
+
+// Sample is a single gauge value
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// GaugeFunc is a gauge read when the metrics are collected
+type GaugeFunc struct {
+	name, help string
+	labels     []string
+	f          func() []Sample
+}
+
+// NewGaugeFunc creates and registers a gauge that calls f on every scrape
+func NewGaugeFunc(name, help string, f func() []Sample, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, labels: labels, f: f}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	header(w, g.name, g.help, "gauge")
+	for _, s := range g.f() {
+		fmt.Fprintf(w, "%v%v %v\n", g.name, labels(g.labels, s.Labels), format(s.Value))
+	}
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates and registers a histogram with the given upper bounds
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+// Observe adds a value to the series with the label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := key(values)
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	header(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s, values := h.series[k], split(k)
+		names := append(append([]string(nil), h.labels...), "le")
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, labels(names, append(values, format(b))), s.counts[i])
+		}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, labels(names, append(values, "+Inf")), s.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", h.name, labels(h.labels, values), format(s.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", h.name, labels(h.labels, values), s.count)
+	}
+}
+
+func header(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, typ)
+}
+
+// labelSep can't appear in the label values of the metrics here
+const labelSep = "\xff"
+
+func key(values []string) string {
+	return strings.Join(values, labelSep)
+}
+
+func split(k string) []string {
+	if k == "" {
+		return nil
+	}
+	return strings.Split(k, labelSep)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func labels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	esc := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	p := make([]string, len(names))
+	for i, n := range names {
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		p[i] = fmt.Sprintf(`%v="%v"`, n, esc.Replace(v))
+	}
+	return "{" + strings.Join(p, ",") + "}"
+}
+
+func format(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
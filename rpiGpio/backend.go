@@ -0,0 +1,115 @@
+package rpiGpio
+
+import "strconv"
+
+// Direction of a GPIO pin
+type Direction string
+
+// Pin directions understood by all backends
+const (
+	In  Direction = "in"
+	Out Direction = "out"
+)
+
+// Backend is the low level access to the GPIO pins that Control uses.
+// Pins are identified by their BCM number as a string, the same way SetPin accepts them.
+type Backend interface {
+	// Exported reports if the pin is already exported and ready for use.
+	Exported(pin string) bool
+	Export(pin string) error
+	Unexport(pin string) error
+	SetDirection(pin string, d Direction) error
+	// Read returns the pin level - 0 or 1.
+	Read(pin string) (int, error)
+	// Write sets the pin level - 0 or 1.
+	Write(pin string, v int) error
+}
+
+// DefaultBackend is used by all controls that don't set their own backend with SetBackend.
+var DefaultBackend Backend = NewSysfs(DefaultSysfsRoot)
+
+// Bias is the pull-up or pull-down resistor setting of a pin
+type Bias string
+
+// Bias settings, the zero value leaves the pin as configured by the kernel
+const (
+	BiasDefault  Bias = ""
+	PullUp       Bias = "pull-up"
+	PullDown     Bias = "pull-down"
+	BiasDisabled Bias = "disabled"
+)
+
+// Edge is a change of the pin level
+type Edge string
+
+// Edges, Both is only used when selecting which edges to watch
+const (
+	EdgeNone Edge = "none"
+	Rising   Edge = "rising"
+	Falling  Edge = "falling"
+	Both     Edge = "both"
+)
+
+// Inverter is implemented by backends that invert the levels of active-low pins in the kernel,
+// Read and Write then use the logical levels - 1 is on.
+type Inverter interface {
+	SetActiveLow(pin string, on bool) error
+}
+
+// Outputter is implemented by backends that make a pin an output with its first level at once,
+// so the output doesn't start low for a moment before the first write.
+type Outputter interface {
+	SetOutput(pin string, v int) error
+}
+
+// OutputExporter is implemented by backends that export a pin as an output with its first level in one step,
+// the pin isn't an input in between so a relay that is on isn't released for a moment.
+// The active-low of the pin is set before the export.
+type OutputExporter interface {
+	ExportOutput(pin string, v int) error
+}
+
+// Resolver is implemented by backends that accept gpio line names, Line returns the offset of the named line.
+// The controls use the offset so the pin locks, the interlocks and the limits apply to the line by any of its names.
+type Resolver interface {
+	Line(name string) (string, error)
+}
+
+// resolveLine returns the offset of the line name, the name is kept for the backends that don't resolve them
+func resolveLine(b Backend, name string) (string, error) {
+	r, ok := b.(Resolver)
+	if !ok {
+		return name, nil
+	}
+	pin, err := r.Line(name)
+	if err != nil {
+		return "", newError(CodeInvalidPin, "Invalid pin:%v", err)
+	}
+	if n, err := strconv.Atoi(pin); err == nil {
+		if p, ok := board.pin(n); ok {
+			if err := checkFunction(p); err != nil {
+				return "", err
+			}
+		}
+	}
+	return pin, nil
+}
+
+// inverts reports if the backend inverts the active-low pins itself
+func inverts(b Backend) bool {
+	_, ok := b.(Inverter)
+	return ok
+}
+
+// Biaser is implemented by backends that can set the pull-up and pull-down resistors
+type Biaser interface {
+	SetBias(pin string, b Bias) error
+}
+
+// Watcher is implemented by backends that get notified by the kernel when an input pin changes.
+// The returned channel receives a value after each level change until stop is closed,
+// the receiver reads the pin to get the new level.
+// Inputs on backends that are not a Watcher are polled.
+type Watcher interface {
+	Watch(pin string, stop <-chan struct{}) (<-chan struct{}, error)
+}
@@ -0,0 +1,349 @@
+package rpiGpio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Files read to detect the board model
+var (
+	BoardModelPath = "/proc/device-tree/model"
+	CPUInfoPath    = "/proc/cpuinfo"
+)
+
+// Pin functions, the pins are usable as plain gpio as long as the function isn't enabled
+const (
+	FunctionI2C  = "i2c"
+	FunctionSPI  = "spi"
+	FunctionUART = "uart"
+	FunctionPWM  = "pwm"
+)
+
+// Board is the pin capability table of a Raspberry Pi model
+type Board struct {
+	// ID is the name used with --board like 3b or zero-w
+	ID    string `json:"id"`
+	Model string `json:"model"`
+	// Header is the layout of the gpio header - 26-rev1, 26-rev2, 40 or none for the compute modules
+	Header string     `json:"header"`
+	Pins   []BoardPin `json:"pins"`
+}
+
+// BoardPin is a usable gpio pin of the board
+type BoardPin struct {
+	GPIO int `json:"gpio"`
+	// Phys is the position on the gpio header, 0 for the pins that aren't on the header
+	Phys int `json:"phys,omitempty"`
+	// WPi is the wiringPi number, nil for the pins wiringPi doesn't number
+	WPi *int `json:"wpi,omitempty"`
+	// Function is the bus or output the pin is reserved for when it is enabled - i2c, spi, uart or pwm
+	Function string `json:"function,omitempty"`
+}
+
+// headers map the header positions to the gpio numbers
+var headers = map[string]map[int]int{
+	"26-rev1": {3: 0, 5: 1, 7: 4, 8: 14, 10: 15, 11: 17, 12: 18, 13: 21, 15: 22, 16: 23, 18: 24, 19: 10, 21: 9, 22: 25, 23: 11, 24: 8, 26: 7},
+	"26-rev2": {3: 2, 5: 3, 7: 4, 8: 14, 10: 15, 11: 17, 12: 18, 13: 27, 15: 22, 16: 23, 18: 24, 19: 10, 21: 9, 22: 25, 23: 11, 24: 8, 26: 7},
+	"40": {3: 2, 5: 3, 7: 4, 8: 14, 10: 15, 11: 17, 12: 18, 13: 27, 15: 22, 16: 23, 18: 24, 19: 10, 21: 9, 22: 25, 23: 11, 24: 8, 26: 7,
+		29: 5, 31: 6, 32: 12, 33: 13, 35: 19, 36: 16, 37: 26, 38: 20, 40: 21},
+}
+
+// wiringPi maps the wiringPi numbers to the gpio numbers, the compute modules use the table of the 40 pin header
+var wiringPi = map[string]map[int]int{
+	"26-rev1": {0: 17, 1: 18, 2: 21, 3: 22, 4: 23, 5: 24, 6: 25, 7: 4, 8: 0, 9: 1, 10: 8, 11: 7, 12: 10, 13: 9, 14: 11, 15: 14, 16: 15},
+	"26-rev2": {0: 17, 1: 18, 2: 27, 3: 22, 4: 23, 5: 24, 6: 25, 7: 4, 8: 2, 9: 3, 10: 8, 11: 7, 12: 10, 13: 9, 14: 11, 15: 14, 16: 15},
+	"40": {0: 17, 1: 18, 2: 27, 3: 22, 4: 23, 5: 24, 6: 25, 7: 4, 8: 2, 9: 3, 10: 8, 11: 7, 12: 10, 13: 9, 14: 11, 15: 14, 16: 15,
+		21: 5, 22: 6, 23: 13, 24: 19, 25: 26, 26: 12, 27: 16, 28: 20, 29: 21, 30: 0, 31: 1},
+}
+
+// functions are the usual functions of the gpio pins, the 26 pin rev1 boards have the i2c on gpio 0 and 1
+var functions = map[string]map[int]string{
+	"26-rev1": {0: FunctionI2C, 1: FunctionI2C, 7: FunctionSPI, 8: FunctionSPI, 9: FunctionSPI, 10: FunctionSPI, 11: FunctionSPI, 14: FunctionUART, 15: FunctionUART, 18: FunctionPWM},
+	"26-rev2": {2: FunctionI2C, 3: FunctionI2C, 7: FunctionSPI, 8: FunctionSPI, 9: FunctionSPI, 10: FunctionSPI, 11: FunctionSPI, 14: FunctionUART, 15: FunctionUART, 18: FunctionPWM},
+	"40": {2: FunctionI2C, 3: FunctionI2C, 7: FunctionSPI, 8: FunctionSPI, 9: FunctionSPI, 10: FunctionSPI, 11: FunctionSPI, 14: FunctionUART, 15: FunctionUART,
+		12: FunctionPWM, 13: FunctionPWM, 18: FunctionPWM, 19: FunctionPWM},
+	"none": {2: FunctionI2C, 3: FunctionI2C, 7: FunctionSPI, 8: FunctionSPI, 9: FunctionSPI, 10: FunctionSPI, 11: FunctionSPI, 14: FunctionUART, 15: FunctionUART,
+		12: FunctionPWM, 13: FunctionPWM, 18: FunctionPWM, 19: FunctionPWM},
+}
+
+// reserved are the functions whose pins can't be used as gpio unless allowed with AllowFunctions,
+// the i2c bus and the serial console are usually enabled and a gpio output would fight them
+var reserved = map[string]bool{FunctionI2C: true, FunctionUART: true}
+
+// boards are the known models by their id with the model name of the device tree and the header layout
+var boards = map[string]struct{ model, header string }{
+	"b-rev1":  {"Raspberry Pi Model B Rev 1", "26-rev1"},
+	"a":       {"Raspberry Pi Model A", "26-rev2"},
+	"b":       {"Raspberry Pi Model B Rev 2", "26-rev2"},
+	"a+":      {"Raspberry Pi Model A Plus", "40"},
+	"b+":      {"Raspberry Pi Model B Plus", "40"},
+	"2b":      {"Raspberry Pi 2 Model B", "40"},
+	"3b":      {"Raspberry Pi 3 Model B", "40"},
+	"3b+":     {"Raspberry Pi 3 Model B Plus", "40"},
+	"3a+":     {"Raspberry Pi 3 Model A Plus", "40"},
+	"4b":      {"Raspberry Pi 4 Model B", "40"},
+	"400":     {"Raspberry Pi 400", "40"},
+	"5":       {"Raspberry Pi 5 Model B", "40"},
+	"zero":    {"Raspberry Pi Zero", "40"},
+	"zero-w":  {"Raspberry Pi Zero W", "40"},
+	"zero-2w": {"Raspberry Pi Zero 2 W", "40"},
+	"cm1":     {"Raspberry Pi Compute Module", "none"},
+	"cm3":     {"Raspberry Pi Compute Module 3", "none"},
+	"cm3+":    {"Raspberry Pi Compute Module 3 Plus", "none"},
+	"cm4":     {"Raspberry Pi Compute Module 4", "none"},
+}
+
+// revisionTypes are the board types of the new style revision codes from /proc/cpuinfo
+var revisionTypes = map[int64]string{
+	0x0: "a", 0x1: "b", 0x2: "a+", 0x3: "b+", 0x4: "2b", 0x6: "cm1", 0x8: "3b", 0x9: "zero", 0xa: "cm3",
+	0xc: "zero-w", 0xd: "3b+", 0xe: "3a+", 0x10: "cm3+", 0x11: "4b", 0x12: "zero-2w", 0x13: "400", 0x14: "cm4", 0x17: "5",
+}
+
+// oldRevisions are the old style revision codes of the first boards
+var oldRevisions = map[int64]string{
+	0x2: "b-rev1", 0x3: "b-rev1", 0x4: "b", 0x5: "b", 0x6: "b", 0x7: "a", 0x8: "a", 0x9: "a", 0xd: "b", 0xe: "b", 0xf: "b",
+	0x10: "b+", 0x11: "cm1", 0x12: "a+", 0x13: "b+", 0x14: "cm1", 0x15: "a+",
+}
+
+// DefaultBoard is used when the model can't be detected, like on a laptop with --simulate
+const DefaultBoard = "3b"
+
+// board is the board the pins are validated against, set with SetBoard before the pins are used
+var board = newBoard(DefaultBoard, "")
+
+// Boards returns the ids of the known boards
+func Boards() []string {
+	var l []string
+	for id := range boards {
+		l = append(l, id)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// SetBoard selects the pin table of the board with the id, an empty id detects the board
+func SetBoard(id string) error {
+	if id == "" {
+		b, err := DetectBoard()
+		if err != nil {
+			log.Printf("Couldn't detect the board, using the pins of the %v:%v", boards[DefaultBoard].model, err)
+			b = newBoard(DefaultBoard, "")
+		}
+		board = b
+		return nil
+	}
+	if _, ok := boards[id]; !ok {
+		return fmt.Errorf("Unknown board:%v, use one of %v", id, Boards())
+	}
+	board = newBoard(id, "")
+	return nil
+}
+
+// AllowFunctions allows using the pins reserved for the functions as gpio, when the bus or the console is disabled
+func AllowFunctions(f ...string) error {
+	for _, fn := range f {
+		fn = strings.ToLower(strings.TrimSpace(fn))
+		switch fn {
+		case FunctionI2C, FunctionSPI, FunctionUART, FunctionPWM:
+			delete(reserved, fn)
+		default:
+			return fmt.Errorf("Unknown pin function:%v, use one of %v", fn, []string{FunctionI2C, FunctionSPI, FunctionUART, FunctionPWM})
+		}
+	}
+	return nil
+}
+
+// checkFunction fails for the pins of a reserved function
+func checkFunction(p BoardPin) error {
+	if reserved[p.Function] {
+		return newError(CodeInvalidPin, "GPIO %v is the %v pin of the %v, allow it with --allow-function %v", p.GPIO, p.Function, board.Model, p.Function)
+	}
+	return nil
+}
+
+// CurrentBoard returns the board the pins are validated against
+func CurrentBoard() Board {
+	return *board
+}
+
+// DetectBoard reads the board model from the device tree and the revision code from /proc/cpuinfo
+func DetectBoard() (*Board, error) {
+	model, _ := ioutil.ReadFile(BoardModelPath)
+	model = bytes.TrimRight(model, "\x00\n ")
+	cpuinfo, err := ioutil.ReadFile(CPUInfoPath)
+	if err != nil && len(model) == 0 {
+		return nil, err
+	}
+	if id, ok := revisionBoard(cpuinfo); ok {
+		return newBoard(id, string(model)), nil
+	}
+	if id, ok := modelBoard(string(model)); ok {
+		return newBoard(id, string(model)), nil
+	}
+	return nil, fmt.Errorf("Unknown board model:%q", model)
+}
+
+// revisionBoard finds the board from the Revision line of /proc/cpuinfo
+func revisionBoard(cpuinfo []byte) (string, bool) {
+	s := bufio.NewScanner(bytes.NewReader(cpuinfo))
+	for s.Scan() {
+		kv := strings.SplitN(s.Text(), ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "Revision" {
+			continue
+		}
+		rev, err := strconv.ParseInt(strings.TrimSpace(kv[1]), 16, 64)
+		if err != nil {
+			return "", false
+		}
+		// bit 23 is set for the new style codes with the board type in bits 4-11
+		if rev&(1<<23) != 0 {
+			id, ok := revisionTypes[(rev>>4)&0xff]
+			return id, ok
+		}
+		// the highest bits of the old codes are the overvoltage and warranty flags
+		id, ok := oldRevisions[rev&0xffff]
+		return id, ok
+	}
+	return "", false
+}
+
+// modelBoard guesses the board from the model name in the device tree
+func modelBoard(model string) (string, bool) {
+	var best string
+	for id, b := range boards {
+		if strings.HasPrefix(model, b.model) && len(b.model) > len(boards[best].model) {
+			best = id
+		}
+	}
+	return best, best != ""
+}
+
+func newBoard(id, model string) *Board {
+	b := boards[id]
+	if model == "" {
+		model = b.model
+	}
+	board := &Board{ID: id, Model: model, Header: b.header}
+	phys := make(map[int]int)
+	for p, g := range headers[b.header] {
+		phys[g] = p
+	}
+	wpi := make(map[int]int)
+	wpiHeader := b.header
+	if wpiHeader == "none" {
+		wpiHeader = "40"
+	}
+	for w, g := range wiringPi[wpiHeader] {
+		wpi[g] = w
+	}
+	last := 27
+	if b.header == "none" {
+		last = 45
+	}
+	for g := 0; g <= last; g++ {
+		_, onHeader := phys[g]
+		// the compute modules have all gpios, the other boards only the ones on the header
+		if b.header != "none" && !onHeader {
+			continue
+		}
+		// gpio 0 and 1 are for the hat eeprom on the compute modules too
+		if b.header == "none" && g < 2 {
+			continue
+		}
+		p := BoardPin{GPIO: g, Phys: phys[g], Function: functions[b.header][g]}
+		if w, ok := wpi[g]; ok {
+			p.WPi = &w
+		}
+		board.Pins = append(board.Pins, p)
+	}
+	return board
+}
+
+// pin returns the pin of the board with the gpio number
+func (b *Board) pin(gpio int) (BoardPin, bool) {
+	for _, p := range b.Pins {
+		if p.GPIO == gpio {
+			return p, true
+		}
+	}
+	return BoardPin{}, false
+}
+
+// gpios returns the gpio numbers of the board
+func (b *Board) gpios() []int {
+	var l []int
+	for _, p := range b.Pins {
+		l = append(l, p.GPIO)
+	}
+	return l
+}
+
+// Pin numbering schemes accepted as a prefix of the pin like phys:12
+const (
+	NumberingBCM  = "bcm"
+	NumberingPhys = "phys"
+	NumberingWPi  = "wpi"
+)
+
+// ResolvePin translates a pin like bcm:18, phys:12 or wpi:1 to the gpio number of the board,
+// a plain number is a gpio number and a gpio line name is the offset of the line in the DefaultBackend,
+// so a line name and the number of the same line are the same pin
+func ResolvePin(d string) (string, error) {
+	pin, err := pinNumber(d)
+	if err != nil || !lineName.MatchString(pin) {
+		return pin, err
+	}
+	return resolveLine(DefaultBackend, pin)
+}
+
+// pinNumber translates a pin number with a numbering scheme to the gpio number, a line name is returned as it is
+func pinNumber(d string) (string, error) {
+	scheme, num := NumberingBCM, d
+	if i := strings.Index(d, ":"); i >= 0 {
+		scheme, num = strings.ToLower(d[:i]), d[i+1:]
+	} else if lineName.MatchString(d) {
+		return d, nil
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return "", newError(CodeInvalidPin, "Invalid pin:%v, use a gpio number like 18, bcm:18, phys:12, wpi:1 or a gpio line name", d)
+	}
+	for _, p := range board.Pins {
+		var ok bool
+		switch scheme {
+		case NumberingBCM:
+			ok = p.GPIO == n
+		case NumberingPhys:
+			ok = p.Phys != 0 && p.Phys == n
+		case NumberingWPi:
+			ok = p.WPi != nil && *p.WPi == n
+		default:
+			return "", newError(CodeInvalidPin, "Invalid pin numbering:%v, use %v, %v or %v", scheme, NumberingBCM, NumberingPhys, NumberingWPi)
+		}
+		if ok {
+			if err := checkFunction(p); err != nil {
+				return "", err
+			}
+			return strconv.Itoa(p.GPIO), nil
+		}
+	}
+	if scheme == NumberingBCM {
+		return "", newError(CodeInvalidPin, "Invalid GPIO pin number:%v, the %v has :%v or use a gpio line name", d, board.Model, board.gpios())
+	}
+	return "", newError(CodeInvalidPin, "Pin %v isn't a gpio of the %v", d, board.Model)
+}
+
+// PinNumbers returns the numbers of the gpio pin in every numbering scheme, false for a line name or an unknown pin
+func PinNumbers(pin string) (BoardPin, bool) {
+	n, err := strconv.Atoi(pin)
+	if err != nil {
+		return BoardPin{}, false
+	}
+	return board.pin(n)
+}
@@ -0,0 +1,207 @@
+package rpiGpio
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the device tree model ends with a NUL like in /proc/device-tree/model
+const (
+	cpuinfo3B = `processor	: 0
+model name	: ARMv7 Processor rev 4 (v7l)
+BogoMIPS	: 38.40
+Features	: half thumb fastmult vfp edsp neon vfpv3 tls vfpv4 idiva idivt vfpd32 lpae evtstrm crc32
+CPU implementer	: 0x41
+
+Hardware	: BCM2835
+Revision	: a02082
+Serial		: 00000000f1e2d3c4
+Model		: Raspberry Pi 3 Model B Rev 1.2
+`
+	cpuinfo4B = `processor	: 0
+BogoMIPS	: 108.00
+Features	: fp asimd evtstrm crc32 cpuid
+CPU implementer	: 0x41
+
+Revision	: c03111
+Serial		: 10000000a1b2c3d4
+Model		: Raspberry Pi 4 Model B Rev 1.1
+`
+	cpuinfoZeroW = `processor	: 0
+model name	: ARMv6-compatible processor rev 7 (v6l)
+BogoMIPS	: 697.95
+
+Hardware	: BCM2835
+Revision	: 9000c1
+Serial		: 00000000e5f6a7b8
+Model		: Raspberry Pi Zero W Rev 1.1
+`
+	// the first Model B has an old style revision code, with the overvoltage bit set
+	cpuinfoBRev1 = `processor	: 0
+model name	: ARMv6-compatible processor rev 7 (v6l)
+
+Hardware	: BCM2708
+Revision	: 1000003
+Serial		: 0000000012345678
+`
+)
+
+// boardFiles writes the model and the cpuinfo fixtures and detects the board from them
+func boardFiles(t *testing.T, model, cpuinfo string) (*Board, error) {
+	t.Helper()
+	dir := t.TempDir()
+	oldModel, oldCPU := BoardModelPath, CPUInfoPath
+	t.Cleanup(func() { BoardModelPath, CPUInfoPath = oldModel, oldCPU })
+	BoardModelPath = filepath.Join(dir, "model")
+	CPUInfoPath = filepath.Join(dir, "cpuinfo")
+	if model != "" {
+		if err := ioutil.WriteFile(BoardModelPath, []byte(model+"\x00"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cpuinfo != "" {
+		if err := ioutil.WriteFile(CPUInfoPath, []byte(cpuinfo), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return DetectBoard()
+}
+
+// useBoard validates the pins against the board until the test ends
+func useBoard(t *testing.T, b *Board) {
+	old := board
+	board = b
+	t.Cleanup(func() { board = old })
+}
+
+func TestDetectBoard(t *testing.T) {
+	for _, tt := range []struct {
+		name, model, cpuinfo string
+		id, header           string
+	}{
+		{"3b", "Raspberry Pi 3 Model B Rev 1.2", cpuinfo3B, "3b", "40"},
+		{"4b", "Raspberry Pi 4 Model B Rev 1.1", cpuinfo4B, "4b", "40"},
+		{"zero-w", "Raspberry Pi Zero W Rev 1.1", cpuinfoZeroW, "zero-w", "40"},
+		{"b-rev1", "", cpuinfoBRev1, "b-rev1", "26-rev1"},
+		// without a revision code the longest model name matches, not the Pi 3 Model B
+		{"model only", "Raspberry Pi 3 Model B Plus Rev 1.3", "processor	: 0\n", "3b+", "40"},
+		{"model without cpuinfo", "Raspberry Pi Zero W Rev 1.1", "", "zero-w", "40"},
+		// the revision code wins over a model name that doesn't match
+		{"revision", "Some Pi clone", cpuinfo4B, "4b", "40"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := boardFiles(t, tt.model, tt.cpuinfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.ID != tt.id || b.Header != tt.header {
+				t.Fatalf("detected %v with the %v header, expected %v with %v", b.ID, b.Header, tt.id, tt.header)
+			}
+			if tt.model != "" && b.Model != tt.model {
+				t.Fatalf("model:%q, expected the one of the device tree %q", b.Model, tt.model)
+			}
+		})
+	}
+
+	for _, tt := range []struct{ name, model, cpuinfo string }{
+		{"unknown", "Some Pi clone", "Revision	: 0000ff\n"},
+		{"no files", "", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if b, err := boardFiles(t, tt.model, tt.cpuinfo); err == nil {
+				t.Fatalf("detected %v", b.ID)
+			}
+		})
+	}
+}
+
+func TestBoardPins(t *testing.T) {
+	for _, tt := range []struct {
+		name, model, cpuinfo string
+		// valid are the pins in any numbering with their gpio number
+		valid map[string]string
+		// invalid aren't gpio pins of the board, reserved are the pins of the i2c and the uart
+		invalid, reserved []string
+	}{
+		{"3b", "Raspberry Pi 3 Model B Rev 1.2", cpuinfo3B,
+			map[string]string{"18": "18", "bcm:27": "27", "phys:12": "18", "phys:40": "21", "wpi:1": "18", "wpi:29": "21", "4": "4", "10": "10"},
+			[]string{"28", "phys:1", "phys:41", "wpi:17", "foo:1", "-1"},
+			[]string{"2", "3", "14", "15", "phys:3", "phys:8", "wpi:8"}},
+		{"4b", "Raspberry Pi 4 Model B Rev 1.1", cpuinfo4B,
+			map[string]string{"26": "26", "phys:37": "26", "wpi:25": "26", "phys:32": "12", "wpi:26": "12"},
+			[]string{"27x", "28", "45", "phys:2"},
+			[]string{"2", "15", "phys:5", "phys:10"}},
+		{"zero-w", "Raspberry Pi Zero W Rev 1.1", cpuinfoZeroW,
+			map[string]string{"17": "17", "phys:11": "17", "wpi:0": "17", "phys:29": "5"},
+			[]string{"28", "0", "1", "phys:27", "wpi:30"},
+			[]string{"3", "14", "phys:8"}},
+		{"b-rev1", "", cpuinfoBRev1,
+			map[string]string{"21": "21", "phys:13": "21", "wpi:2": "21", "4": "4"},
+			[]string{"2", "27", "5", "phys:29", "wpi:21"},
+			[]string{"0", "1", "phys:3", "14"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := boardFiles(t, tt.model, tt.cpuinfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			useBoard(t, b)
+			for pin, gpio := range tt.valid {
+				if p, err := ResolvePin(pin); err != nil || p != gpio {
+					t.Fatalf("%v resolved to %v, expected %v:%v", pin, p, gpio, err)
+				}
+				if _, err := NewControl(SetPin(pin)); err != nil {
+					t.Fatalf("SetPin(%v):%v", pin, err)
+				}
+			}
+			for _, pin := range append(tt.invalid, tt.reserved...) {
+				if p, err := ResolvePin(pin); err == nil || ErrorCode(err) != CodeInvalidPin {
+					t.Fatalf("%v resolved to %v:%v", pin, p, err)
+				}
+				if _, err := NewControl(SetPin(pin)); err == nil {
+					t.Fatalf("SetPin(%v) didn't fail", pin)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowFunctions(t *testing.T) {
+	old := reserved
+	reserved = map[string]bool{FunctionI2C: true, FunctionUART: true}
+	t.Cleanup(func() { reserved = old })
+	useBoard(t, newBoard("3b", ""))
+
+	if _, err := ResolvePin("2"); err == nil {
+		t.Fatal("the i2c pin isn't reserved")
+	}
+	if err := AllowFunctions("I2C"); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := ResolvePin("phys:3"); err != nil || p != "2" {
+		t.Fatalf("the allowed i2c pin resolved to %v:%v", p, err)
+	}
+	if _, err := ResolvePin("14"); err == nil {
+		t.Fatal("allowing the i2c allowed the uart pins")
+	}
+	err := AllowFunctions("can")
+	if err == nil {
+		t.Fatal("an unknown function didn't fail")
+	}
+	for _, f := range []string{FunctionI2C, FunctionSPI, FunctionUART, FunctionPWM} {
+		if !strings.Contains(err.Error(), f) {
+			t.Fatalf("the error doesn't list the %v function:%v", f, err)
+		}
+	}
+
+	// a line name of a reserved pin is rejected too
+	b := &namedSim{countingSim: newCountingSim(), names: map[string]string{"TXD": "14", "RELAY": "17"}}
+	if _, err := NewControl(SetPin("TXD"), SetBackend(b)); err == nil {
+		t.Fatal("the line name of the uart pin didn't fail")
+	}
+	if _, err := NewControl(SetPin("RELAY"), SetBackend(b)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,140 @@
+package rpiGpio
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// fakeIoctl records the line requests and the line configs of the chip backend instead of calling the kernel
+type fakeIoctl struct {
+	mu       sync.Mutex
+	requests []gpioLineRequest
+	configs  []gpioLineConfig
+}
+
+func newFakeIoctl(t *testing.T) *fakeIoctl {
+	f := &fakeIoctl{}
+	old := ioctl
+	ioctl = f.ioctl
+	t.Cleanup(func() { ioctl = old })
+	return f
+}
+
+func (f *fakeIoctl) ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch req {
+	case gpioGetLineIoctl:
+		r := (*gpioLineRequest)(arg)
+		// any file will do for the line
+		lfd, err := syscall.Open("/dev/null", syscall.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		r.Fd = int32(lfd)
+		f.requests = append(f.requests, *r)
+	case gpioLineSetConfigIoctl:
+		f.configs = append(f.configs, *(*gpioLineConfig)(arg))
+	}
+	return nil
+}
+
+func fakeChipDevice(t *testing.T) *Chip {
+	t.Helper()
+	dev := filepath.Join(t.TempDir(), "gpiochip0")
+	if err := ioutil.WriteFile(dev, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewChip(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// outputValue is the level an output line config starts with
+func outputValue(cfg gpioLineConfig) (int, bool) {
+	for i := uint32(0); i < cfg.NumAttrs; i++ {
+		if a := cfg.Attrs[i]; a.Attr.ID == gpioLineAttrIDOutputValue && a.Mask&1 != 0 {
+			return int(a.Attr.Value & 1), true
+		}
+	}
+	return 0, false
+}
+
+func TestChipExportOutput(t *testing.T) {
+	f := newFakeIoctl(t)
+	c := fakeChipDevice(t)
+	if err := c.SetActiveLow("4", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ExportOutput("4", 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.requests) != 1 || len(f.configs) != 0 {
+		t.Fatalf("%v line requests and %v line configs, expected a single request", len(f.requests), len(f.configs))
+	}
+	r := f.requests[0]
+	if r.Offsets[0] != 4 || r.Config.Flags&gpioLineFlagInput != 0 || r.Config.Flags&gpioLineFlagOutput == 0 {
+		t.Fatalf("line %v requested with the flags %b, expected an output", r.Offsets[0], r.Config.Flags)
+	}
+	if r.Config.Flags&gpioLineFlagActiveLow == 0 {
+		t.Fatal("the active-low isn't set with the request")
+	}
+	if v, ok := outputValue(r.Config); !ok || v != 1 {
+		t.Fatalf("the output starts at %v, expected 1", v)
+	}
+
+	// an input is still requested as an input
+	if err := c.Export("5"); err != nil {
+		t.Fatal(err)
+	}
+	if r := f.requests[1]; r.Config.Flags&gpioLineFlagInput == 0 {
+		t.Fatalf("the input is requested with the flags %b", r.Config.Flags)
+	}
+}
+
+// TestChipControlOutput checks that a control never requests its output pin as an input
+func TestChipControlOutput(t *testing.T) {
+	f := newFakeIoctl(t)
+	c := fakeChipDevice(t)
+	forgetPins(t, "26", "27")
+	if err := SetPinActiveLow("26", true); err != nil {
+		t.Fatal(err)
+	}
+	ctrl, err := NewControl(SetType("set"), SetPin("26"), SetLevel("1"), SetInitial("on"), SetBackend(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctrl.Run(); err != nil {
+		t.Fatal(err)
+	}
+	ctrl, _ = NewControl(SetType("toggle"), SetPin("27"), SetBackend(c))
+	if _, err := ctrl.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) != 2 {
+		t.Fatalf("%v line requests, expected one for each pin", len(f.requests))
+	}
+	for _, r := range f.requests {
+		if r.Config.Flags&gpioLineFlagInput != 0 {
+			t.Fatalf("line %v was requested as an input", r.Offsets[0])
+		}
+	}
+	if v, _ := outputValue(f.requests[0].Config); v != 1 || f.requests[0].Config.Flags&gpioLineFlagActiveLow == 0 {
+		t.Fatal("the initial on of the active-low pin isn't requested with the line")
+	}
+	for _, cfg := range f.configs {
+		if cfg.Flags&gpioLineFlagInput != 0 {
+			t.Fatal("an output line was reconfigured as an input")
+		}
+	}
+}
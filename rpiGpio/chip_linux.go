@@ -0,0 +1,484 @@
+package rpiGpio
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// DefaultChip is the gpio character device of the main Raspberry Pi header
+const DefaultChip = "/dev/gpiochip0"
+
+// structures and flags from the v2 GPIO uAPI in linux/gpio.h
+const (
+	gpioMaxLines    = 64
+	gpioMaxNameSize = 32
+	gpioMaxAttrs    = 10
+
+	gpioLineFlagActiveLow     = 1 << 1
+	gpioLineFlagInput         = 1 << 2
+	gpioLineFlagOutput        = 1 << 3
+	gpioLineFlagEdgeRising    = 1 << 4
+	gpioLineFlagEdgeFalling   = 1 << 5
+	gpioLineFlagBiasPullUp    = 1 << 8
+	gpioLineFlagBiasPullDown  = 1 << 9
+	gpioLineFlagBiasDisabled  = 1 << 10
+	gpioLineAttrIDOutputValue = 2
+)
+
+type gpioChipInfo struct {
+	Name  [gpioMaxNameSize]byte
+	Label [gpioMaxNameSize]byte
+	Lines uint32
+}
+
+type gpioLineAttribute struct {
+	ID      uint32
+	Padding uint32
+	// union of flags, values and debounce_period_us
+	Value uint64
+}
+
+type gpioLineConfigAttribute struct {
+	Attr gpioLineAttribute
+	Mask uint64
+}
+
+type gpioLineConfig struct {
+	Flags    uint64
+	NumAttrs uint32
+	Padding  [5]uint32
+	Attrs    [gpioMaxAttrs]gpioLineConfigAttribute
+}
+
+type gpioLineRequest struct {
+	Offsets         [gpioMaxLines]uint32
+	Consumer        [gpioMaxNameSize]byte
+	Config          gpioLineConfig
+	NumLines        uint32
+	EventBufferSize uint32
+	Padding         [5]uint32
+	Fd              int32
+}
+
+type gpioLineInfo struct {
+	Name     [gpioMaxNameSize]byte
+	Consumer [gpioMaxNameSize]byte
+	Offset   uint32
+	NumAttrs uint32
+	Flags    uint64
+	Attrs    [gpioMaxAttrs]gpioLineAttribute
+	Padding  [4]uint32
+}
+
+type gpioLineEvent struct {
+	TimestampNs uint64
+	ID          uint32
+	Offset      uint32
+	Seqno       uint32
+	LineSeqno   uint32
+	Padding     [6]uint32
+}
+
+type gpioLineValues struct {
+	Bits uint64
+	Mask uint64
+}
+
+func gpioIoc(dir, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 0xB4<<8 | nr
+}
+
+var (
+	gpioGetChipInfoIoctl   = gpioIoc(2, 0x01, unsafe.Sizeof(gpioChipInfo{}))
+	gpioGetLineInfoIoctl   = gpioIoc(3, 0x05, unsafe.Sizeof(gpioLineInfo{}))
+	gpioGetLineIoctl       = gpioIoc(3, 0x07, unsafe.Sizeof(gpioLineRequest{}))
+	gpioLineSetConfigIoctl = gpioIoc(3, 0x0D, unsafe.Sizeof(gpioLineConfig{}))
+	gpioLineGetValuesIoctl = gpioIoc(3, 0x0E, unsafe.Sizeof(gpioLineValues{}))
+	gpioLineSetValuesIoctl = gpioIoc(3, 0x0F, unsafe.Sizeof(gpioLineValues{}))
+)
+
+// chipConsumer is the label the kernel shows for the lines held by this app
+const chipConsumer = "rpi-web-control"
+
+// ioctl is a variable so the tests can check the requests without a gpio chip
+var ioctl = func(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Chip is a Backend that uses the gpio character device (/dev/gpiochipN) and the v2 line request ioctls.
+// Pins can be given as line offsets or as line names.
+// Lines are held while exported and are released by Unexport or Close.
+type Chip struct {
+	path string
+
+	mu    sync.Mutex
+	lines map[string]*chipLine
+	// line settings that apply when the line is requested
+	bias      map[string]Bias
+	activeLow map[string]bool
+}
+
+type chipLine struct {
+	// fd is used for the ioctls because os.File.Fd puts the file back to blocking mode
+	fd     uintptr
+	f      *os.File
+	offset uint32
+	flags  uint64
+}
+
+// NewChip creates a backend for the given gpio character device
+func NewChip(path string) (*Chip, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info := gpioChipInfo{}
+	if err := ioctl(f.Fd(), gpioGetChipInfoIoctl, unsafe.Pointer(&info)); err != nil {
+		return nil, fmt.Errorf("%v is not a gpio chip: %v", path, err)
+	}
+	return &Chip{
+		path:      path,
+		lines:     make(map[string]*chipLine),
+		bias:      make(map[string]Bias),
+		activeLow: make(map[string]bool),
+	}, nil
+}
+
+// offset finds the line offset by its number or by its name
+func (c *Chip) offset(f *os.File, pin string) (uint32, error) {
+	if o, err := strconv.ParseUint(pin, 10, 32); err == nil {
+		return uint32(o), nil
+	}
+	chip := gpioChipInfo{}
+	if err := ioctl(f.Fd(), gpioGetChipInfoIoctl, unsafe.Pointer(&chip)); err != nil {
+		return 0, err
+	}
+	for o := uint32(0); o < chip.Lines; o++ {
+		info := gpioLineInfo{Offset: o}
+		if err := ioctl(f.Fd(), gpioGetLineInfoIoctl, unsafe.Pointer(&info)); err != nil {
+			return 0, err
+		}
+		if cString(info.Name[:]) == pin {
+			return o, nil
+		}
+	}
+	return 0, fmt.Errorf("No line named %v on %v", pin, c.path)
+}
+
+// Line returns the offset of the named line
+func (c *Chip) Line(name string) (string, error) {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	o, err := c.offset(f, name)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(uint64(o), 10), nil
+}
+
+func (c *Chip) line(pin string) (*chipLine, error) {
+	l, ok := c.lines[pin]
+	if !ok {
+		return nil, fmt.Errorf("Pin %v is not exported", pin)
+	}
+	return l, nil
+}
+
+func (c *Chip) lineFlags(pin string, d Direction) uint64 {
+	var flags uint64 = gpioLineFlagInput
+	if d == Out {
+		flags = gpioLineFlagOutput
+	}
+	if c.activeLow[pin] {
+		flags |= gpioLineFlagActiveLow
+	}
+	switch c.bias[pin] {
+	case PullUp:
+		flags |= gpioLineFlagBiasPullUp
+	case PullDown:
+		flags |= gpioLineFlagBiasPullDown
+	case BiasDisabled:
+		flags |= gpioLineFlagBiasDisabled
+	}
+	return flags
+}
+
+// Exported reports if the line is currently requested
+func (c *Chip) Exported(pin string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.lines[pin]
+	return ok
+}
+
+// Export requests the line as an input
+func (c *Chip) Export(pin string) error {
+	return c.request(pin, In, 0)
+}
+
+// ExportOutput requests the line as an output with the level, the line isn't an input for a moment
+// like with Export and SetOutput, which would release a relay that is on
+func (c *Chip) ExportOutput(pin string, v int) error {
+	if v != 0 {
+		v = 1
+	}
+	return c.request(pin, Out, v)
+}
+
+func (c *Chip) request(pin string, d Direction, v int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.lines[pin]; ok {
+		return nil
+	}
+
+	f, err := os.Open(c.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	o, err := c.offset(f, pin)
+	if err != nil {
+		return err
+	}
+	req := gpioLineRequest{NumLines: 1}
+	req.Offsets[0] = o
+	copy(req.Consumer[:gpioMaxNameSize-1], chipConsumer)
+	req.Config.Flags = c.lineFlags(pin, d)
+	if d == Out {
+		req.Config.NumAttrs = 1
+		req.Config.Attrs[0] = gpioLineConfigAttribute{
+			Attr: gpioLineAttribute{ID: gpioLineAttrIDOutputValue, Value: uint64(v)},
+			Mask: 1,
+		}
+	}
+	if err := ioctl(f.Fd(), gpioGetLineIoctl, unsafe.Pointer(&req)); err != nil {
+		return fmt.Errorf("Can't request line %v on %v: %v", pin, c.path, err)
+	}
+	// non blocking so that reading line events can be interrupted with a deadline
+	if err := syscall.SetNonblock(int(req.Fd), true); err != nil {
+		syscall.Close(int(req.Fd))
+		return err
+	}
+	c.lines[pin] = &chipLine{
+		fd:     uintptr(req.Fd),
+		f:      os.NewFile(uintptr(req.Fd), fmt.Sprintf("%v:%v", c.path, o)),
+		offset: o,
+		flags:  req.Config.Flags,
+	}
+	return nil
+}
+
+// Unexport releases the line
+func (c *Chip) Unexport(pin string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.lines[pin]
+	if !ok {
+		return nil
+	}
+	delete(c.lines, pin)
+	return l.f.Close()
+}
+
+func (c *Chip) reconfigure(l *chipLine, flags uint64, value int) error {
+	cfg := gpioLineConfig{Flags: flags}
+	if flags&gpioLineFlagOutput != 0 {
+		// keep the current level so changing the line settings doesn't glitch the output
+		cfg.NumAttrs = 1
+		cfg.Attrs[0] = gpioLineConfigAttribute{
+			Attr: gpioLineAttribute{ID: gpioLineAttrIDOutputValue, Value: uint64(value)},
+			Mask: 1,
+		}
+	}
+	if err := ioctl(l.fd, gpioLineSetConfigIoctl, unsafe.Pointer(&cfg)); err != nil {
+		return err
+	}
+	l.flags = flags
+	return nil
+}
+
+// update applies the changed line settings to an already requested line,
+// invert is set when the active-low setting changed so the physical level is kept
+func (c *Chip) update(pin string, invert bool) error {
+	l, ok := c.lines[pin]
+	if !ok {
+		// applied when the line is requested
+		return nil
+	}
+	d := In
+	if l.flags&gpioLineFlagOutput != 0 {
+		d = Out
+	}
+	v, err := l.read()
+	if err != nil {
+		return err
+	}
+	if invert {
+		v ^= 1
+	}
+	// keep the edge detection of watched inputs
+	edges := l.flags & (gpioLineFlagEdgeRising | gpioLineFlagEdgeFalling)
+	return c.reconfigure(l, c.lineFlags(pin, d)|edges, v)
+}
+
+// SetOutput reconfigures the line as an output with the level in one request
+func (c *Chip) SetOutput(pin string, v int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, err := c.line(pin)
+	if err != nil {
+		return err
+	}
+	if v != 0 {
+		v = 1
+	}
+	return c.reconfigure(l, c.lineFlags(pin, Out), v)
+}
+
+// SetDirection reconfigures the line as an input or an output
+func (c *Chip) SetDirection(pin string, d Direction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, err := c.line(pin)
+	if err != nil {
+		return err
+	}
+	return c.reconfigure(l, c.lineFlags(pin, d), 0)
+}
+
+// SetBias sets the pull-up or pull-down resistor of the line
+func (c *Chip) SetBias(pin string, b Bias) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bias[pin] = b
+	return c.update(pin, false)
+}
+
+// SetActiveLow inverts the logical level of the line
+func (c *Chip) SetActiveLow(pin string, on bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	invert := c.activeLow[pin] != on
+	if !invert {
+		return nil
+	}
+	c.activeLow[pin] = on
+	return c.update(pin, invert)
+}
+
+func (l *chipLine) read() (int, error) {
+	v := gpioLineValues{Mask: 1}
+	if err := ioctl(l.fd, gpioLineGetValuesIoctl, unsafe.Pointer(&v)); err != nil {
+		return 0, err
+	}
+	return int(v.Bits & 1), nil
+}
+
+// Read returns the current line level
+func (c *Chip) Read(pin string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, err := c.line(pin)
+	if err != nil {
+		return 0, err
+	}
+	return l.read()
+}
+
+// Write sets the line level
+func (c *Chip) Write(pin string, v int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, err := c.line(pin)
+	if err != nil {
+		return err
+	}
+	vals := gpioLineValues{Mask: 1}
+	if v != 0 {
+		vals.Bits = 1
+	}
+	return ioctl(l.fd, gpioLineSetValuesIoctl, unsafe.Pointer(&vals))
+}
+
+// Close releases all requested lines
+func (c *Chip) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []string
+	for pin, l := range c.lines {
+		if err := l.f.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+		delete(c.lines, pin)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Couldn't release all lines:%v", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Watch enables edge detection on the input line and reads the line events
+func (c *Chip) Watch(pin string, stop <-chan struct{}) (<-chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, err := c.line(pin)
+	if err != nil {
+		return nil, err
+	}
+	if l.flags&gpioLineFlagInput == 0 {
+		return nil, fmt.Errorf("Pin %v is not an input", pin)
+	}
+	if err := c.reconfigure(l, l.flags|gpioLineFlagEdgeRising|gpioLineFlagEdgeFalling, 0); err != nil {
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		<-stop
+		// unblocks the reader below
+		l.f.SetReadDeadline(time.Now())
+	}()
+	go func() {
+		buf := make([]byte, 16*unsafe.Sizeof(gpioLineEvent{}))
+		for {
+			if _, err := l.f.Read(buf); err != nil {
+				select {
+				case <-stop:
+				default:
+					log.Printf("Stopped watching pin %v because %v", pin, err)
+				}
+				return
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes, nil
+}
+
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,207 @@
+//go:build linux && gpiosim
+// +build linux,gpiosim
+
+package rpiGpio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The chip backend is tested against the gpio-sim kernel module, run as root with
+//
+//	modprobe gpio-sim && go test -tags gpiosim -run Chip ./rpiGpio/
+//
+// The tests are skipped when gpio-sim isn't loaded.
+const gpioSimRoot = "/sys/kernel/config/gpio-sim"
+
+// simChip is a simulated gpio chip with 8 lines, line 3 is named TEST_LINE
+type simChip struct {
+	dir  string
+	dev  string
+	name string
+}
+
+func newSimChip(t *testing.T) *simChip {
+	t.Helper()
+	if _, err := os.Stat(gpioSimRoot); err != nil {
+		t.Skip("gpio-sim isn't loaded:", err)
+	}
+	s := &simChip{dir: filepath.Join(gpioSimRoot, fmt.Sprintf("rpi-web-control-%v", os.Getpid()))}
+	for _, d := range []string{s.dir, filepath.Join(s.dir, "bank0"), filepath.Join(s.dir, "bank0", "line3")} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			s.remove()
+			t.Fatal(err)
+		}
+	}
+	s.write(t, "bank0/num_lines", "8")
+	s.write(t, "bank0/line3/name", "TEST_LINE")
+	s.write(t, "live", "1")
+	t.Cleanup(s.remove)
+	s.dev = s.read(t, "dev_name")
+	s.name = s.read(t, "bank0/chip_name")
+	return s
+}
+
+func (s *simChip) write(t *testing.T, file, v string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(s.dir, file), []byte(v), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *simChip) read(t *testing.T, file string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// linePath is the sysfs attribute of the simulated line like value or pull
+func (s *simChip) linePath(offset int, attr string) string {
+	return filepath.Join("/sys/devices/platform", s.dev, s.name, fmt.Sprintf("sim_gpio%v", offset), attr)
+}
+
+// value is the physical level the simulated line is driven to
+func (s *simChip) value(t *testing.T, offset int) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(s.linePath(offset, "value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// pull drives the simulated input like an external device
+func (s *simChip) pull(t *testing.T, offset int, p string) {
+	t.Helper()
+	if err := ioutil.WriteFile(s.linePath(offset, "pull"), []byte(p), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *simChip) remove() {
+	ioutil.WriteFile(filepath.Join(s.dir, "live"), []byte("0"), 0644)
+	os.Remove(filepath.Join(s.dir, "bank0", "line3"))
+	os.Remove(filepath.Join(s.dir, "bank0"))
+	os.Remove(s.dir)
+}
+
+func (s *simChip) open(t *testing.T) *Chip {
+	t.Helper()
+	c, err := NewChip(filepath.Join("/dev", s.name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestChipWriteRead(t *testing.T) {
+	s := newSimChip(t)
+	c := s.open(t)
+	if err := c.Export("0"); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Exported("0") {
+		t.Fatal("line 0 isn't exported")
+	}
+	if err := c.SetDirection("0", Out); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []int{1, 0, 1} {
+		if err := c.Write("0", v); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.value(t, 0); got != fmt.Sprint(v) {
+			t.Fatalf("wrote %v, the simulated line is %v", v, got)
+		}
+		if got, err := c.Read("0"); err != nil || got != v {
+			t.Fatalf("wrote %v, read back %v:%v", v, got, err)
+		}
+	}
+	if err := c.Unexport("0"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Exported("0") {
+		t.Fatal("line 0 is still exported")
+	}
+}
+
+func TestChipLineName(t *testing.T) {
+	s := newSimChip(t)
+	c := s.open(t)
+	if err := c.Export("TEST_LINE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetOutput("TEST_LINE", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.value(t, 3); got != "1" {
+		t.Fatalf("the line named TEST_LINE is %v, expected 1", got)
+	}
+	if err := c.Export("NO_SUCH_LINE"); err == nil {
+		t.Fatal("exporting an unknown line name didn't fail")
+	}
+	if o, err := c.Line("TEST_LINE"); err != nil || o != "3" {
+		t.Fatalf("the line named TEST_LINE resolves to %q:%v, expected 3", o, err)
+	}
+	if _, err := c.Line("NO_SUCH_LINE"); err == nil {
+		t.Fatal("resolving an unknown line name didn't fail")
+	}
+}
+
+func TestChipActiveLowOutput(t *testing.T) {
+	s := newSimChip(t)
+	c := s.open(t)
+	if err := c.Export("2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetActiveLow("2", true); err != nil {
+		t.Fatal(err)
+	}
+	// on is the physical low and the line never goes through a physical low while it is off
+	if err := c.SetOutput("2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.value(t, 2); got != "1" {
+		t.Fatalf("an active-low output that is off is %v, expected 1", got)
+	}
+	if err := c.Write("2", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.value(t, 2); got != "0" {
+		t.Fatalf("an active-low output that is on is %v, expected 0", got)
+	}
+}
+
+func TestChipEdgeEvent(t *testing.T) {
+	s := newSimChip(t)
+	c := s.open(t)
+	s.pull(t, 1, "pull-down")
+	if err := c.Export("1"); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	changes, err := c.Watch("1", stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.pull(t, 1, "pull-up")
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("no edge event after pulling the input up")
+	}
+	if v, err := c.Read("1"); err != nil || v != 1 {
+		t.Fatalf("the input is %v:%v after pulling it up", v, err)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build !linux
+// +build !linux
+
+package rpiGpio
+
+import "errors"
+
+// DefaultChip is the gpio character device of the main Raspberry Pi header
+const DefaultChip = "/dev/gpiochip0"
+
+// Chip is a Backend that uses the gpio character device, it is only available on linux
+type Chip struct {
+	Backend
+}
+
+// NewChip always fails because the gpio character device exists only on linux
+func NewChip(path string) (*Chip, error) {
+	return nil, errors.New("The gpio character device is only supported on linux")
+}
+
+// SetBias is not supported outside linux
+func (c *Chip) SetBias(pin string, b Bias) error {
+	return errors.New("The gpio character device is only supported on linux")
+}
+
+// SetActiveLow is not supported outside linux
+func (c *Chip) SetActiveLow(pin string, on bool) error {
+	return errors.New("The gpio character device is only supported on linux")
+}
+
+// SetOutput is not supported outside linux
+func (c *Chip) SetOutput(pin string, v int) error {
+	return errors.New("The gpio character device is only supported on linux")
+}
+
+// ExportOutput is not supported outside linux
+func (c *Chip) ExportOutput(pin string, v int) error {
+	return errors.New("The gpio character device is only supported on linux")
+}
+
+// Line is not supported outside linux
+func (c *Chip) Line(name string) (string, error) {
+	return "", errors.New("The gpio character device is only supported on linux")
+}
+
+// Close is not supported outside linux
+func (c *Chip) Close() error {
+	return nil
+}
@@ -0,0 +1,45 @@
+package rpiGpio
+
+import "fmt"
+
+// Error codes so callers can tell the failures apart without parsing the messages
+const (
+	CodeInvalidType  = "invalid_type"
+	CodeInvalidPin   = "invalid_pin"
+	CodeInvalidDelay = "invalid_delay"
+	CodeInvalidLevel = "invalid_level"
+	CodeGPIO         = "gpio_failure"
+	// CodePinBusy is returned when a timer is rejected because the pin has an active timer
+	CodePinBusy         = "pin_busy"
+	CodeInvalidConflict = "invalid_conflict"
+	CodeUnknownJob      = "unknown_job"
+	CodeJobFinished     = "job_finished"
+	CodeInvalidPWM      = "invalid_pwm"
+	CodeInvalidSequence = "invalid_sequence"
+	// CodeInterlock is returned instead of switching on a pin while another pin of its interlock group is on
+	CodeInterlock = "interlock"
+	// CodeLimit is returned when a safety limit of the pin doesn't allow the change
+	CodeLimit = "limit"
+)
+
+// Error is returned by the control setters and Run
+type Error struct {
+	Code string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// ErrorCode returns the code of an Error or CodeGPIO for any other error
+func ErrorCode(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return CodeGPIO
+}
+
+func newError(code string, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Err: fmt.Errorf(format, a...)}
+}
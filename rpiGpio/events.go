@@ -0,0 +1,178 @@
+package rpiGpio
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is how many events a slow subscriber can fall behind before events are dropped for it
+const subscriberBuffer = 64
+
+// Event is a change reported to subscribers
+type Event struct {
+	Type  string `json:"type"`
+	Pin   string `json:"pin"`
+	Value int    `json:"value"`
+	Edge  Edge   `json:"edge,omitempty"`
+	// Job is the id of the job that caused the event
+	Job  string    `json:"job,omitempty"`
+	Time time.Time `json:"time"`
+	// Deadline is when a started timer expires
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// Duty is the new PWM duty cycle in percent
+	Duty *float64 `json:"duty,omitempty"`
+	// Reason is why a limit stopped a change or switched the pin off
+	Reason string `json:"reason,omitempty"`
+}
+
+// Event types
+const (
+	EventEdge       = "edge"
+	EventOutput     = "output"
+	EventTimerStart = "timer-start"
+	EventTimerEnd   = "timer-end"
+	// EventJob is sent when a job is queued or ends, get the job for its state
+	EventJob = "job"
+	// EventPWM is sent when the PWM duty cycle is set and when a ramp starts and ends
+	EventPWM = "pwm"
+	// EventLimit is sent when a safety limit of the pin refuses a change
+	EventLimit = "limit"
+	// EventForcedOff is sent when a pin is switched off because it was on for longer than its limit
+	EventForcedOff = "forced-off"
+)
+
+// PinState is the last known state of a pin
+type PinState struct {
+	Pin       string    `json:"pin"`
+	Direction Direction `json:"direction"`
+	Value     int       `json:"value"`
+	Time      time.Time `json:"time"`
+	// Deadline is set while a timer is pending for the pin
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// Duty is set while the pin is driven with PWM
+	Duty *float64 `json:"duty,omitempty"`
+}
+
+var (
+	events = newBroadcaster()
+
+	statesMu sync.Mutex
+	states   = make(map[string]*PinState)
+)
+
+// Subscribe returns a channel that receives every output change, timer start and expiry and input edge.
+// Call the returned function to unsubscribe.
+func Subscribe() (<-chan Event, func()) {
+	return events.subscribe()
+}
+
+// States returns the last known state of all pins that changed since the start, sorted by pin
+func States() []PinState {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	s := make([]PinState, 0, len(states))
+	for _, p := range states {
+		s = append(s, *p)
+	}
+	sort.Slice(s, func(i, j int) bool {
+		a, errA := strconv.Atoi(s[i].Pin)
+		b, errB := strconv.Atoi(s[j].Pin)
+		if errA != nil || errB != nil {
+			return s[i].Pin < s[j].Pin
+		}
+		return a < b
+	})
+	return s
+}
+
+// State returns the last known state of the pin
+func State(pin string) (PinState, bool) {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	p, ok := states[pin]
+	if !ok {
+		return PinState{Pin: pin}, false
+	}
+	return *p, true
+}
+
+// notify records the pin state and sends the event to all subscribers
+func notify(e Event) {
+	statesMu.Lock()
+	p, ok := states[e.Pin]
+	if !ok {
+		p = &PinState{Pin: e.Pin}
+		states[e.Pin] = p
+	}
+	p.Time = e.Time
+	switch e.Type {
+	case EventEdge:
+		p.Direction = In
+		p.Value = e.Value
+	case EventOutput:
+		p.Direction = Out
+		p.Value = e.Value
+		p.Duty = nil
+	case EventPWM:
+		p.Direction = Out
+		p.Duty = e.Duty
+	case EventTimerStart:
+		p.Deadline = e.Deadline
+	case EventTimerEnd:
+		p.Deadline = nil
+	}
+	statesMu.Unlock()
+
+	events.publish(e)
+}
+
+// broadcaster fans out events to all subscribers.
+// Sending never blocks, a subscriber that doesn't keep up misses events.
+type broadcaster struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{subs: make(map[chan Event]struct{})}
+}
+
+// subscribe returns the events channel and a function that unsubscribes and closes it
+func (b *broadcaster) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *broadcaster) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// close unsubscribes everybody
+func (b *broadcaster) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
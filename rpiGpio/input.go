@@ -0,0 +1,253 @@
+package rpiGpio
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PollInterval is how often inputs are read on backends that can't notify about level changes
+var PollInterval = 20 * time.Millisecond
+
+// Input watches a digital input pin like a door reed switch or a push button
+type Input struct {
+	pin      string
+	bias     Bias
+	edge     Edge
+	debounce time.Duration
+	backend  Backend
+
+	subs *broadcaster
+
+	mu    sync.Mutex
+	value int
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewInput creates an input for the pin, it reports both edges without debouncing unless configured otherwise
+func NewInput(pin string, opts ...func(*Input) error) (*Input, error) {
+	pin, err := ResolvePin(pin)
+	if err != nil {
+		return nil, err
+	}
+	in := &Input{
+		pin:     pin,
+		edge:    Both,
+		backend: DefaultBackend,
+		subs:    newBroadcaster(),
+	}
+	for _, o := range opts {
+		if err := o(in); err != nil {
+			return nil, err
+		}
+	}
+	// sysfs can't set the pull resistors, the input fails now rather than when it starts
+	if _, ok := in.backend.(Biaser); !ok && in.bias != BiasDefault {
+		return nil, fmt.Errorf("The gpio backend can't set the pull resistor of pin %v, use the chip backend or set it in config.txt", in.pin)
+	}
+	return in, nil
+}
+
+// SetPull sets the pull resistor of the input: up, down, off or empty to leave it as it is
+func SetPull(d string) func(*Input) error {
+	return func(in *Input) error {
+		switch strings.TrimSpace(d) {
+		case "":
+			in.bias = BiasDefault
+		case "up", string(PullUp):
+			in.bias = PullUp
+		case "down", string(PullDown):
+			in.bias = PullDown
+		case "off", string(BiasDisabled):
+			in.bias = BiasDisabled
+		default:
+			return errors.New("Invalid pull setting:" + d + ", use up, down or off")
+		}
+		return nil
+	}
+}
+
+// SetEdge selects which level changes are reported: rising, falling or both
+func SetEdge(d string) func(*Input) error {
+	return func(in *Input) error {
+		switch e := Edge(strings.TrimSpace(d)); e {
+		case "":
+			in.edge = Both
+		case Rising, Falling, Both:
+			in.edge = e
+		default:
+			return errors.New("Invalid edge:" + d + ", use rising, falling or both")
+		}
+		return nil
+	}
+}
+
+// SetDebounce sets how long the level must be stable before a change is reported
+func SetDebounce(d string) func(*Input) error {
+	return func(in *Input) error {
+		if d == "" {
+			in.debounce = 0
+			return nil
+		}
+		t, err := time.ParseDuration(d)
+		if err != nil || t < 0 {
+			return fmt.Errorf("Invalid debounce format :%v (use 10ms, 1s)", d)
+		}
+		in.debounce = t
+		return nil
+	}
+}
+
+// SetInputBackend sets the backend used to read the pin, the default is DefaultBackend
+func SetInputBackend(b Backend) func(*Input) error {
+	return func(in *Input) error {
+		if b == nil {
+			return errors.New("Backend can't be nil")
+		}
+		in.backend = b
+		return nil
+	}
+}
+
+// Pin returns the watched pin
+func (in *Input) Pin() string {
+	return in.pin
+}
+
+// Value returns the last debounced level of the input
+func (in *Input) Value() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.value
+}
+
+// Subscribe returns a channel that receives the edges of the input.
+// Call the returned function to unsubscribe, the channel is closed also when the input is closed.
+func (in *Input) Subscribe() (<-chan Event, func()) {
+	return in.subs.subscribe()
+}
+
+// Start configures the pin as an input and starts watching it
+func (in *Input) Start() error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.stop != nil {
+		return errors.New("Input " + in.pin + " is already started")
+	}
+
+	if err := in.backend.Export(in.pin); err != nil {
+		return err
+	}
+	if err := in.backend.SetDirection(in.pin, In); err != nil {
+		return err
+	}
+	if in.bias != BiasDefault {
+		if err := in.backend.(Biaser).SetBias(in.pin, in.bias); err != nil {
+			return err
+		}
+	}
+	v, err := in.backend.Read(in.pin)
+	if err != nil {
+		return err
+	}
+	in.value = v
+	in.stop = make(chan struct{})
+	in.done = make(chan struct{})
+
+	var changes <-chan struct{}
+	if w, ok := in.backend.(Watcher); ok {
+		if changes, err = w.Watch(in.pin, in.stop); err != nil {
+			log.Printf("Can't watch pin %v, will poll it instead because %v", in.pin, err)
+		}
+	}
+	if changes == nil {
+		changes = in.poll(v, in.stop)
+	}
+	go in.run(changes, in.stop, in.done)
+	return nil
+}
+
+// Close stops watching the pin and closes all subscriptions
+func (in *Input) Close() {
+	in.mu.Lock()
+	stop, done := in.stop, in.done
+	in.stop = nil
+	in.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+	in.subs.close()
+}
+
+// poll reads the pin periodically for backends that can't notify about changes
+func (in *Input) poll(last int, stop <-chan struct{}) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	go func() {
+		t := time.NewTicker(PollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+			v, err := in.backend.Read(in.pin)
+			if err != nil || v == last {
+				continue
+			}
+			last = v
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes
+}
+
+// run debounces the raw changes and reports the edges to the subscribers
+func (in *Input) run(changes <-chan struct{}, stop <-chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	settle := time.NewTimer(time.Hour)
+	settle.Stop()
+	for {
+		select {
+		case <-stop:
+			settle.Stop()
+			return
+		case <-changes:
+			// wait for the level to stay the same for the whole debounce period
+			settle.Reset(in.debounce)
+			continue
+		case <-settle.C:
+		}
+
+		v, err := in.backend.Read(in.pin)
+		if err != nil {
+			log.Printf("Can't read input pin %v because %v", in.pin, err)
+			continue
+		}
+		in.mu.Lock()
+		changed := v != in.value
+		in.value = v
+		in.mu.Unlock()
+		if !changed {
+			continue
+		}
+		e := Event{Type: EventEdge, Pin: in.pin, Value: v, Edge: Rising, Time: time.Now()}
+		if v == 0 {
+			e.Edge = Falling
+		}
+		if in.edge == Both || in.edge == e.Edge {
+			in.subs.publish(e)
+			notify(e)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package rpiGpio
+
+import (
+	"testing"
+	"time"
+)
+
+func startInput(t *testing.T, pin string, opts ...func(*Input) error) (*Input, <-chan Event) {
+	t.Helper()
+	in, err := NewInput(pin, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, _ := in.Subscribe()
+	if err := in.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(in.Close)
+	return in, events
+}
+
+// edges returns the edges reported until nothing happens for the wait
+func edges(events <-chan Event, wait time.Duration) []Edge {
+	var l []Edge
+	for {
+		select {
+		case e := <-events:
+			l = append(l, e.Edge)
+		case <-time.After(wait):
+			return l
+		}
+	}
+}
+
+func TestInputDebounce(t *testing.T) {
+	sim := NewSim()
+	in, events := startInput(t, "22", SetInputBackend(sim), SetDebounce("50ms"))
+
+	// a bouncing contact inside the debounce window is a single edge
+	for _, v := range []int{1, 0, 1, 0, 1} {
+		if err := sim.Inject("22", v); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if l := edges(events, 200*time.Millisecond); len(l) != 1 || l[0] != Rising {
+		t.Fatalf("the bounces were reported as %v, expected one rising edge", l)
+	}
+	if in.Value() != 1 {
+		t.Fatal("the input doesn't have the settled level")
+	}
+
+	// a bounce back to the old level isn't reported at all
+	sim.Inject("22", 0)
+	time.Sleep(5 * time.Millisecond)
+	sim.Inject("22", 1)
+	if l := edges(events, 200*time.Millisecond); len(l) != 0 {
+		t.Fatalf("a bounce that settled on the same level was reported as %v", l)
+	}
+}
+
+func TestInputPoll(t *testing.T) {
+	sim := NewSim()
+	// the backend can't notify about changes so the input is polled
+	in, events := startInput(t, "23", SetInputBackend(plainBackend{sim}), SetEdge("falling"))
+	sim.Inject("23", 1)
+	time.Sleep(5 * PollInterval)
+	if in.Value() != 1 {
+		t.Fatal("the poll didn't pick up the level")
+	}
+	sim.Inject("23", 0)
+	if l := edges(events, 5*PollInterval); len(l) != 1 || l[0] != Falling {
+		t.Fatalf("the polled input reported %v, expected only the falling edge", l)
+	}
+}
+
+func TestInputPull(t *testing.T) {
+	sim := NewSim()
+	// a backend without pull resistors fails when the input is created, not when it starts
+	if _, err := NewInput("24", SetPull("up"), SetInputBackend(plainBackend{sim})); err == nil {
+		t.Fatal("a pull setting was accepted by a backend that can't set it")
+	}
+	if _, err := NewInput("24", SetInputBackend(plainBackend{sim})); err != nil {
+		t.Fatal(err)
+	}
+	in, _ := startInput(t, "24", SetPull("up"), SetInputBackend(sim))
+	if in.Value() != 1 {
+		t.Fatal("the pull up didn't set the level of the input")
+	}
+}
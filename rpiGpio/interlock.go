@@ -0,0 +1,92 @@
+package rpiGpio
+
+import (
+	"fmt"
+	"time"
+)
+
+// Interlock is a group of pins where at most one may be on, like the up and down relays of a motor
+type Interlock struct {
+	Name string
+	Pins []string
+	// DeadTime is how long the other pins must be off before a pin of the group is switched on
+	DeadTime time.Duration
+}
+
+// interlocks are guarded by guardMu
+var interlocks []Interlock
+
+// SetInterlocks replaces the interlock groups, a pin can be in more than one group.
+// The pins are matched by their gpio number or line offset so a line name can't bypass an interlock.
+func SetInterlocks(l []Interlock) error {
+	groups := make([]Interlock, 0, len(l))
+	for _, g := range l {
+		if len(g.Pins) < 2 {
+			return fmt.Errorf("Interlock %v needs at least 2 pins", g.Name)
+		}
+		seen := make(map[string]bool)
+		pins := make([]string, 0, len(g.Pins))
+		for _, p := range g.Pins {
+			pin, err := ResolvePin(p)
+			if err != nil {
+				return fmt.Errorf("Interlock %v:%v", g.Name, err)
+			}
+			if seen[pin] {
+				return fmt.Errorf("Interlock %v has pin %v more than once", g.Name, p)
+			}
+			seen[pin] = true
+			pins = append(pins, pin)
+		}
+		g.Pins = pins
+		groups = append(groups, g)
+		if g.DeadTime < 0 {
+			return fmt.Errorf("Interlock %v has a negative dead time:%v", g.Name, g.DeadTime)
+		}
+	}
+	guardMu.Lock()
+	defer guardMu.Unlock()
+	interlocks = groups
+	return nil
+}
+
+// checkInterlocks fails when another pin of a group of the pin is on or was switched off less than the dead time ago,
+// the caller holds guardMu
+func (c *Control) checkInterlocks() error {
+	for _, g := range interlocks {
+		if !g.has(c.pin) {
+			continue
+		}
+		for _, p := range g.Pins {
+			if p == c.pin {
+				continue
+			}
+			on, known := pinOn[p]
+			if !known && c.backend.Exported(p) {
+				// only read with the polarity of the pin, the pin isn't changed
+				other := &Control{pin: p, backend: c.backend}
+				v, err := other.read()
+				if err != nil {
+					return newError(CodeInterlock, "Interlock %v: couldn't read pin %v:%v", g.Name, p, err)
+				}
+				on = v == 1
+			}
+			if on {
+				return newError(CodeInterlock, "Interlock %v: pin %v can't be switched on while pin %v is on", g.Name, c.pin, p)
+			}
+			if wait := time.Until(pinOff[p].Add(g.DeadTime)); wait > 0 {
+				return newError(CodeInterlock, "Interlock %v: pin %v can be switched on %v after pin %v was switched off, wait %v",
+					g.Name, c.pin, g.DeadTime, p, wait.Round(time.Millisecond))
+			}
+		}
+	}
+	return nil
+}
+
+func (g Interlock) has(pin string) bool {
+	for _, p := range g.Pins {
+		if p == pin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package rpiGpio
+
+import (
+	"fmt"
+	"testing"
+)
+
+// namedSim is a simulated board with gpio line names like the gpio character device
+type namedSim struct {
+	*countingSim
+	names map[string]string
+}
+
+func (n *namedSim) Line(name string) (string, error) {
+	o, ok := n.names[name]
+	if !ok {
+		return "", fmt.Errorf("No line named %v", name)
+	}
+	return o, nil
+}
+
+// forgetPins clears the state kept for the pins when the test ends so the tests can run again
+func forgetPins(t *testing.T, pins ...string) {
+	t.Cleanup(func() {
+		guardMu.Lock()
+		for _, p := range pins {
+			delete(pinOn, p)
+			delete(pinOff, p)
+			delete(onSince, p)
+			delete(activations, p)
+		}
+		guardMu.Unlock()
+		polarityMu.Lock()
+		for _, p := range pins {
+			delete(activeLow, p)
+		}
+		polarityMu.Unlock()
+	})
+}
+
+func setInterlocks(t *testing.T, l ...Interlock) {
+	t.Helper()
+	if err := SetInterlocks(l); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetInterlocks(nil) })
+}
+
+func TestInterlockLineName(t *testing.T) {
+	b := &namedSim{countingSim: newCountingSim(), names: map[string]string{"MOTOR_UP": "22", "MOTOR_DOWN": "23"}}
+	forgetPins(t, "22", "23")
+	setInterlocks(t, Interlock{Name: "motor", Pins: []string{"22", "23"}})
+
+	set := func(pin, level string) error {
+		c, err := NewControl(SetType("set"), SetPin(pin), SetLevel(level), SetBackend(b))
+		if err != nil {
+			return err
+		}
+		_, err = c.Run()
+		return err
+	}
+	if err := set("23", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := set("MOTOR_UP", "1"); err == nil || err.(*Error).Code != CodeInterlock {
+		t.Fatalf("the line name of an interlocked pin switched on while the other pin is on:%v", err)
+	}
+	if simLevel(b.Sim, "22") != 0 {
+		t.Fatal("the interlocked pin is on")
+	}
+	if err := set("MOTOR_DOWN", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := set("MOTOR_UP", "1"); err != nil {
+		t.Fatalf("the interlocked pin didn't switch on after the other pin was switched off by its line name:%v", err)
+	}
+	if simLevel(b.Sim, "22") != 1 {
+		t.Fatal("the line name didn't switch its line")
+	}
+	if _, err := NewControl(SetPin("NO_SUCH_LINE"), SetBackend(b)); err == nil {
+		t.Fatal("an unknown line name didn't fail")
+	}
+}
+
+func TestInterlockNameInGroup(t *testing.T) {
+	old := DefaultBackend
+	defer func() { DefaultBackend = old }()
+	b := &namedSim{countingSim: newCountingSim(), names: map[string]string{"PUMP": "24"}}
+	DefaultBackend = b
+	forgetPins(t, "24", "25")
+
+	if err := SetInterlocks([]Interlock{{Name: "pump", Pins: []string{"PUMP", "24"}}}); err == nil {
+		t.Fatal("an interlock with a line name and the number of the same line didn't fail")
+	}
+	setInterlocks(t, Interlock{Name: "pump", Pins: []string{"PUMP", "25"}})
+	c, _ := NewControl(SetType("set"), SetPin("25"), SetLevel("1"))
+	if _, err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	c, _ = NewControl(SetType("set"), SetPin("24"), SetLevel("1"))
+	if _, err := c.Run(); err == nil {
+		t.Fatal("the number of a line named in an interlock bypassed the interlock")
+	}
+}
+
+// TestInterlockRead checks that the other pin of a group, exported by a previous run, is only read
+func TestInterlockRead(t *testing.T) {
+	b := newCountingSim()
+	forgetPins(t, "7", "8", "9", "10")
+	setInterlocks(t,
+		Interlock{Name: "off", Pins: []string{"7", "8"}},
+		Interlock{Name: "on", Pins: []string{"9", "10"}},
+	)
+	for pin, physical := range map[string]int{"8": 1, "10": 0} {
+		if err := SetPinActiveLow(pin, true); err != nil {
+			t.Fatal(err)
+		}
+		b.Sim.Export(pin)
+		b.Sim.SetActiveLow(pin, true)
+		b.Sim.SetOutput(pin, physical^1)
+	}
+	before := b.setCount("8") + b.setCount("10")
+
+	c, _ := NewControl(SetType("set"), SetPin("7"), SetLevel("1"), SetBackend(b))
+	if _, err := c.Run(); err != nil {
+		t.Fatalf("the pin didn't switch on while the active-low pin of the group is high:%v", err)
+	}
+	c, _ = NewControl(SetType("set"), SetPin("9"), SetLevel("1"), SetBackend(b))
+	if _, err := c.Run(); err == nil {
+		t.Fatal("the pin switched on while the active-low pin of the group is low")
+	}
+	if after := b.setCount("8") + b.setCount("10"); after != before {
+		t.Fatal("reading the other pin of the group set its active-low")
+	}
+	if simLevel(b.Sim, "8") != 1 || simLevel(b.Sim, "10") != 0 {
+		t.Fatal("reading the other pin of the group changed it")
+	}
+}
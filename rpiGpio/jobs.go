@@ -0,0 +1,433 @@
+package rpiGpio
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job states
+const (
+	// JobPending is a timer queued behind the timer that holds the pin
+	JobPending   = "pending"
+	JobActive    = "active"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// What happens when a timer is started for a pin that already has an active timer
+const (
+	// ConflictRestart replaces the active timer, the pin stays on until the new deadline
+	ConflictRestart = "restart"
+	// ConflictExtend adds the delay to the deadline of the active timer and returns that job
+	ConflictExtend = "extend"
+	// ConflictReject fails with CodePinBusy
+	ConflictReject = "reject"
+	// ConflictQueue starts the new timer when the active one ends
+	ConflictQueue = "queue"
+)
+
+// DefaultConflict is the conflict policy when none is set
+const DefaultConflict = ConflictRestart
+
+// maxFinishedJobs is how many finished jobs are kept for the job list
+const maxFinishedJobs = 100
+
+// Job is a single Run of a control
+type Job struct {
+	ID       string     `json:"id"`
+	Pin      string     `json:"pin"`
+	Type     string     `json:"type"`
+	State    string     `json:"state"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+	Ended    *time.Time `json:"ended,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	// Step is the running step of a sequence out of its Steps
+	Step  int `json:"step,omitempty"`
+	Steps int `json:"steps,omitempty"`
+}
+
+// job is the running job with its control and cancellation
+type job struct {
+	Job
+	c      *Control
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	// replaced is set when a restart hands the pin to a new timer so it isn't switched off
+	replaced bool
+	// restarts is the timer this one replaced, the pin is still on from it until this one starts
+	restarts *job
+}
+
+var (
+	jobsMu   sync.Mutex
+	jobs     = make(map[string]*job)
+	finished []string
+	// active is the timer that holds each pin and queued are the timers waiting for it
+	active = make(map[string]*job)
+	queued = make(map[string][]*job)
+	// shuttingDown stops starting queued timers and keeps the journal for the next start
+	shuttingDown bool
+)
+
+// newJob registers a job for the control, the caller holds jobsMu
+func newJob(c *Control, state string) *job {
+	b := make([]byte, 4)
+	rand.Read(b)
+	ctx, cancel := context.WithCancel(c.ctx)
+	j := &job{
+		Job:    Job{ID: hex.EncodeToString(b), Pin: c.pin, Type: c.ctype, State: state, Created: time.Now()},
+		c:      c,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	jobs[j.ID] = j
+	return j
+}
+
+// endJob sets the final state of a job, the caller holds jobsMu
+func endJob(j *job, state string, err error) {
+	now := time.Now()
+	j.State = state
+	j.Ended = &now
+	if err != nil {
+		j.Error = err.Error()
+	}
+	j.cancel()
+	close(j.done)
+
+	finished = append(finished, j.ID)
+	if len(finished) > maxFinishedJobs {
+		delete(jobs, finished[0])
+		finished = finished[1:]
+	}
+	notify(Event{Type: EventJob, Pin: j.Pin, Job: j.ID, Time: now})
+}
+
+// runJob runs the toggle and set controls which finish right away
+func runJob(c *Control, run func() error) (Job, error) {
+	jobsMu.Lock()
+	j := newJob(c, JobActive)
+	now := time.Now()
+	j.Started = &now
+	jobsMu.Unlock()
+
+	err := run()
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if err != nil {
+		endJob(j, JobFailed, err)
+	} else {
+		endJob(j, JobDone, nil)
+	}
+	return j.Job, err
+}
+
+// startTimer starts a timer job, the conflict policy decides what happens when the pin already has one
+func (c *Control) startTimer() (Job, error) {
+	if err := checkDelay(c.pin, c.delay); err != nil {
+		return Job{}, err
+	}
+	jobsMu.Lock()
+	if cur, ok := active[c.pin]; ok {
+		switch c.conflict {
+		case ConflictReject:
+			id, deadline := cur.ID, *cur.Deadline
+			jobsMu.Unlock()
+			return Job{}, newError(CodePinBusy, "Pin %v is busy with job %v until %v", c.pin, id, deadline.Format(time.RFC3339))
+		case ConflictExtend:
+			if err := checkDelay(c.pin, time.Until(*cur.Deadline)+c.delay); err != nil {
+				jobsMu.Unlock()
+				return Job{}, err
+			}
+			d := cur.Deadline.Add(c.delay)
+			cur.Deadline = &d
+			if err := saveJournal(); err != nil {
+				log.Printf("Couldn't save the timer journal:%v", err)
+			}
+			s := cur.Job
+			jobsMu.Unlock()
+			notify(Event{Type: EventTimerStart, Pin: c.pin, Value: 1, Job: s.ID, Time: time.Now(), Deadline: &d})
+			return s, nil
+		case ConflictQueue:
+			j := newJob(c, JobPending)
+			queued[c.pin] = append(queued[c.pin], j)
+			go func() {
+				// a queued job can be cancelled before it starts
+				<-j.ctx.Done()
+				jobsMu.Lock()
+				defer jobsMu.Unlock()
+				if j.State == JobPending {
+					removeQueued(j)
+					endJob(j, JobCancelled, nil)
+				}
+			}()
+			s := j.Job
+			jobsMu.Unlock()
+			notify(Event{Type: EventJob, Pin: c.pin, Job: s.ID, Time: time.Now()})
+			return s, nil
+		default:
+			cur.replaced = true
+			cur.cancel()
+			j := c.activate()
+			j.restarts = cur
+			cur.Error = "restarted by job " + j.ID
+			jobsMu.Unlock()
+			return j.run()
+		}
+	}
+	j := c.activate()
+	jobsMu.Unlock()
+	return j.run()
+}
+
+// activate registers a timer job that holds the pin, the caller holds jobsMu
+func (c *Control) activate() *job {
+	j := newJob(c, JobActive)
+	d := time.Now().Add(c.delay)
+	j.Deadline = &d
+	active[c.pin] = j
+	return j
+}
+
+// run starts the job and returns its state after the start
+func (j *job) run() (Job, error) {
+	err := j.start()
+	return j.snapshot(), err
+}
+
+func (j *job) snapshot() Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return j.Job
+}
+
+// start switches the pin on and waits for the deadline in the background
+func (j *job) start() error {
+	c := j.c
+	unlock := lockPin(c.pin)
+	err := c.enablePin()
+	if err != nil {
+		log.Printf("I couldn't enable pin %v, because %v", c.pin, err)
+	} else {
+		err = c.write(1)
+	}
+	if err != nil {
+		jobsMu.Lock()
+		// the restarted timer didn't switch its pin off so it is switched off here,
+		// unless another restart or a queued timer took the pin over in the meantime
+		restarts := j.restarts
+		off := restarts != nil && active[c.pin] == j
+		next := j.release()
+		if next != nil {
+			next.restarts = restarts
+			off = false
+		}
+		endJob(j, JobFailed, err)
+		jobsMu.Unlock()
+		if off {
+			if err := c.write(0); err != nil {
+				log.Printf("Couldn't disable pin:%v error:%v", c.pin, err)
+			}
+			notify(Event{Type: EventTimerEnd, Pin: c.pin, Job: restarts.ID, Time: time.Now()})
+		}
+		unlock()
+		if next != nil {
+			next.start()
+		}
+		return err
+	}
+	unlock()
+
+	jobsMu.Lock()
+	now := time.Now()
+	j.Started = &now
+	deadline := *j.Deadline
+	if err := saveJournal(); err != nil {
+		log.Printf("Couldn't save the timer journal:%v", err)
+	}
+	jobsMu.Unlock()
+
+	notify(Event{Type: EventTimerStart, Pin: c.pin, Value: 1, Job: j.ID, Time: now, Deadline: &deadline})
+	go j.wait()
+	return nil
+}
+
+// wait switches the pin off at the deadline or when the job is cancelled
+func (j *job) wait() {
+	for {
+		jobsMu.Lock()
+		deadline := *j.Deadline
+		jobsMu.Unlock()
+
+		t := time.NewTimer(time.Until(deadline))
+		select {
+		case <-t.C:
+			jobsMu.Lock()
+			extended := j.Deadline.After(deadline)
+			jobsMu.Unlock()
+			if extended {
+				continue
+			}
+			j.finish(JobDone)
+			return
+		case <-j.ctx.Done():
+			t.Stop()
+			j.finish(JobCancelled)
+			return
+		}
+	}
+}
+
+// finish switches the pin off, unless a restart handed it to a new timer, and starts the next queued timer
+func (j *job) finish(state string) {
+	// a restart sets replaced before the new timer takes the pin lock to switch the pin on,
+	// so checking it under the pin lock never switches off the pin of the new timer
+	unlock := lockPin(j.Pin)
+	jobsMu.Lock()
+	replaced := j.replaced
+	jobsMu.Unlock()
+
+	if !replaced {
+		if err := j.c.write(0); err != nil {
+			log.Printf("Couldn't disable pin:%v error:%v", j.Pin, err)
+		}
+		notify(Event{Type: EventTimerEnd, Pin: j.Pin, Job: j.ID, Time: time.Now()})
+	}
+	unlock()
+
+	jobsMu.Lock()
+	next := j.release()
+	endJob(j, state, nil)
+	// only forget the timer once the pin is off so a crash in between still finishes it on the next start
+	if !shuttingDown {
+		if err := saveJournal(); err != nil {
+			log.Printf("Couldn't save the timer journal:%v", err)
+		}
+	}
+	jobsMu.Unlock()
+
+	if next != nil {
+		next.start()
+	}
+}
+
+// release gives up the pin and returns the next queued timer that takes it over, the caller holds jobsMu
+func (j *job) release() *job {
+	if active[j.Pin] != j {
+		return nil
+	}
+	delete(active, j.Pin)
+	if shuttingDown || len(queued[j.Pin]) == 0 {
+		return nil
+	}
+	next := queued[j.Pin][0]
+	removeQueued(next)
+	d := time.Now().Add(next.c.delay)
+	next.State = JobActive
+	next.Deadline = &d
+	active[j.Pin] = next
+	return next
+}
+
+// removeQueued takes the job out of the queue of its pin, the caller holds jobsMu
+func removeQueued(j *job) {
+	q := queued[j.Pin]
+	for i := range q {
+		if q[i] == j {
+			queued[j.Pin] = append(q[:i:i], q[i+1:]...)
+			break
+		}
+	}
+	if len(queued[j.Pin]) == 0 {
+		delete(queued, j.Pin)
+	}
+}
+
+// Jobs returns the pending, active and recently finished jobs, the newest first
+func Jobs() []Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	l := make([]Job, 0, len(jobs))
+	for _, j := range jobs {
+		l = append(l, j.Job)
+	}
+	sort.Slice(l, func(i, k int) bool { return l[i].Created.After(l[k].Created) })
+	return l
+}
+
+// GetJob returns the job with the id
+func GetJob(id string) (Job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j, ok := jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return j.Job, true
+}
+
+// CancelJob stops a pending or active job, an active timer switches its pin off
+func CancelJob(id string) (Job, error) {
+	jobsMu.Lock()
+	j, ok := jobs[id]
+	if !ok {
+		jobsMu.Unlock()
+		return Job{}, newError(CodeUnknownJob, "No job with id %v", id)
+	}
+	if j.State != JobPending && j.State != JobActive {
+		s := j.Job
+		jobsMu.Unlock()
+		return s, newError(CodeJobFinished, "Job %v is already %v", id, s.State)
+	}
+	j.cancel()
+	jobsMu.Unlock()
+
+	<-j.done
+	return j.snapshot(), nil
+}
+
+// TimerPending reports if a timer holds the pin
+func TimerPending(pin string) bool {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	_, ok := active[pin]
+	return ok
+}
+
+// FinishTimers switches off the pins of all pending timers and sequences right away, used before exiting
+// so that no relay is left energised. The active timers stay in the journal and the next start
+// switches the pins back on until their deadline, the queued timers are dropped.
+func FinishTimers() {
+	jobsMu.Lock()
+	shuttingDown = true
+	var pending []*job
+	for _, j := range active {
+		if j.Deadline != nil {
+			log.Printf("Finishing the timer of pin %v before the deadline %v", j.Pin, j.Deadline.Format(time.RFC3339))
+		}
+		pending = append(pending, j)
+	}
+	for _, q := range queued {
+		pending = append(pending, q...)
+	}
+	for _, j := range sequences {
+		pending = append(pending, j)
+	}
+	jobsMu.Unlock()
+
+	for _, j := range pending {
+		j.cancel()
+		<-j.done
+	}
+}
@@ -0,0 +1,123 @@
+package rpiGpio
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failingSim is a simulated board where switching the pins on can be made to fail
+type failingSim struct {
+	*Sim
+	mu     sync.Mutex
+	failOn bool
+}
+
+func (f *failingSim) Write(pin string, v int) error {
+	f.mu.Lock()
+	fail := f.failOn && v == 1
+	f.mu.Unlock()
+	if fail {
+		return errors.New("simulated write failure")
+	}
+	return f.Sim.Write(pin, v)
+}
+
+func (f *failingSim) fail(on bool) {
+	f.mu.Lock()
+	f.failOn = on
+	f.mu.Unlock()
+}
+
+// simLevel is the physical level of a simulated pin
+func simLevel(s *Sim, pin string) int {
+	for _, p := range s.Pins() {
+		if p.Pin == pin {
+			return p.Value
+		}
+	}
+	return 0
+}
+
+func newTimer(t *testing.T, b Backend, pin, delay string) *Control {
+	t.Helper()
+	c, err := NewControl(SetType("timer"), SetPin(pin), SetDelay(delay), SetBackend(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestTimerRestart(t *testing.T) {
+	sim := NewSim()
+	forgetPins(t, "5")
+	first, err := newTimer(t, sim, "5", "1h").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newTimer(t, sim, "5", "1h").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if simLevel(sim, "5") != 1 {
+		t.Fatal("the pin isn't on after a restart")
+	}
+	waitJob(t, first.ID, JobCancelled)
+	if simLevel(sim, "5") != 1 {
+		t.Fatal("the restarted timer switched the pin off")
+	}
+	for _, e := range sim.History() {
+		if e.Pin == "5" && e.Value == 0 {
+			t.Fatal("the pin went off during the restart")
+		}
+	}
+	if _, err := CancelJob(second.ID); err != nil {
+		t.Fatal(err)
+	}
+	if simLevel(sim, "5") != 0 {
+		t.Fatal("the pin is still on after cancelling the timer")
+	}
+}
+
+func TestTimerRestartFailure(t *testing.T) {
+	sim := &failingSim{Sim: NewSim()}
+	forgetPins(t, "6")
+	first, err := newTimer(t, sim, "6", "1h").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.fail(true)
+	j, err := newTimer(t, sim, "6", "1h").Run()
+	if err == nil {
+		t.Fatal("the restart didn't fail")
+	}
+	if j.State != JobFailed {
+		t.Fatalf("the failed restart is %v", j.State)
+	}
+	waitJob(t, first.ID, JobCancelled)
+	if simLevel(sim.Sim, "6") != 0 {
+		t.Fatal("the pin was left on after the restart failed")
+	}
+	if TimerPending("6") {
+		t.Fatal("a timer still holds the pin")
+	}
+}
+
+// waitJob waits for the job to end in the state
+func waitJob(t *testing.T, id, state string) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		j, ok := GetJob(id)
+		if !ok {
+			t.Fatalf("no job %v", id)
+		}
+		if j.Ended != nil {
+			if j.State != state {
+				t.Fatalf("job %v ended %v, expected %v", id, j.State, state)
+			}
+			return
+		}
+	}
+	t.Fatalf("job %v didn't end", id)
+}
@@ -0,0 +1,115 @@
+package rpiGpio
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// journalEntry is a pending timer saved in the journal
+type journalEntry struct {
+	Pin       string    `json:"pin"`
+	Deadline  time.Time `json:"deadline"`
+	ActiveLow bool      `json:"active_low,omitempty"`
+}
+
+// journalPath is the timer journal file, empty when the timers aren't persisted
+var journalPath string
+
+// OpenJournal persists the pending timers to the file so they are finished even after a crash or a restart.
+// The timers saved by the previous run are restored - the overdue ones switch their pins off right away
+// and the rest switch the pins back on until their deadline.
+func OpenJournal(path string) error {
+	var entries []journalEntry
+	d, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(d, &entries); err != nil {
+			return newError(CodeGPIO, "Invalid timer journal %v:%v", path, err)
+		}
+	}
+
+	jobsMu.Lock()
+	journalPath = path
+	jobsMu.Unlock()
+
+	for _, e := range entries {
+		c, err := NewControl(SetType("timer"), SetPin(e.Pin))
+		if err != nil {
+			log.Printf("Skipping the journal timer of pin %v:%v", e.Pin, err)
+			continue
+		}
+		// the polarity of a pin that isn't in the config anymore is the one it had when the timer started
+		if _, known := polarity(c.pin); !known {
+			SetPinActiveLow(c.pin, e.ActiveLow)
+		}
+		if !time.Now().Before(e.Deadline) {
+			log.Printf("Timer of pin %v expired at %v while the app wasn't running, switching it off", e.Pin, e.Deadline.Format(time.RFC3339))
+			c.ctype, c.level = "set", 0
+			if err := c.set(); err != nil {
+				log.Printf("Couldn't disable pin:%v error:%v", e.Pin, err)
+			}
+			continue
+		}
+		log.Printf("Restoring the timer of pin %v until %v", e.Pin, e.Deadline.Format(time.RFC3339))
+		// the pin is still on from the previous run
+		c.initial = 1
+		jobsMu.Lock()
+		j := c.activate()
+		d := e.Deadline
+		j.Deadline = &d
+		jobsMu.Unlock()
+		if err := j.start(); err != nil {
+			log.Printf("Couldn't restore the timer of pin %v:%v", e.Pin, err)
+		}
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return saveJournal()
+}
+
+// saveJournal writes the active timers, the caller holds jobsMu
+func saveJournal() error {
+	if journalPath == "" {
+		return nil
+	}
+	entries := make([]journalEntry, 0, len(active))
+	for _, j := range active {
+		// a timer that is still switching its pin on has no deadline yet
+		if j.Deadline == nil {
+			continue
+		}
+		entries = append(entries, journalEntry{Pin: j.Pin, Deadline: *j.Deadline, ActiveLow: isActiveLow(j.Pin)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Deadline.Before(entries[j].Deadline) })
+	d, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// write, sync and rename so a power loss leaves either the old or the new journal
+	tmp := journalPath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(d); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, journalPath)
+}
@@ -0,0 +1,124 @@
+package rpiGpio
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openJournal restores the journal on the simulator and stops persisting the timers after the test
+func openJournal(t *testing.T, entries string) (*Sim, string, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "timers.json")
+	if err := ioutil.WriteFile(path, []byte(entries), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sim := NewSim()
+	old := DefaultBackend
+	DefaultBackend = sim
+	t.Cleanup(func() {
+		DefaultBackend = old
+		jobsMu.Lock()
+		journalPath = ""
+		jobsMu.Unlock()
+	})
+	return sim, path, OpenJournal(path)
+}
+
+func readJournal(t *testing.T, path string) []journalEntry {
+	t.Helper()
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []journalEntry
+	if err := json.Unmarshal(d, &entries); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// activeJob is the active job of the pin
+func activeJob(t *testing.T, pin string) Job {
+	t.Helper()
+	for _, j := range Jobs() {
+		if j.Pin == pin && j.State == JobActive {
+			return j
+		}
+	}
+	t.Fatalf("pin %v has no active job", pin)
+	return Job{}
+}
+
+func TestJournalRestore(t *testing.T) {
+	forgetPins(t, "20", "21")
+	deadline := time.Now().Add(300 * time.Millisecond).Round(time.Millisecond)
+	entries, _ := json.Marshal([]journalEntry{
+		{Pin: "20", Deadline: time.Now().Add(-time.Minute)},
+		{Pin: "21", Deadline: deadline},
+	})
+	sim, path, err := openJournal(t, string(entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the overdue timer switched its pin off at the start
+	if simLevel(sim, "20") != 0 || TimerPending("20") {
+		t.Fatal("the overdue timer wasn't switched off")
+	}
+	// the running timer holds the pin until the deadline of the previous run
+	if simLevel(sim, "21") != 1 || !TimerPending("21") {
+		t.Fatal("the running timer wasn't restored")
+	}
+	j := activeJob(t, "21")
+	if j.Deadline == nil || !j.Deadline.Equal(deadline) {
+		t.Fatalf("the restored timer ends at %v, expected %v", j.Deadline, deadline)
+	}
+	for _, e := range sim.History() {
+		if e.Pin == "21" && e.Value == 0 {
+			t.Fatal("the restored timer switched its pin off for a moment")
+		}
+	}
+	if l := readJournal(t, path); len(l) != 1 || l[0].Pin != "21" || !l[0].Deadline.Equal(deadline) {
+		t.Fatalf("unexpected journal after the restore:%+v", l)
+	}
+
+	// extending the restored timer moves its deadline in the journal
+	c, _ := NewControl(SetType("timer"), SetPin("21"), SetDelay("100ms"), SetConflict(ConflictExtend))
+	if _, err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	deadline = deadline.Add(100 * time.Millisecond)
+	if l := readJournal(t, path); len(l) != 1 || !l[0].Deadline.Equal(deadline) {
+		t.Fatalf("the journal has %+v after extending the timer until %v", l, deadline)
+	}
+
+	waitJob(t, j.ID, JobDone)
+	if time.Now().Before(deadline) {
+		t.Fatal("the restored timer ended before its deadline")
+	}
+	if simLevel(sim, "21") != 0 {
+		t.Fatal("the restored timer didn't switch its pin off")
+	}
+	if l := readJournal(t, path); len(l) != 0 {
+		t.Fatalf("the finished timer is still in the journal:%+v", l)
+	}
+}
+
+func TestJournalCorrupt(t *testing.T) {
+	_, path, err := openJournal(t, `[{"pin":"21","deadline":`)
+	if err == nil || ErrorCode(err) != CodeGPIO {
+		t.Fatalf("a corrupt journal was opened:%v", err)
+	}
+	// the journal is left for a look and the timers aren't persisted to it
+	if d, _ := ioutil.ReadFile(path); string(d) != `[{"pin":"21","deadline":` {
+		t.Fatalf("the corrupt journal was overwritten:%s", d)
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if journalPath != "" {
+		t.Fatal("the timers are persisted to the corrupt journal")
+	}
+}
@@ -0,0 +1,180 @@
+package rpiGpio
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Limits are the safety limits of an output pin, the zero values are no limit
+type Limits struct {
+	// MaxOn switches the pin off when it stays on longer, whatever switched it on
+	MaxOn time.Duration
+	// MaxDelay is the longest timer, an extended timer included
+	MaxDelay time.Duration
+	// MinOff is how long the pin must stay off before it is switched on again
+	MinOff time.Duration
+	// MaxPerHour is how many times the pin can be switched on within an hour
+	MaxPerHour int
+}
+
+var (
+	guardMu sync.Mutex
+	limits  = make(map[string]Limits)
+	// pinOn is the last level written to each pin, pinOff when it was switched off,
+	// onSince when it was switched on and activations when it was switched on within the last hour
+	pinOn       = make(map[string]bool)
+	pinOff      = make(map[string]time.Time)
+	onSince     = make(map[string]time.Time)
+	activations = make(map[string][]time.Time)
+	// safetyHook gets the limit and the forced off events, set with SetSafetyHook
+	safetyHook func(Event)
+)
+
+// SetSafetyHook sets the function called with every EventLimit and EventForcedOff, also the ones of the background jobs
+// like a queued timer, a sequence or an initial on. Unlike a subscriber it never misses an event,
+// it is called synchronously with the locks of the pin held so it must not control the pins.
+func SetSafetyHook(f func(Event)) {
+	guardMu.Lock()
+	defer guardMu.Unlock()
+	safetyHook = f
+}
+
+// safety notifies the event to the subscribers and the safety hook, the caller holds guardMu
+func safety(e Event) {
+	notify(e)
+	if safetyHook != nil {
+		safetyHook(e)
+	}
+}
+
+// SetLimits sets the safety limits of the pin, the zero Limits removes them
+func SetLimits(pin string, l Limits) error {
+	pin, err := ResolvePin(pin)
+	if err != nil {
+		return err
+	}
+	if l.MaxOn < 0 || l.MaxDelay < 0 || l.MinOff < 0 || l.MaxPerHour < 0 {
+		return fmt.Errorf("The limits of pin %v can't be negative", pin)
+	}
+	guardMu.Lock()
+	defer guardMu.Unlock()
+	if l == (Limits{}) {
+		delete(limits, pin)
+	} else {
+		limits[pin] = l
+	}
+	return nil
+}
+
+// guard checks the interlocks and the limits of the pin before switching it on with write and records the new level.
+// A pin that stays on longer than its MaxOn is switched off. The caller holds the pin lock.
+func (c *Control) guard(on bool, write func() error) error {
+	guardMu.Lock()
+	defer guardMu.Unlock()
+	was, known := pinOn[c.pin]
+	// a pin that is already on, like during a PWM ramp, doesn't need to be checked again
+	switching := on && !was
+	now := time.Now()
+	if switching {
+		if err := c.checkInterlocks(); err != nil {
+			return err
+		}
+		if err := c.checkLimits(now); err != nil {
+			return err
+		}
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	if !on && (was || !known) {
+		pinOff[c.pin] = now
+	}
+	pinOn[c.pin] = on
+	if switching {
+		onSince[c.pin] = now
+		activations[c.pin] = append(activations[c.pin], now)
+		if max := limits[c.pin].MaxOn; max > 0 {
+			time.AfterFunc(max, func() { c.forceOff(now, max) })
+		}
+	}
+	return nil
+}
+
+// checkLimits fails when the pin can't be switched on yet, the caller holds guardMu
+func (c *Control) checkLimits(now time.Time) error {
+	l, ok := limits[c.pin]
+	if !ok {
+		return nil
+	}
+	if wait := pinOff[c.pin].Add(l.MinOff).Sub(now); l.MinOff > 0 && wait > 0 {
+		return limitError(c.pin, "Pin %v must stay off for %v, wait %v", c.pin, l.MinOff, wait.Round(time.Millisecond))
+	}
+	recent := activations[c.pin][:0]
+	for _, t := range activations[c.pin] {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	activations[c.pin] = recent
+	if l.MaxPerHour > 0 && len(recent) >= l.MaxPerHour {
+		return limitError(c.pin, "Pin %v was switched on %v times within an hour, the limit is %v", c.pin, len(recent), l.MaxPerHour)
+	}
+	return nil
+}
+
+// checkDelay fails when the timer delay is longer than the MaxDelay of the pin
+func checkDelay(pin string, d time.Duration) error {
+	guardMu.Lock()
+	defer guardMu.Unlock()
+	max := limits[pin].MaxDelay
+	if max > 0 && d > max {
+		return limitError(pin, "The timer of pin %v would take %v, the limit is %v", pin, d, max)
+	}
+	return nil
+}
+
+// limitError notifies the violation of a limit to the subscribers and the safety hook, the caller holds guardMu
+func limitError(pin string, format string, a ...interface{}) error {
+	err := newError(CodeLimit, format, a...)
+	log.Print(err)
+	safety(Event{Type: EventLimit, Pin: pin, Time: time.Now(), Reason: err.Error()})
+	return err
+}
+
+// forceOff switches the pin off when it is still on since the time, the jobs that hold the pin are cancelled
+func (c *Control) forceOff(since time.Time, max time.Duration) {
+	guardMu.Lock()
+	still := pinOn[c.pin] && onSince[c.pin].Equal(since)
+	guardMu.Unlock()
+	if !still {
+		return
+	}
+
+	jobsMu.Lock()
+	for _, j := range []*job{active[c.pin], ramps[c.pin], sequences[c.pin]} {
+		if j != nil {
+			j.cancel()
+		}
+	}
+	jobsMu.Unlock()
+
+	unlock := lockPin(c.pin)
+	defer unlock()
+	guardMu.Lock()
+	still = pinOn[c.pin] && onSince[c.pin].Equal(since)
+	guardMu.Unlock()
+	if !still {
+		return
+	}
+	if err := c.write(0); err != nil {
+		log.Printf("Couldn't switch off pin %v after its maximum on time:%v", c.pin, err)
+		return
+	}
+	reason := fmt.Sprintf("Pin %v was on for longer than %v", c.pin, max)
+	log.Print(reason)
+	guardMu.Lock()
+	safety(Event{Type: EventForcedOff, Pin: c.pin, Time: time.Now(), Reason: reason})
+	guardMu.Unlock()
+}
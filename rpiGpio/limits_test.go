@@ -0,0 +1,212 @@
+package rpiGpio
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// safetyEvents records the events of the safety hook
+type safetyEvents struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func recordSafety(t *testing.T) *safetyEvents {
+	s := &safetyEvents{}
+	SetSafetyHook(func(e Event) {
+		s.mu.Lock()
+		s.events = append(s.events, e)
+		s.mu.Unlock()
+	})
+	t.Cleanup(func() { SetSafetyHook(nil) })
+	return s
+}
+
+// of returns the events of the type
+func (s *safetyEvents) of(typ string) []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var l []Event
+	for _, e := range s.events {
+		if e.Type == typ {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+func setLimits(t *testing.T, pin string, l Limits) {
+	t.Helper()
+	if err := SetLimits(pin, l); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetLimits(pin, Limits{}) })
+}
+
+func setLevel(b Backend, pin, level string) error {
+	c, err := NewControl(SetType("set"), SetPin(pin), SetLevel(level), SetBackend(b))
+	if err != nil {
+		return err
+	}
+	_, err = c.Run()
+	return err
+}
+
+func TestMaxOn(t *testing.T) {
+	sim := NewSim()
+	events := recordSafety(t)
+	forgetPins(t, "4")
+	setLimits(t, "4", Limits{MaxOn: 50 * time.Millisecond})
+
+	j, err := newTimer(t, sim, "4", "1h").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, j.ID, JobCancelled)
+	if simLevel(sim, "4") != 0 {
+		t.Fatal("the pin is still on after its max on time")
+	}
+	if TimerPending("4") {
+		t.Fatal("the timer still holds the pin")
+	}
+	if l := events.of(EventForcedOff); len(l) != 1 || l[0].Pin != "4" || l[0].Reason == "" {
+		t.Fatalf("the forced off wasn't reported to the safety hook:%+v", l)
+	}
+
+	// a pin switched off and on again in the meantime isn't switched off by the old max on time
+	setLimits(t, "4", Limits{MaxOn: 100 * time.Millisecond})
+	if err := setLevel(sim, "4", "1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := setLevel(sim, "4", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setLevel(sim, "4", "1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if simLevel(sim, "4") != 1 {
+		t.Fatal("the pin was switched off by the max on time of the previous switch on")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if simLevel(sim, "4") != 0 || len(events.of(EventForcedOff)) != 2 {
+		t.Fatal("the pin wasn't switched off after its max on time")
+	}
+}
+
+func TestMinOff(t *testing.T) {
+	sim := NewSim()
+	events := recordSafety(t)
+	forgetPins(t, "11")
+	setLimits(t, "11", Limits{MinOff: 100 * time.Millisecond})
+
+	if err := setLevel(sim, "11", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setLevel(sim, "11", "0"); err != nil {
+		t.Fatal(err)
+	}
+	err := setLevel(sim, "11", "1")
+	if err == nil || ErrorCode(err) != CodeLimit {
+		t.Fatalf("the pin switched on before its min off time:%v", err)
+	}
+	if simLevel(sim, "11") != 0 {
+		t.Fatal("the refused switch on changed the pin")
+	}
+	if l := events.of(EventLimit); len(l) != 1 || l[0].Reason != err.Error() {
+		t.Fatalf("the refusal wasn't reported to the safety hook:%+v", l)
+	}
+	// switching off again is always allowed
+	if err := setLevel(sim, "11", "0"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(120 * time.Millisecond)
+	if err := setLevel(sim, "11", "1"); err != nil {
+		t.Fatalf("the pin didn't switch on after its min off time:%v", err)
+	}
+}
+
+func TestMaxPerHour(t *testing.T) {
+	sim := NewSim()
+	recordSafety(t)
+	forgetPins(t, "17")
+	setLimits(t, "17", Limits{MaxPerHour: 3})
+
+	for i := 0; i < 3; i++ {
+		if err := setLevel(sim, "17", "1"); err != nil {
+			t.Fatal(err)
+		}
+		// already on isn't another switch on
+		if err := setLevel(sim, "17", "1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := setLevel(sim, "17", "0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setLevel(sim, "17", "1"); err == nil || ErrorCode(err) != CodeLimit {
+		t.Fatalf("the pin switched on a 4th time within an hour:%v", err)
+	}
+
+	// the activations older than an hour don't count
+	guardMu.Lock()
+	for i := range activations["17"] {
+		activations["17"][i] = activations["17"][i].Add(-time.Hour)
+	}
+	guardMu.Unlock()
+	if err := setLevel(sim, "17", "1"); err != nil {
+		t.Fatalf("the pin didn't switch on after an hour:%v", err)
+	}
+}
+
+func TestMaxDelay(t *testing.T) {
+	sim := NewSim()
+	events := recordSafety(t)
+	forgetPins(t, "13")
+	setLimits(t, "13", Limits{MaxDelay: time.Minute})
+
+	if _, err := newTimer(t, sim, "13", "2m").Run(); err == nil || ErrorCode(err) != CodeLimit {
+		t.Fatalf("a timer longer than the max delay started:%v", err)
+	}
+	if simLevel(sim, "13") != 0 {
+		t.Fatal("the refused timer switched the pin on")
+	}
+	j, err := newTimer(t, sim, "13", "40s").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CancelJob(j.ID)
+	// extending the timer counts the time left
+	c, _ := NewControl(SetType("timer"), SetPin("13"), SetDelay("30s"), SetConflict(ConflictExtend), SetBackend(sim))
+	if _, err := c.Run(); err == nil || ErrorCode(err) != CodeLimit {
+		t.Fatalf("the timer was extended beyond the max delay:%v", err)
+	}
+	if len(events.of(EventLimit)) != 2 {
+		t.Fatalf("%v limit events, expected 2", len(events.of(EventLimit)))
+	}
+}
+
+// TestLimitBackground checks that a queued timer refused by a limit when it starts reaches the safety hook
+func TestLimitBackground(t *testing.T) {
+	sim := NewSim()
+	events := recordSafety(t)
+	forgetPins(t, "12")
+	setLimits(t, "12", Limits{MinOff: time.Hour})
+
+	first, err := newTimer(t, sim, "12", "20ms").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := NewControl(SetType("timer"), SetPin("12"), SetDelay("20ms"), SetConflict(ConflictQueue), SetBackend(sim))
+	queued, err := c.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, first.ID, JobDone)
+	waitJob(t, queued.ID, JobFailed)
+	if l := events.of(EventLimit); len(l) != 1 || l[0].Pin != "12" {
+		t.Fatalf("the refused queued timer wasn't reported to the safety hook:%+v", l)
+	}
+}
@@ -0,0 +1,35 @@
+package rpiGpio
+
+import "sync"
+
+// Every change of an output pin holds the lock of the pin, so for the same pin:
+//
+//   - exporting, setting the direction and writing happen once and never interleave,
+//     two requests can't both see the pin missing and both export it
+//   - toggle reads and writes the level as one step, a timer can't switch the pin off in between
+//   - the output events are published in the order the levels were written
+//   - a timer that ends and a timer that restarts it don't race, the pin stays on for the new timer
+//
+// Which of two concurrent requests runs first isn't defined, only that one finishes before the other starts.
+// Different pins don't block each other. The lock is per line - the controls resolve a pin like phys:12
+// or a gpio line name to the gpio number or the line offset first - so every name of a line takes the same lock.
+//
+// Lock ordering: a pin lock is taken before jobsMu, statesMu and the broadcaster lock, never while holding any of them.
+var (
+	pinLocksMu sync.Mutex
+	pinLocks   = make(map[string]*sync.Mutex)
+)
+
+// lockPin locks the pin and returns the function that unlocks it
+func lockPin(pin string) func() {
+	pinLocksMu.Lock()
+	l, ok := pinLocks[pin]
+	if !ok {
+		l = &sync.Mutex{}
+		pinLocks[pin] = l
+	}
+	pinLocksMu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
@@ -0,0 +1,212 @@
+package rpiGpio
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serialSim counts the backend calls for the same pin that overlap, the pin lock must prevent them
+type serialSim struct {
+	*namedSim
+	mu       sync.Mutex
+	busy     map[string]bool
+	overlaps int
+}
+
+func newSerialSim(names map[string]string) *serialSim {
+	return &serialSim{namedSim: &namedSim{countingSim: newCountingSim(), names: names}, busy: make(map[string]bool)}
+}
+
+// line is the offset of a line name, the backend accepts both like the gpio character device
+func (s *serialSim) line(pin string) string {
+	if o, ok := s.names[pin]; ok {
+		return o
+	}
+	return pin
+}
+
+func (s *serialSim) enter(pin string) func() {
+	s.mu.Lock()
+	if s.busy[pin] {
+		s.overlaps++
+	}
+	s.busy[pin] = true
+	s.mu.Unlock()
+	// give another goroutine the chance to overlap
+	time.Sleep(20 * time.Microsecond)
+	return func() {
+		s.mu.Lock()
+		s.busy[pin] = false
+		s.mu.Unlock()
+	}
+}
+
+func (s *serialSim) Exported(pin string) bool {
+	return s.Sim.Exported(s.line(pin))
+}
+
+func (s *serialSim) Export(pin string) error {
+	pin = s.line(pin)
+	defer s.enter(pin)()
+	return s.Sim.Export(pin)
+}
+
+func (s *serialSim) SetDirection(pin string, d Direction) error {
+	pin = s.line(pin)
+	defer s.enter(pin)()
+	return s.Sim.SetDirection(pin, d)
+}
+
+func (s *serialSim) Read(pin string) (int, error) {
+	pin = s.line(pin)
+	defer s.enter(pin)()
+	return s.Sim.Read(pin)
+}
+
+func (s *serialSim) Write(pin string, v int) error {
+	pin = s.line(pin)
+	defer s.enter(pin)()
+	return s.Sim.Write(pin, v)
+}
+
+func (s *serialSim) SetOutput(pin string, v int) error {
+	pin = s.line(pin)
+	defer s.enter(pin)()
+	return s.Sim.SetOutput(pin, v)
+}
+
+func (s *serialSim) SetActiveLow(pin string, on bool) error {
+	pin = s.line(pin)
+	defer s.enter(pin)()
+	return s.countingSim.SetActiveLow(pin, on)
+}
+
+func (s *serialSim) overlapped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.overlaps
+}
+
+// waitJobs waits for all the jobs of the pin to end
+func waitJobs(t *testing.T, pin string) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(time.Millisecond) {
+		running := false
+		for _, j := range Jobs() {
+			if j.Pin == pin && (j.State == JobActive || j.State == JobPending) {
+				running = true
+			}
+		}
+		if !running {
+			return
+		}
+	}
+	t.Fatalf("the jobs of pin %v didn't end", pin)
+}
+
+// TestLockToggles toggles a pin by its number and by its line name at the same time,
+// each toggle reads and writes the pin as one step so an even number of toggles leaves it off
+func TestLockToggles(t *testing.T) {
+	b := newSerialSim(map[string]string{"TOGGLE_LINE": "16"})
+	forgetPins(t, "16")
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		pin := "16"
+		if i%2 == 0 {
+			pin = "TOGGLE_LINE"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := NewControl(SetType("toggle"), SetPin(pin), SetBackend(b))
+			if err == nil {
+				_, err = c.Run()
+			}
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if n := b.overlapped(); n > 0 {
+		t.Fatalf("%v backend calls of the pin overlapped", n)
+	}
+	if simLevel(b.Sim, "16") != 0 {
+		t.Fatal("100 toggles left the pin on")
+	}
+}
+
+// TestLockTimers starts timers with every conflict policy, toggles and reads of the same pin concurrently
+func TestLockTimers(t *testing.T) {
+	b := newSerialSim(map[string]string{"TIMER_LINE": "19"})
+	forgetPins(t, "19")
+	conflicts := []string{ConflictRestart, ConflictExtend, ConflictQueue, ConflictReject}
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		pin := "19"
+		if i%2 == 0 {
+			pin = "TIMER_LINE"
+		}
+		conflict := conflicts[i%len(conflicts)]
+		delay := fmt.Sprintf("%vms", 1+i%5)
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			c, err := NewControl(SetType("timer"), SetPin(pin), SetDelay(delay), SetConflict(conflict), SetBackend(b))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := c.Run(); err != nil && err.(*Error).Code != CodePinBusy {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			c, err := NewControl(SetType("toggle"), SetPin(pin), SetBackend(b))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := c.Run(); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			c, err := NewControl(SetPin(pin), SetBackend(b))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, _, err := c.Level(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	waitJobs(t, "19")
+	if n := b.overlapped(); n > 0 {
+		t.Fatalf("%v backend calls of the pin overlapped", n)
+	}
+	if TimerPending("19") {
+		t.Fatal("a timer still holds the pin")
+	}
+
+	// the last timer switches the pin off once no toggle runs anymore
+	c, _ := NewControl(SetType("timer"), SetPin("TIMER_LINE"), SetDelay("1ms"), SetBackend(b))
+	if _, err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	waitJobs(t, "19")
+	if simLevel(b.Sim, "19") != 0 {
+		t.Fatal("the pin is on after the last timer ended")
+	}
+}
@@ -0,0 +1,81 @@
+package rpiGpio
+
+import "sync"
+
+// The active-low pins are set once from the device config so every control of a pin uses the same polarity,
+// a raw pin control, a read of the level or the other pin of an interlock can't switch it.
+var (
+	polarityMu sync.Mutex
+	activeLow  = make(map[string]bool)
+	// applied is the active-low set for the pin in the backends that invert the levels themselves,
+	// it is written again only when it changes or the pin is exported again
+	applied = make(map[appliedPin]bool)
+)
+
+type appliedPin struct {
+	backend Backend
+	pin     string
+}
+
+// SetPinActiveLow sets if the pin is on when it is low like most relay boards, the pins are active-high by default
+func SetPinActiveLow(pin string, on bool) error {
+	pin, err := ResolvePin(pin)
+	if err != nil {
+		return err
+	}
+	polarityMu.Lock()
+	defer polarityMu.Unlock()
+	activeLow[pin] = on
+	return nil
+}
+
+// polarity reports if the pin is active-low and if its polarity was set at all
+func polarity(pin string) (low bool, known bool) {
+	polarityMu.Lock()
+	defer polarityMu.Unlock()
+	low, known = activeLow[pin]
+	return low, known
+}
+
+func isActiveLow(pin string) bool {
+	low, _ := polarity(pin)
+	return low
+}
+
+// applyActiveLow sets the active-low of the pin in the backends that invert the levels themselves
+// when it isn't set already, the caller holds the pin lock
+func (c *Control) applyActiveLow() error {
+	i, ok := c.backend.(Inverter)
+	if !ok {
+		return nil
+	}
+	low := isActiveLow(c.pin)
+	k := appliedPin{c.backend, c.pin}
+	polarityMu.Lock()
+	was, done := applied[k]
+	polarityMu.Unlock()
+	if done && was == low {
+		return nil
+	}
+	if err := i.SetActiveLow(c.pin, low); err != nil {
+		return err
+	}
+	polarityMu.Lock()
+	applied[k] = low
+	polarityMu.Unlock()
+	return nil
+}
+
+// forgetActiveLow is called when the pin is exported or unexported as the kernel resets its active-low
+func (c *Control) forgetActiveLow() {
+	polarityMu.Lock()
+	delete(applied, appliedPin{c.backend, c.pin})
+	polarityMu.Unlock()
+}
+
+// invert reports if the levels are inverted here rather than by the backend.
+// A backend that inverts the levels itself returns them with the active-low set in the kernel,
+// which is the one from the config once the pin was written by this or a previous run.
+func (c *Control) invert() bool {
+	return isActiveLow(c.pin) && !inverts(c.backend)
+}
@@ -0,0 +1,102 @@
+package rpiGpio
+
+import (
+	"sync"
+	"testing"
+)
+
+// countingSim counts how often the active-low of each pin is set
+type countingSim struct {
+	*Sim
+	mu  sync.Mutex
+	set map[string]int
+}
+
+func newCountingSim() *countingSim {
+	return &countingSim{Sim: NewSim(), set: make(map[string]int)}
+}
+
+func (c *countingSim) SetActiveLow(pin string, on bool) error {
+	c.mu.Lock()
+	c.set[pin]++
+	c.mu.Unlock()
+	return c.Sim.SetActiveLow(pin, on)
+}
+
+func (c *countingSim) setCount(pin string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.set[pin]
+}
+
+// plainBackend hides the Inverter and the Outputter of the simulation so the levels are inverted by the control
+type plainBackend struct {
+	s *Sim
+}
+
+func (p plainBackend) Exported(pin string) bool                   { return p.s.Exported(pin) }
+func (p plainBackend) Export(pin string) error                    { return p.s.Export(pin) }
+func (p plainBackend) Unexport(pin string) error                  { return p.s.Unexport(pin) }
+func (p plainBackend) SetDirection(pin string, d Direction) error { return p.s.SetDirection(pin, d) }
+func (p plainBackend) Read(pin string) (int, error)               { return p.s.Read(pin) }
+func (p plainBackend) Write(pin string, v int) error              { return p.s.Write(pin, v) }
+
+func TestActiveLow(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		pin     string
+		backend func(*Sim) Backend
+	}{
+		{"kernel", "20", func(s *Sim) Backend { return &countingSim{Sim: s, set: make(map[string]int)} }},
+		{"control", "21", func(s *Sim) Backend { return plainBackend{s} }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			forgetPins(t, tt.pin)
+			sim := NewSim()
+			b := tt.backend(sim)
+			if err := SetPinActiveLow(tt.pin, true); err != nil {
+				t.Fatal(err)
+			}
+			control := func(opts ...func(*Control) error) *Control {
+				c, err := NewControl(append([]func(*Control) error{SetPin(tt.pin), SetBackend(b)}, opts...)...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return c
+			}
+			level := func() int {
+				v, exported, err := control().Level()
+				if err != nil || !exported {
+					t.Fatalf("reading the level:%v exported:%v", err, exported)
+				}
+				return v
+			}
+
+			// a raw control of the pin, which knows nothing about the device, uses the polarity of the pin
+			if _, err := control(SetType("set"), SetLevel("0")).Run(); err != nil {
+				t.Fatal(err)
+			}
+			if simLevel(sim, tt.pin) != 1 {
+				t.Fatal("an active-low pin that is off isn't high")
+			}
+			if level() != 0 {
+				t.Fatal("an active-low pin that is off reads on")
+			}
+			if _, err := control(SetType("toggle")).Run(); err != nil {
+				t.Fatal(err)
+			}
+			if simLevel(sim, tt.pin) != 0 || level() != 1 {
+				t.Fatal("an active-low pin that is on isn't low")
+			}
+			for i := 0; i < 3; i++ {
+				level()
+			}
+			if c, ok := b.(*countingSim); ok && c.setCount(tt.pin) != 1 {
+				t.Fatalf("the active-low was set %v times, expected once at the export", c.setCount(tt.pin))
+			}
+			if simLevel(sim, tt.pin) != 0 {
+				t.Fatal("reading the level changed the pin")
+			}
+		})
+	}
+}
@@ -0,0 +1,528 @@
+package rpiGpio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPWMRoot is the first chip of the kernel sysfs PWM interface
+const DefaultPWMRoot = "/sys/class/pwm/pwmchip0"
+
+// PWM frequencies in Hz, the software PWM toggles the pin from a goroutine so it can't go very fast
+const (
+	DefaultPWMFrequency     = 1000
+	DefaultSoftPWMFrequency = 100
+	MaxSoftPWMFrequency     = 500
+	MaxPWMFrequency         = 1000000
+)
+
+// rampStep is how often a ramp changes the duty cycle
+const rampStep = 20 * time.Millisecond
+
+var (
+	// PWMRoot is the sysfs PWM chip of the hardware PWM pins, empty uses the software PWM for all pins
+	PWMRoot = DefaultPWMRoot
+	// PWMChannels are the pins of the hardware PWM channels. The pwm-2chan overlay of the Raspberry Pi routes
+	// channel 0 to GPIO 18 and channel 1 to GPIO 19, or to GPIO 12 and 13 with pin=12 and pin2=13,
+	// SetPWMPins sets them to match the overlay.
+	PWMChannels = map[string]int{"18": 0, "19": 1}
+	// pwmPins are the pins each hardware PWM channel can be routed to
+	pwmPins = [][]string{{"12", "18"}, {"13", "19"}}
+
+	pwmMu sync.Mutex
+	pwms  = make(map[string]*pwm)
+	// ramps are the running ramp jobs of each pin, guarded by jobsMu
+	ramps = make(map[string]*job)
+)
+
+// PWMState is the duty cycle of a pin driven with PWM
+type PWMState struct {
+	// Duty is the percentage of the period the output is on
+	Duty      float64 `json:"duty"`
+	Frequency float64 `json:"frequency"`
+	// Hardware is false for the software PWM
+	Hardware bool `json:"hardware"`
+}
+
+// pwm drives a pin, a hardware channel is -1 for the software PWM
+type pwm struct {
+	PWMState
+	pin     string
+	channel int
+	period  int64
+	backend Backend
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// SetDuty is the duty cycle in percent of the pwm and ramp control types
+func SetDuty(d string) func(*Control) error {
+	return func(c *Control) error {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(d), "%"), 64)
+		if err != nil || v < 0 || v > 100 {
+			return newError(CodeInvalidPWM, "Invalid duty cycle:%v, use 0 to 100 percent", d)
+		}
+		c.duty = v
+		return nil
+	}
+}
+
+// SetFrequency is the PWM frequency in Hz, empty keeps the current frequency of the pin
+// or uses the default of the hardware or the software PWM
+func SetFrequency(d string) func(*Control) error {
+	return func(c *Control) error {
+		if strings.TrimSpace(d) == "" {
+			c.frequency = 0
+			return nil
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(d), 64)
+		if err != nil || v <= 0 || v > MaxPWMFrequency {
+			return newError(CodeInvalidPWM, "Invalid PWM frequency:%v, use up to %v Hz", d, MaxPWMFrequency)
+		}
+		c.frequency = v
+		return nil
+	}
+}
+
+// SetPWMPins sets the pins the hardware PWM channels are routed to by the overlay, the first pin is for channel 0,
+// 18 or 12, and the second for channel 1, 19 or 13. An empty pin leaves the channel unused.
+func SetPWMPins(pins ...string) error {
+	if len(pins) > len(pwmPins) {
+		return fmt.Errorf("There are only %v hardware PWM channels", len(pwmPins))
+	}
+	channels := make(map[string]int)
+	for ch, pin := range pins {
+		if strings.TrimSpace(pin) == "" {
+			continue
+		}
+		p, err := pinNumber(strings.TrimSpace(pin))
+		if err != nil {
+			return err
+		}
+		if !contains(pwmPins[ch], p) {
+			return fmt.Errorf("PWM channel %v can't be on pin %v, use %v", ch, pin, strings.Join(pwmPins[ch], " or "))
+		}
+		channels[p] = ch
+	}
+	pwmMu.Lock()
+	defer pwmMu.Unlock()
+	PWMChannels = channels
+	return nil
+}
+
+// checkPWMPin fails for the pin that shares a hardware PWM channel with the pin the overlay routes it to,
+// the channel may reach both pins depending on the overlay so neither PWM can be driven right there
+func checkPWMPin(pin string) error {
+	if PWMRoot == "" {
+		return nil
+	}
+	if _, err := os.Stat(PWMRoot); err != nil {
+		return nil
+	}
+	pwmMu.Lock()
+	defer pwmMu.Unlock()
+	if _, ok := PWMChannels[pin]; ok {
+		return nil
+	}
+	for ch, l := range pwmPins {
+		if !contains(l, pin) {
+			continue
+		}
+		for p, c := range PWMChannels {
+			if c == ch {
+				return newError(CodeInvalidPWM, "Pin %v shares the hardware PWM channel %v with pin %v, use pin %v or set the PWM pins to match the overlay", pin, ch, p, p)
+			}
+		}
+	}
+	return nil
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// PWM returns the PWM state of the pin, false when the pin isn't driven with PWM
+func PWM(pin string) (PWMState, bool) {
+	pwmMu.Lock()
+	defer pwmMu.Unlock()
+	p, ok := pwms[pin]
+	if !ok {
+		return PWMState{}, false
+	}
+	return p.PWMState, true
+}
+
+// pwm sets the duty cycle of the pin and stops a running ramp
+func (c *Control) pwm() error {
+	defer lockPin(c.pin)()
+	jobsMu.Lock()
+	if j, ok := ramps[c.pin]; ok {
+		j.cancel()
+	}
+	jobsMu.Unlock()
+	if err := c.setPWM(c.duty); err != nil {
+		return err
+	}
+	notifyPWM(c.pin, c.duty)
+	return nil
+}
+
+func notifyPWM(pin string, duty float64) {
+	notify(Event{Type: EventPWM, Pin: pin, Time: time.Now(), Duty: &duty})
+}
+
+// setPWM starts the PWM of the pin or changes its duty cycle, the caller holds the pin lock
+func (c *Control) setPWM(duty float64) error {
+	pwmMu.Lock()
+	p, ok := pwms[c.pin]
+	pwmMu.Unlock()
+	if !ok {
+		if err := checkPWMPin(c.pin); err != nil {
+			return err
+		}
+		p = newPWM(c)
+		if p.channel < 0 {
+			if err := c.enablePin(); err != nil {
+				log.Printf("I couldn't enable pin %v, because %v", c.pin, err)
+				return err
+			}
+		} else if c.backend.Exported(c.pin) {
+			log.Printf("Pin %v was used as a gpio output, the hardware PWM might not reach it until a reboot", c.pin)
+		}
+	}
+
+	freq := c.frequency
+	switch {
+	case freq == 0 && ok:
+		freq = p.Frequency
+	case freq == 0 && p.channel >= 0:
+		freq = DefaultPWMFrequency
+	case freq == 0:
+		freq = DefaultSoftPWMFrequency
+	}
+	if p.channel < 0 && freq > MaxSoftPWMFrequency {
+		if !ok {
+			p.close()
+		}
+		return newError(CodeInvalidPWM, "Pin %v has no hardware PWM, the software PWM goes up to %v Hz", c.pin, MaxSoftPWMFrequency)
+	}
+	if err := c.guard(duty > 0, func() error { return p.set(freq, duty) }); err != nil {
+		if !ok {
+			p.close()
+		}
+		return err
+	}
+	if !ok {
+		pwmMu.Lock()
+		pwms[c.pin] = p
+		pwmMu.Unlock()
+	}
+	return nil
+}
+
+// newPWM uses the hardware channel of the pin when it has one, otherwise the software PWM
+func newPWM(c *Control) *pwm {
+	p := &pwm{pin: c.pin, channel: -1, backend: c.backend}
+	pwmMu.Lock()
+	ch, ok := PWMChannels[c.pin]
+	pwmMu.Unlock()
+	if !ok || PWMRoot == "" {
+		return p
+	}
+	if _, err := os.Stat(PWMRoot); err != nil {
+		return p
+	}
+	if n, err := ioutil.ReadFile(filepath.Join(PWMRoot, "npwm")); err == nil {
+		if npwm, err := strconv.Atoi(strings.TrimSpace(string(n))); err == nil && ch >= npwm {
+			return p
+		}
+	}
+	p.channel = ch
+	p.Hardware = true
+	return p
+}
+
+// set changes the frequency and the duty cycle, the caller holds the pin lock
+func (p *pwm) set(freq, duty float64) error {
+	if p.channel >= 0 {
+		return p.setHardware(freq, duty)
+	}
+	pwmMu.Lock()
+	p.Frequency, p.Duty = freq, duty
+	running := p.stop != nil
+	if !running {
+		p.stop, p.done = make(chan struct{}), make(chan struct{})
+	}
+	pwmMu.Unlock()
+	if !running {
+		go p.soft()
+	}
+	return nil
+}
+
+func (p *pwm) hwPath(file string) string {
+	return filepath.Join(PWMRoot, fmt.Sprintf("pwm%v", p.channel), file)
+}
+
+func (p *pwm) setHardware(freq, duty float64) error {
+	if _, err := os.Stat(p.hwPath("")); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(filepath.Join(PWMRoot, "export"), []byte(strconv.Itoa(p.channel)), 0644); err != nil {
+			return err
+		}
+	}
+	on := duty
+	if isActiveLow(p.pin) {
+		on = 100 - duty
+	}
+	period := int64(1e9 / freq)
+	dutyNs := int64(float64(period) * on / 100)
+	if period != p.period {
+		// the duty cycle can't be longer than the period so it is cleared before changing the period
+		if p.period != 0 {
+			if err := ioutil.WriteFile(p.hwPath("duty_cycle"), []byte("0"), 0644); err != nil {
+				return err
+			}
+		}
+		if err := ioutil.WriteFile(p.hwPath("period"), []byte(strconv.FormatInt(period, 10)), 0644); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(p.hwPath("duty_cycle"), []byte(strconv.FormatInt(dutyNs, 10)), 0644); err != nil {
+		return err
+	}
+	if p.period == 0 {
+		if err := ioutil.WriteFile(p.hwPath("enable"), []byte("1"), 0644); err != nil {
+			return err
+		}
+	}
+	pwmMu.Lock()
+	p.period = period
+	p.Frequency, p.Duty = freq, duty
+	pwmMu.Unlock()
+	return nil
+}
+
+// soft switches the pin on and off for the duty cycle until stopped
+func (p *pwm) soft() {
+	defer close(p.done)
+	level := -1
+	invert := isActiveLow(p.pin) && !inverts(p.backend)
+	write := func(v int) {
+		if v == level {
+			return
+		}
+		level = v
+		if invert {
+			v ^= 1
+		}
+		if err := p.backend.Write(p.pin, v); err != nil {
+			log.Printf("Software PWM couldn't write pin %v:%v", p.pin, err)
+		}
+	}
+	t := time.NewTimer(time.Hour)
+	t.Stop()
+	defer t.Stop()
+	wait := func(d time.Duration) bool {
+		t.Reset(d)
+		select {
+		case <-p.stop:
+			return false
+		case <-t.C:
+			return true
+		}
+	}
+	for {
+		pwmMu.Lock()
+		period := time.Duration(float64(time.Second) / p.Frequency)
+		on := time.Duration(float64(period) * p.Duty / 100)
+		pwmMu.Unlock()
+
+		if on > 0 {
+			write(1)
+			if !wait(on) {
+				return
+			}
+		}
+		if on < period {
+			write(0)
+			if !wait(period - on) {
+				return
+			}
+		}
+	}
+}
+
+// close stops the PWM and leaves the pin as it is, the caller holds the pin lock
+func (p *pwm) close() {
+	if p.channel >= 0 {
+		if p.period != 0 {
+			if err := ioutil.WriteFile(p.hwPath("enable"), []byte("0"), 0644); err != nil {
+				log.Printf("Couldn't disable PWM channel %v:%v", p.channel, err)
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(PWMRoot, "unexport"), []byte(strconv.Itoa(p.channel)), 0644); err != nil {
+			log.Printf("Couldn't unexport PWM channel %v:%v", p.channel, err)
+		}
+		return
+	}
+	pwmMu.Lock()
+	stop, done := p.stop, p.done
+	pwmMu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// stopPWM stops the PWM and the ramp of the pin before it is written, the caller holds the pin lock
+func stopPWM(pin string) {
+	jobsMu.Lock()
+	if j, ok := ramps[pin]; ok {
+		j.cancel()
+	}
+	jobsMu.Unlock()
+
+	pwmMu.Lock()
+	p, ok := pwms[pin]
+	delete(pwms, pin)
+	pwmMu.Unlock()
+	if ok {
+		p.close()
+	}
+}
+
+// StopPWM stops all ramps and PWM outputs and switches them off, used before exiting
+func StopPWM() {
+	jobsMu.Lock()
+	var running []*job
+	for _, j := range ramps {
+		j.cancel()
+		running = append(running, j)
+	}
+	jobsMu.Unlock()
+	for _, j := range running {
+		<-j.done
+	}
+
+	pwmMu.Lock()
+	var l []*pwm
+	for _, p := range pwms {
+		l = append(l, p)
+	}
+	pwmMu.Unlock()
+
+	for _, p := range l {
+		c, err := NewControl(SetPin(p.pin), SetBackend(p.backend))
+		if err != nil {
+			continue
+		}
+		log.Printf("Switching off the PWM of pin %v", p.pin)
+		c.ctype, c.level = "set", 0
+		if err := c.set(); err != nil {
+			log.Printf("Couldn't disable pin:%v error:%v", p.pin, err)
+		}
+	}
+}
+
+// startRamp changes the duty cycle from the current one to the target over the delay, a running ramp of the pin is replaced
+func (c *Control) startRamp() (Job, error) {
+	unlock := lockPin(c.pin)
+	from := 0.0
+	if s, ok := PWM(c.pin); ok {
+		from = s.Duty
+	} else if c.backend.Exported(c.pin) {
+		if v, err := c.read(); err == nil {
+			from = float64(v * 100)
+		}
+	}
+
+	jobsMu.Lock()
+	if cur, ok := ramps[c.pin]; ok {
+		// its goroutine notices it at the next step under the pin lock
+		cur.cancel()
+	}
+	j := newJob(c, JobActive)
+	now := time.Now()
+	deadline := now.Add(c.delay)
+	j.Started, j.Deadline = &now, &deadline
+	ramps[c.pin] = j
+	jobsMu.Unlock()
+
+	err := c.setPWM(from)
+	unlock()
+	if err != nil {
+		j.endRamp(JobFailed, err)
+		return j.snapshot(), err
+	}
+	notifyPWM(c.pin, from)
+	go j.ramp(from)
+	return j.snapshot(), nil
+}
+
+// ramp steps the duty cycle until the deadline or until the job is cancelled
+func (j *job) ramp(from float64) {
+	c := j.c
+	t := time.NewTicker(rampStep)
+	defer t.Stop()
+	for {
+		select {
+		case <-j.ctx.Done():
+			j.endRamp(JobCancelled, nil)
+			return
+		case now := <-t.C:
+			f := 1.0
+			if c.delay > 0 {
+				f = float64(now.Sub(*j.Started)) / float64(c.delay)
+			}
+			if f > 1 {
+				f = 1
+			}
+			duty := math.Round((from+(c.duty-from)*f)*100) / 100
+
+			unlock := lockPin(c.pin)
+			if j.ctx.Err() != nil {
+				unlock()
+				j.endRamp(JobCancelled, nil)
+				return
+			}
+			err := c.setPWM(duty)
+			unlock()
+			if err != nil {
+				j.endRamp(JobFailed, err)
+				return
+			}
+			if f == 1 {
+				notifyPWM(c.pin, duty)
+				j.endRamp(JobDone, nil)
+				return
+			}
+		}
+	}
+}
+
+func (j *job) endRamp(state string, err error) {
+	if state == JobCancelled {
+		if s, ok := PWM(j.Pin); ok {
+			notifyPWM(j.Pin, s.Duty)
+		}
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if ramps[j.Pin] == j {
+		delete(ramps, j.Pin)
+	}
+	endJob(j, state, err)
+}
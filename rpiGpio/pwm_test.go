@@ -0,0 +1,167 @@
+package rpiGpio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeChip is a sysfs pwm chip with two channels, the channel directories exist already
+// since there is no kernel to create them on export
+func fakeChip(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, f := range []string{"export", "unexport"} {
+		if err := ioutil.WriteFile(filepath.Join(root, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "npwm"), []byte("2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range []string{"pwm0", "pwm1"} {
+		if err := os.Mkdir(filepath.Join(root, ch), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []string{"period", "duty_cycle", "enable"} {
+			if err := ioutil.WriteFile(filepath.Join(root, ch, f), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	old := PWMRoot
+	PWMRoot = root
+	t.Cleanup(func() {
+		PWMRoot = old
+		SetPWMPins("18", "19")
+	})
+	return root
+}
+
+func chipFile(t *testing.T, root string, file ...string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join(append([]string{root}, file...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// stopPWMs stops the PWM of the pins when the test ends
+func stopPWMs(t *testing.T, pins ...string) {
+	t.Cleanup(func() {
+		for _, p := range pins {
+			stopPWM(p)
+		}
+	})
+}
+
+func runPWM(b Backend, pin, duty string) error {
+	c, err := NewControl(SetType("pwm"), SetPin(pin), SetDuty(duty), SetBackend(b))
+	if err != nil {
+		return err
+	}
+	_, err = c.Run()
+	return err
+}
+
+func TestPWMChannels(t *testing.T) {
+	root := fakeChip(t)
+	sim := NewSim()
+	forgetPins(t, "12", "13", "18", "19")
+	stopPWMs(t, "12", "13", "18", "19")
+
+	if err := runPWM(sim, "18", "25"); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := PWM("18"); !ok || !s.Hardware {
+		t.Fatalf("pin 18 doesn't use the hardware PWM:%+v", s)
+	}
+	if chipFile(t, root, "pwm0", "period") != "1000000" || chipFile(t, root, "pwm0", "duty_cycle") != "250000" || chipFile(t, root, "pwm0", "enable") != "1" {
+		t.Fatal("pin 18 didn't set up channel 0")
+	}
+
+	// the other pin of the channel can't drive a PWM
+	err := runPWM(sim, "12", "50")
+	if err == nil || err.(*Error).Code != CodeInvalidPWM {
+		t.Fatalf("PWM on pin 12 while channel 0 is on pin 18 didn't fail:%v", err)
+	}
+	if _, ok := PWM("12"); ok {
+		t.Fatal("pin 12 has a PWM")
+	}
+	if chipFile(t, root, "pwm0", "duty_cycle") != "250000" {
+		t.Fatal("pin 12 changed the PWM of pin 18")
+	}
+
+	if err := SetPinActiveLow("19", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := runPWM(sim, "19", "25"); err != nil {
+		t.Fatal(err)
+	}
+	if chipFile(t, root, "pwm1", "duty_cycle") != "750000" {
+		t.Fatal("the duty cycle of the active-low pin 19 isn't inverted on channel 1")
+	}
+
+	c, _ := NewControl(SetType("set"), SetPin("18"), SetLevel("0"), SetBackend(sim))
+	if _, err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := PWM("18"); ok {
+		t.Fatal("setting the level didn't stop the PWM")
+	}
+	if chipFile(t, root, "pwm0", "enable") != "0" || chipFile(t, root, "unexport") != "0" {
+		t.Fatal("setting the level didn't release channel 0")
+	}
+	stopPWM("19")
+
+	// an overlay with pin=12 and pin2=13
+	if err := SetPWMPins("12", "13"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runPWM(sim, "12", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := PWM("12"); !s.Hardware || chipFile(t, root, "pwm0", "duty_cycle") != "100000" {
+		t.Fatal("pin 12 didn't use channel 0")
+	}
+	if err := runPWM(sim, "18", "10"); err == nil {
+		t.Fatal("PWM on pin 18 while channel 0 is on pin 12 didn't fail")
+	}
+	if err := runPWM(sim, "19", "10"); err == nil {
+		t.Fatal("PWM on pin 19 while channel 1 is on pin 13 didn't fail")
+	}
+}
+
+func TestSetPWMPins(t *testing.T) {
+	t.Cleanup(func() { SetPWMPins("18", "19") })
+	for _, pins := range [][]string{{"13"}, {"18", "12"}, {"18", "19", "13"}, {"5"}, {"x"}} {
+		if err := SetPWMPins(pins...); err == nil {
+			t.Fatalf("%v didn't fail", pins)
+		}
+	}
+	if err := SetPWMPins("", "13"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := PWMChannels["18"]; ok || PWMChannels["13"] != 1 || len(PWMChannels) != 1 {
+		t.Fatalf("unexpected channels:%v", PWMChannels)
+	}
+}
+
+// TestSoftPWM checks that without the pwm chip the pins of the channels get the software PWM
+func TestSoftPWM(t *testing.T) {
+	old := PWMRoot
+	PWMRoot = filepath.Join(t.TempDir(), "missing")
+	defer func() { PWMRoot = old }()
+	forgetPins(t, "12")
+	stopPWMs(t, "12")
+
+	if err := runPWM(NewSim(), "12", "50"); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := PWM("12"); !ok || s.Hardware {
+		t.Fatalf("pin 12 doesn't use the software PWM:%+v", s)
+	}
+}
@@ -0,0 +1,317 @@
+package rpiGpio
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var lineName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+
+const DefaultDelay = 2 * time.Second
+const DefaultPin = "18"
+const DefaultType = "timer"
+
+// Controller interface describes the main funcitons when triggering a pin
+type Controller interface {
+	StartTimer(ch chan string) error
+	Toggle(ch chan string) error
+}
+
+//NewControl the constructor with some defaults
+func NewControl(opts ...func(*Control) error) (*Control, error) {
+	ctrl := &Control{backend: DefaultBackend, conflict: DefaultConflict, ctx: context.Background()}
+	for _, o := range opts {
+		if err := o(ctrl); err != nil {
+			return nil, err
+		}
+	}
+	// a line name is resolved once the backend is known
+	if lineName.MatchString(ctrl.pin) {
+		pin, err := resolveLine(ctrl.backend, ctrl.pin)
+		if err != nil {
+			return nil, err
+		}
+		ctrl.pin = pin
+	}
+
+	return ctrl, nil
+}
+
+// Control holds all configuration
+type Control struct {
+	ctype string
+	pin   string
+	delay time.Duration
+	level int
+	// initial is the level written when the pin is exported
+	initial int
+	backend Backend
+	// conflict is what happens when a timer is started for a pin that already has one
+	conflict string
+	// ctx cancels the job of the control
+	ctx context.Context
+	// duty in percent and frequency in Hz of the pwm and ramp types, the ramp takes the delay
+	duty      float64
+	frequency float64
+	// steps of the sequence type
+	steps []step
+}
+
+// SetType is the controller ctype setter
+func SetType(d string) func(*Control) error {
+	return func(c *Control) error {
+		switch strings.TrimSpace(d) {
+		case "":
+			c.ctype = DefaultType
+		case "timer", "toggle", "set", "pwm", "ramp", "sequence":
+			c.ctype = strings.TrimSpace(d)
+		default:
+			return newError(CodeInvalidType, "Invalid control type:%v", d)
+		}
+		return nil
+	}
+}
+
+//SetPin the pin on gpio that willbe controlled
+func SetPin(d string) func(*Control) error {
+	return func(c *Control) error {
+		if d == "" {
+			c.pin = DefaultPin
+			return nil
+		}
+		pin, err := pinNumber(d)
+		if err != nil {
+			return err
+		}
+		c.pin = pin
+		return nil
+	}
+}
+
+// SetBackend sets the backend used to access the pins, the default is DefaultBackend
+func SetBackend(b Backend) func(*Control) error {
+	return func(c *Control) error {
+		if b == nil {
+			return errors.New("Backend can't be nil")
+		}
+		c.backend = b
+		return nil
+	}
+}
+
+// SetDelay delay between enable and disable timer
+func SetDelay(d string) func(*Control) error {
+	return func(c *Control) error {
+		if d == "" {
+			c.delay = DefaultDelay
+			return nil
+		}
+		if t, err := time.ParseDuration(d); err == nil {
+			c.delay = t
+			return nil
+		}
+		return newError(CodeInvalidDelay, "Invalid time delay format :%v (use 1ms, 1s, 1m, 1h)", d)
+	}
+}
+
+// SetInitial sets the level written when the pin is exported - on, off, 1 or 0, the default is off
+func SetInitial(d string) func(*Control) error {
+	return func(c *Control) error {
+		switch strings.TrimSpace(d) {
+		case "", "off", "0":
+			c.initial = 0
+		case "on", "1":
+			c.initial = 1
+		default:
+			return newError(CodeInvalidLevel, "Invalid initial level:%v, use on, off, 1 or 0", d)
+		}
+		return nil
+	}
+}
+
+// SetConflict sets what happens when a timer is started for a pin with an active timer -
+// restart(the default), extend, reject or queue
+func SetConflict(d string) func(*Control) error {
+	return func(c *Control) error {
+		switch strings.TrimSpace(d) {
+		case "":
+			c.conflict = DefaultConflict
+		case ConflictRestart, ConflictExtend, ConflictReject, ConflictQueue:
+			c.conflict = strings.TrimSpace(d)
+		default:
+			return newError(CodeInvalidConflict, "Invalid conflict policy:%v, use restart, extend, reject or queue", d)
+		}
+		return nil
+	}
+}
+
+// SetContext cancels the job started by Run when the context is done
+func SetContext(ctx context.Context) func(*Control) error {
+	return func(c *Control) error {
+		if ctx == nil {
+			return errors.New("Context can't be nil")
+		}
+		c.ctx = ctx
+		return nil
+	}
+}
+
+// SetLevel is the level written by the set control type - 0 or 1
+func SetLevel(d string) func(*Control) error {
+	return func(c *Control) error {
+		switch strings.TrimSpace(d) {
+		case "0", "off":
+			c.level = 0
+		case "1", "on":
+			c.level = 1
+		default:
+			return newError(CodeInvalidLevel, "Invalid level:%v, use 0 or 1", d)
+		}
+		return nil
+	}
+}
+
+// enablePin exports the pin as an output with the initial level, the caller holds the pin lock
+func (c *Control) enablePin() error {
+	// enable if not already enabled
+	if c.backend.Exported(c.pin) {
+		return c.applyActiveLow()
+	}
+	if e, ok := c.backend.(OutputExporter); ok {
+		c.forgetActiveLow()
+		if err := c.applyActiveLow(); err != nil {
+			return err
+		}
+		export := func() error { return e.ExportOutput(c.pin, c.initial) }
+		if c.initial == 0 {
+			return export()
+		}
+		return c.guard(true, export)
+	}
+	if err := c.backend.Export(c.pin); err != nil {
+		return err
+	}
+	c.forgetActiveLow()
+	if err := c.applyActiveLow(); err != nil {
+		return err
+	}
+	output := func() error {
+		if o, ok := c.backend.(Outputter); ok {
+			return o.SetOutput(c.pin, c.initial)
+		}
+		// the output starts low until the first write, which is on for active low outputs
+		if err := c.backend.SetDirection(c.pin, Out); err != nil {
+			return err
+		}
+		v := c.initial
+		if c.invert() {
+			v ^= 1
+		}
+		if v == 0 {
+			return nil
+		}
+		return c.backend.Write(c.pin, v)
+	}
+	if c.initial == 0 {
+		return output()
+	}
+	// an initial on is checked against the interlocks and the limits like any other switch on
+	return c.guard(true, output)
+}
+
+func (c *Control) disablePin() {
+	if err := c.backend.Unexport(c.pin); err != nil {
+		log.Printf("Oops can't disable pin %v because %v", c.pin, err)
+	}
+	c.forgetActiveLow()
+}
+
+// Run executes the control with the initiated settings as a job.
+// Toggle, set and pwm jobs are finished when Run returns, timer, ramp and sequence jobs stay active until the deadline.
+func (c *Control) Run() (Job, error) {
+	switch c.ctype {
+	case "timer":
+		return c.startTimer()
+	case "toggle":
+		return runJob(c, c.toggle)
+	case "set":
+		return runJob(c, c.set)
+	case "pwm":
+		return runJob(c, c.pwm)
+	case "ramp":
+		return c.startRamp()
+	case "sequence":
+		return c.startSequence()
+	default:
+		return Job{}, newError(CodeInvalidType, "Invalid control type:%v", c.ctype)
+	}
+}
+
+// Pin returns the controlled pin
+func (c *Control) Pin() string {
+	return c.pin
+}
+
+// Level reads the current level of the pin, exported is false when the pin wasn't used yet
+func (c *Control) Level() (v int, exported bool, err error) {
+	defer lockPin(c.pin)()
+	if !c.backend.Exported(c.pin) {
+		return 0, false, nil
+	}
+	v, err = c.read()
+	return v, true, err
+}
+
+// read returns the logical level of the pin
+func (c *Control) read() (int, error) {
+	v, err := c.backend.Read(c.pin)
+	if c.invert() {
+		v ^= 1
+	}
+	return v, err
+}
+
+// write sets the pin level and notifies the subscribers, a PWM of the pin is stopped.
+// Switching on fails with CodeInterlock when another pin of an interlock group of the pin is on.
+func (c *Control) write(v int) error {
+	stopPWM(c.pin)
+	p := v
+	if c.invert() {
+		p ^= 1
+	}
+	if err := c.guard(v == 1, func() error { return c.backend.Write(c.pin, p) }); err != nil {
+		return err
+	}
+	notify(Event{Type: EventOutput, Pin: c.pin, Value: v, Time: time.Now()})
+	return nil
+}
+
+func (c *Control) set() error {
+	defer lockPin(c.pin)()
+	if err := c.enablePin(); err != nil {
+		log.Printf("I couldn't enable pin %v, because %v", c.pin, err)
+		return err
+	}
+	return c.write(c.level)
+}
+
+func (c *Control) toggle() error {
+	defer lockPin(c.pin)()
+	if err := c.enablePin(); err != nil {
+		log.Printf("I couldn't enable pin %v, because %v", c.pin, err)
+	}
+
+	d, err := c.read()
+	if err != nil {
+		log.Printf("Oh boy can't read the status of pin	%v becasue I don't have my glasses and %v", c.pin, err)
+	}
+
+	if d == 1 {
+		return c.write(0)
+	}
+	return c.write(1)
+}
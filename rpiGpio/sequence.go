@@ -0,0 +1,324 @@
+package rpiGpio
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits of a sequence so a typo can't keep a pin busy for days
+const (
+	MaxSequenceSteps    = 1000
+	MaxSequenceRepeat   = 1000
+	MaxSequenceDuration = 24 * time.Hour
+)
+
+var (
+	repeat = regexp.MustCompile(`^[x*]([0-9]+)$`)
+	// sequences are the running sequence jobs of each pin, guarded by jobsMu
+	sequences = make(map[string]*job)
+)
+
+// levels of the sequence steps, a wait only step doesn't change any pin
+const (
+	stepWait   = -1
+	stepToggle = 2
+)
+
+// step sets the level of a pin and waits, c is nil for the pin of the sequence control
+type step struct {
+	c     *Control
+	level int
+	wait  time.Duration
+}
+
+// SetSequence parses the steps of the sequence control type, the steps are separated with spaces or commas
+//
+//	on 500ms off 200ms on 500ms      - level(on, off, 1, 0 or toggle) and how long to keep it
+//	(on 100ms off 100ms)x3           - repeats the steps in the parentheses
+//	23:on 1s 18:off                  - the level of another pin and a wait without a change
+//
+// The pins written by the steps are switched off when it ends or is cancelled, the others are left alone.
+// resolve creates the controls of the other pins, nil uses the pin number with the backend of the control
+// so SetBackend must come before it.
+func SetSequence(d string, resolve func(pin string) (*Control, error)) func(*Control) error {
+	return func(c *Control) error {
+		if resolve == nil {
+			resolve = func(pin string) (*Control, error) {
+				return NewControl(SetPin(pin), SetBackend(c.backend))
+			}
+		}
+		p := &seqParser{tokens: strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ", ",", " ").Replace(d)), resolve: resolve, pins: make(map[string]*Control)}
+		steps, err := p.parse(0)
+		if err != nil {
+			// the errors of resolve like an unknown pin keep their code
+			if ErrorCode(err) != CodeInvalidSequence {
+				return err
+			}
+			return newError(CodeInvalidSequence, "Invalid sequence:%v", err)
+		}
+		if len(steps) == 0 {
+			return newError(CodeInvalidSequence, "The sequence has no steps")
+		}
+		var total time.Duration
+		for _, s := range steps {
+			total += s.wait
+		}
+		if total > MaxSequenceDuration {
+			return newError(CodeInvalidSequence, "The sequence takes %v, the limit is %v", total, MaxSequenceDuration)
+		}
+		c.steps = steps
+		return nil
+	}
+}
+
+type seqParser struct {
+	tokens  []string
+	i       int
+	resolve func(pin string) (*Control, error)
+	// pins are the controls of the other pins so each pin is resolved once
+	pins map[string]*Control
+}
+
+// parse returns the expanded steps until the end or the closing parenthesis of the depth
+func (p *seqParser) parse(depth int) ([]step, error) {
+	var steps []step
+	for p.i < len(p.tokens) {
+		t := p.tokens[p.i]
+		switch {
+		case t == "(":
+			p.i++
+			group, err := p.parse(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if p.i == len(p.tokens) {
+				return nil, seqError("missing )")
+			}
+			p.i++
+			n := 1
+			if p.i < len(p.tokens) {
+				if m := repeat.FindStringSubmatch(p.tokens[p.i]); m != nil {
+					n, _ = strconv.Atoi(m[1])
+					if n < 1 || n > MaxSequenceRepeat {
+						return nil, seqError("repeat %v, use 1 to %v", p.tokens[p.i], MaxSequenceRepeat)
+					}
+					p.i++
+				}
+			}
+			if len(steps)+n*len(group) > MaxSequenceSteps {
+				return nil, seqError("more than %v steps", MaxSequenceSteps)
+			}
+			for k := 0; k < n; k++ {
+				steps = append(steps, group...)
+			}
+		case t == ")":
+			if depth == 0 {
+				return nil, seqError("unexpected )")
+			}
+			return steps, nil
+		default:
+			s, err := p.step()
+			if err != nil {
+				return nil, err
+			}
+			if len(steps) == MaxSequenceSteps {
+				return nil, seqError("more than %v steps", MaxSequenceSteps)
+			}
+			steps = append(steps, s)
+		}
+	}
+	if depth > 0 {
+		return nil, seqError("missing )")
+	}
+	return steps, nil
+}
+
+// step parses a level with an optional pin and duration or a wait
+func (p *seqParser) step() (step, error) {
+	t := p.tokens[p.i]
+	p.i++
+	pin, level := "", t
+	if i := strings.LastIndex(t, ":"); i >= 0 {
+		pin, level = t[:i], t[i+1:]
+	}
+	s := step{level: stepWait}
+	switch level {
+	case "on", "1":
+		s.level = 1
+	case "off", "0":
+		s.level = 0
+	case "toggle":
+		s.level = stepToggle
+	default:
+		if pin != "" {
+			return s, seqError("invalid level %v, use on, off, 1, 0 or toggle", t)
+		}
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			return s, seqError("invalid step %v, use a level like on or 18:off or a duration like 500ms", t)
+		}
+		s.wait = d
+		return s, nil
+	}
+	if pin != "" {
+		c, ok := p.pins[pin]
+		if !ok {
+			var err error
+			if c, err = p.resolve(pin); err != nil {
+				return s, err
+			}
+			p.pins[pin] = c
+		}
+		s.c = c
+	}
+	if p.i < len(p.tokens) {
+		if d, err := time.ParseDuration(p.tokens[p.i]); err == nil && d > 0 {
+			s.wait = d
+			p.i++
+		}
+	}
+	return s, nil
+}
+
+func seqError(format string, a ...interface{}) error {
+	return newError(CodeInvalidSequence, format, a...)
+}
+
+// SequencePins returns the pins changed by a sequence control other than its own pin
+func (c *Control) SequencePins() []string {
+	var pins []string
+	seen := map[string]bool{c.pin: true}
+	for _, s := range c.steps {
+		if s.c != nil && !seen[s.c.pin] {
+			seen[s.c.pin] = true
+			pins = append(pins, s.c.pin)
+		}
+	}
+	return pins
+}
+
+// startSequence runs the steps in the background as an active job, a running sequence of the pin is cancelled first
+func (c *Control) startSequence() (Job, error) {
+	if len(c.steps) == 0 {
+		return Job{}, newError(CodeInvalidSequence, "The sequence has no steps")
+	}
+	jobsMu.Lock()
+	for {
+		cur, ok := sequences[c.pin]
+		if !ok {
+			break
+		}
+		cur.cancel()
+		jobsMu.Unlock()
+		<-cur.done
+		jobsMu.Lock()
+	}
+	j := newJob(c, JobActive)
+	now := time.Now()
+	deadline := now
+	for _, s := range c.steps {
+		deadline = deadline.Add(s.wait)
+	}
+	j.Started, j.Deadline = &now, &deadline
+	j.Steps = len(c.steps)
+	sequences[c.pin] = j
+	jobsMu.Unlock()
+
+	go j.sequence()
+	return j.snapshot(), nil
+}
+
+// sequence runs the steps until the end or until the job is cancelled and switches the pins off
+func (j *job) sequence() {
+	c := j.c
+	state := JobDone
+	var err error
+	t := time.NewTimer(time.Hour)
+	t.Stop()
+	defer t.Stop()
+	// written are the controls of the pins the steps changed, only they are switched off at the end
+	var written []*Control
+	seen := make(map[*Control]bool)
+steps:
+	for i, s := range c.steps {
+		jobsMu.Lock()
+		j.Step = i + 1
+		jobsMu.Unlock()
+		if s.level != stepWait {
+			sc, e := j.apply(s)
+			if sc != nil && !seen[sc] {
+				seen[sc] = true
+				written = append(written, sc)
+			}
+			if err = e; err == errCancelled {
+				err, state = nil, JobCancelled
+				break
+			} else if err != nil {
+				state = JobFailed
+				break
+			}
+		}
+		if s.wait > 0 {
+			t.Reset(s.wait)
+			select {
+			case <-t.C:
+			case <-j.ctx.Done():
+				state = JobCancelled
+				break steps
+			}
+		}
+	}
+
+	for _, sc := range written {
+		unlock := lockPin(sc.pin)
+		if sc.backend.Exported(sc.pin) {
+			if err := sc.write(0); err != nil {
+				log.Printf("Couldn't disable pin:%v error:%v", sc.pin, err)
+			}
+		}
+		unlock()
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if sequences[j.Pin] == j {
+		delete(sequences, j.Pin)
+	}
+	endJob(j, state, err)
+}
+
+// errCancelled stops a sequence cancelled while it waited for the pin lock
+var errCancelled = errors.New("cancelled")
+
+// apply sets the level of the step under the pin lock unless the job was cancelled,
+// it returns the control of the pin when the pin was written
+func (j *job) apply(s step) (*Control, error) {
+	sc := s.c
+	if sc == nil {
+		sc = j.c
+	}
+	defer lockPin(sc.pin)()
+	if j.ctx.Err() != nil {
+		return nil, errCancelled
+	}
+	if err := sc.enablePin(); err != nil {
+		log.Printf("I couldn't enable pin %v, because %v", sc.pin, err)
+		return nil, err
+	}
+	v := s.level
+	if v == stepToggle {
+		cur, err := sc.read()
+		if err != nil {
+			return nil, err
+		}
+		v = cur ^ 1
+	}
+	if err := sc.write(v); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
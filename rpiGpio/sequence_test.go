@@ -0,0 +1,162 @@
+package rpiGpio
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// deviceResolver resolves the device names like the api and the other pins by their number
+func deviceResolver(b Backend, devices map[string]string) func(pin string) (*Control, error) {
+	return func(pin string) (*Control, error) {
+		if p, ok := devices[pin]; ok {
+			pin = p
+		} else if lineName.MatchString(pin) {
+			return nil, errors.New("No device " + pin)
+		}
+		return NewControl(SetPin(pin), SetBackend(b))
+	}
+}
+
+func TestSequenceParse(t *testing.T) {
+	sim := NewSim()
+	resolve := deviceResolver(sim, map[string]string{"door": "22", "light": "phys:16"})
+	for _, tt := range []struct {
+		sequence string
+		// levels are the levels of the steps, w for a wait only step and t for a toggle
+		levels string
+		// pins are the pins of the steps that change a pin, - for the pin of the sequence
+		pins  string
+		total time.Duration
+	}{
+		{"on 500ms off 200ms on 500ms", "101", "---", 1200 * time.Millisecond},
+		{"on,500ms,off", "10", "--", 500 * time.Millisecond},
+		{"1 1s 0 toggle 1s", "10t", "---", 2 * time.Second},
+		{"on 1s 2s off", "1w0", "--", 3 * time.Second},
+		{"(on 100ms off 100ms)x3", "101010", "------", 600 * time.Millisecond},
+		{"(on 100ms off 100ms)*2 on", "10101", "-----", 400 * time.Millisecond},
+		{"(on 1s)", "1", "-", time.Second},
+		{"((on 10ms off 10ms)x2 toggle 5ms)x2", "1010t1010t", "----------", 90 * time.Millisecond},
+		{"( on 1s ) x2", "11", "--", 2 * time.Second},
+		{"23:on 1s 23:off", "10", "23 23", time.Second},
+		{"phys:16:toggle 1s bcm:23:0", "t0", "23 23", time.Second},
+		{"door:on 1s door:off on light:on", "1011", "22 22 - 23", time.Second},
+	} {
+		t.Run(tt.sequence, func(t *testing.T) {
+			c, err := NewControl(SetPin("18"), SetBackend(sim), SetSequence(tt.sequence, resolve))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var levels, pins []string
+			var total time.Duration
+			for _, s := range c.steps {
+				switch s.level {
+				case stepWait:
+					levels = append(levels, "w")
+				case stepToggle:
+					levels = append(levels, "t")
+				default:
+					levels = append(levels, string(rune('0'+s.level)))
+				}
+				total += s.wait
+				switch {
+				case s.level == stepWait:
+				case s.c == nil:
+					pins = append(pins, "-")
+				default:
+					pins = append(pins, s.c.pin)
+				}
+			}
+			if got := strings.Join(levels, ""); got != tt.levels {
+				t.Fatalf("levels %v, expected %v", got, tt.levels)
+			}
+			if got := strings.Join(pins, ""); got != strings.Replace(tt.pins, " ", "", -1) {
+				t.Fatalf("pins %v, expected %v", strings.Join(pins, " "), tt.pins)
+			}
+			if total != tt.total {
+				t.Fatalf("takes %v, expected %v", total, tt.total)
+			}
+		})
+	}
+}
+
+func TestSequenceInvalid(t *testing.T) {
+	sim := NewSim()
+	resolve := deviceResolver(sim, map[string]string{"door": "22"})
+	for _, tt := range []struct {
+		sequence string
+		code     string
+		err      string
+	}{
+		{"", CodeInvalidSequence, "The sequence has no steps"},
+		{"()x3", CodeInvalidSequence, "The sequence has no steps"},
+		{"on 1s)", CodeInvalidSequence, "unexpected )"},
+		{"(on 1s", CodeInvalidSequence, "missing )"},
+		{"((on 1s)x2", CodeInvalidSequence, "missing )"},
+		{"(on 1s)x0", CodeInvalidSequence, "repeat x0, use 1 to 1000"},
+		{"(on 1s)x1001", CodeInvalidSequence, "repeat x1001, use 1 to 1000"},
+		{"blink", CodeInvalidSequence, "invalid step blink"},
+		{"on -1s", CodeInvalidSequence, "invalid step -1s"},
+		{"0s", CodeInvalidSequence, "invalid step 0s"},
+		{"23:blink", CodeInvalidSequence, "invalid level 23:blink"},
+		{"23:500ms", CodeInvalidSequence, "invalid level 23:500ms"},
+		{"(on 1ms)x1000 off", CodeInvalidSequence, "more than 1000 steps"},
+		{"((on 1ms off 1ms)x10)x51", CodeInvalidSequence, "more than 1000 steps"},
+		{"(on 2h)x13", CodeInvalidSequence, "The sequence takes 26h0m0s, the limit is 24h0m0s"},
+		// the errors of the pins keep their code
+		{"99:on", CodeInvalidPin, "Invalid GPIO pin number:99"},
+		{"phys:1:on", CodeInvalidPin, "isn't a gpio"},
+		{"garage:on 1s", CodeGPIO, "No device garage"},
+	} {
+		t.Run(tt.sequence, func(t *testing.T) {
+			_, err := NewControl(SetPin("18"), SetBackend(sim), SetSequence(tt.sequence, resolve))
+			if err == nil {
+				t.Fatal("the sequence is valid")
+			}
+			if ErrorCode(err) != tt.code || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("%v %q, expected %v with %q", ErrorCode(err), err, tt.code, tt.err)
+			}
+		})
+	}
+}
+
+func TestSequenceOwnPin(t *testing.T) {
+	sim := NewSim()
+	forgetPins(t, "26", "27")
+	// a toggle holds the pin of the sequence, which the steps don't write
+	c, _ := NewControl(SetType("toggle"), SetPin("27"), SetBackend(sim))
+	if _, err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewControl(SetType("sequence"), SetPin("27"), SetBackend(sim), SetSequence("26:on 10ms 26:toggle 10ms 26:on", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := c.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, j.ID, JobDone)
+	if simLevel(sim, "27") != 1 {
+		t.Fatal("the sequence switched off its own pin that no step wrote")
+	}
+	if simLevel(sim, "26") != 0 {
+		t.Fatal("the pin the steps wrote is still on after the sequence")
+	}
+	for _, e := range sim.History() {
+		if e.Pin == "27" && e.Value == 0 {
+			t.Fatal("the pin of the sequence went off")
+		}
+	}
+
+	// the pin of the sequence is switched off when the steps wrote it
+	c, _ = NewControl(SetType("sequence"), SetPin("27"), SetBackend(sim), SetSequence("toggle 10ms on", nil))
+	if j, err = c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, j.ID, JobDone)
+	if simLevel(sim, "27") != 0 {
+		t.Fatal("the own pin written by the steps is on after the sequence")
+	}
+}
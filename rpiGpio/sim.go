@@ -0,0 +1,291 @@
+package rpiGpio
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// simHistory is how many transitions the simulated board remembers
+const simHistory = 1000
+
+// Transition is a recorded level change of a simulated pin
+type Transition struct {
+	Pin   string    `json:"pin"`
+	Edge  Edge      `json:"edge"`
+	Value int       `json:"value"`
+	Time  time.Time `json:"time"`
+	// Injected is set when the change came from Inject rather than a Write
+	Injected bool `json:"injected"`
+}
+
+// SimPin is the state of a simulated pin
+type SimPin struct {
+	Pin       string    `json:"pin"`
+	Exported  bool      `json:"exported"`
+	Direction Direction `json:"direction"`
+	Value     int       `json:"value"`
+	Bias      Bias      `json:"bias,omitempty"`
+	// ActiveLow inverts Read and Write, Value is always the physical level
+	ActiveLow bool `json:"active_low,omitempty"`
+	// driven is set once a level was injected so the pull resistor no longer sets the level
+	driven bool
+}
+
+// Sim is a Backend that keeps the whole board in memory and logs every transition.
+// It is used to run the app without any gpio hardware.
+type Sim struct {
+	mu       sync.Mutex
+	pins     map[string]*SimPin
+	history  []Transition
+	watchers map[string]map[chan struct{}]struct{}
+}
+
+// NewSim creates a simulated board with all pins unexported
+func NewSim() *Sim {
+	return &Sim{
+		pins:     make(map[string]*SimPin),
+		watchers: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+func (s *Sim) pin(pin string) *SimPin {
+	p, ok := s.pins[pin]
+	if !ok {
+		p = &SimPin{Pin: pin, Direction: In}
+		s.pins[pin] = p
+	}
+	return p
+}
+
+func (s *Sim) exported(pin string) (*SimPin, error) {
+	p, ok := s.pins[pin]
+	if !ok || !p.Exported {
+		return nil, fmt.Errorf("Pin %v is not exported", pin)
+	}
+	return p, nil
+}
+
+// set changes the pin level and records the transition when the level actually changed
+func (s *Sim) set(p *SimPin, v int, injected bool) {
+	if v != 0 {
+		v = 1
+	}
+	if p.Value == v {
+		return
+	}
+	p.Value = v
+	t := Transition{Pin: p.Pin, Edge: Rising, Value: v, Time: time.Now(), Injected: injected}
+	if v == 0 {
+		t.Edge = Falling
+	}
+	s.history = append(s.history, t)
+	if len(s.history) > simHistory {
+		s.history = s.history[len(s.history)-simHistory:]
+	}
+	log.Printf("Simulated pin %v %v to %v", p.Pin, t.Edge, v)
+	for ch := range s.watchers[p.Pin] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Exported reports if the pin is exported
+func (s *Sim) Exported(pin string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pins[pin]
+	return ok && p.Exported
+}
+
+// Export enables the pin
+func (s *Sim) Export(pin string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pin(pin)
+	if !p.Exported {
+		p.Exported = true
+		log.Printf("Simulated pin %v exported", pin)
+	}
+	return nil
+}
+
+// Unexport disables the pin
+func (s *Sim) Unexport(pin string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.pins[pin]; ok && p.Exported {
+		p.Exported = false
+		log.Printf("Simulated pin %v unexported", pin)
+	}
+	return nil
+}
+
+// SetDirection changes the pin direction, like sysfs an output starts low
+func (s *Sim) SetDirection(pin string, d Direction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.exported(pin)
+	if err != nil {
+		return err
+	}
+	if d != In && d != Out {
+		return fmt.Errorf("Invalid direction:%v", d)
+	}
+	p.Direction = d
+	log.Printf("Simulated pin %v direction %v", pin, d)
+	if d == Out {
+		s.set(p, 0, false)
+	}
+	return nil
+}
+
+// Read returns the pin level
+func (s *Sim) Read(pin string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.exported(pin)
+	if err != nil {
+		return 0, err
+	}
+	if p.ActiveLow {
+		return p.Value ^ 1, nil
+	}
+	return p.Value, nil
+}
+
+// Write sets the level of an output pin
+func (s *Sim) Write(pin string, v int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.exported(pin)
+	if err != nil {
+		return err
+	}
+	if p.Direction != Out {
+		return fmt.Errorf("Can't write to pin %v, it is an input", pin)
+	}
+	s.set(p, s.physical(p, v), false)
+	return nil
+}
+
+// physical returns the level of the pin for the logical value
+func (s *Sim) physical(p *SimPin, v int) int {
+	if v != 0 {
+		v = 1
+	}
+	if p.ActiveLow {
+		v ^= 1
+	}
+	return v
+}
+
+// SetActiveLow inverts the levels of the pin, the physical level stays the same
+func (s *Sim) SetActiveLow(pin string, on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.exported(pin)
+	if err != nil {
+		return err
+	}
+	if p.ActiveLow != on {
+		p.ActiveLow = on
+		log.Printf("Simulated pin %v active low %v", pin, on)
+	}
+	return nil
+}
+
+// SetOutput makes the pin an output with the level without starting low first
+func (s *Sim) SetOutput(pin string, v int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.exported(pin)
+	if err != nil {
+		return err
+	}
+	p.Direction = Out
+	log.Printf("Simulated pin %v direction %v", pin, Out)
+	s.set(p, s.physical(p, v), false)
+	return nil
+}
+
+// Inject changes the level of a pin as if an external device drives it,
+// scripts use it to simulate sensors and buttons connected to inputs.
+func (s *Sim) Inject(pin string, v int) error {
+	if _, err := strconv.Atoi(pin); err != nil {
+		return fmt.Errorf("Invalid GPIO pin number:%v", pin)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pin(pin)
+	if p.Direction == Out {
+		return fmt.Errorf("Can't inject a level to pin %v, it is an output", pin)
+	}
+	p.driven = true
+	s.set(p, v, true)
+	return nil
+}
+
+// SetBias sets the pull resistor, it sets the level of an input until a level is injected
+func (s *Sim) SetBias(pin string, b Bias) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pin(pin)
+	p.Bias = b
+	if p.Direction == In && !p.driven {
+		switch b {
+		case PullUp:
+			s.set(p, 1, false)
+		case PullDown:
+			s.set(p, 0, false)
+		}
+	}
+	return nil
+}
+
+// Watch notifies about every level change of the pin
+func (s *Sim) Watch(pin string, stop <-chan struct{}) (<-chan struct{}, error) {
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	if s.watchers[pin] == nil {
+		s.watchers[pin] = make(map[chan struct{}]struct{})
+	}
+	s.watchers[pin][ch] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		<-stop
+		s.mu.Lock()
+		delete(s.watchers[pin], ch)
+		s.mu.Unlock()
+	}()
+	return ch, nil
+}
+
+// Pins returns the state of all pins the simulation knows about sorted by pin number
+func (s *Sim) Pins() []SimPin {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pins := make([]SimPin, 0, len(s.pins))
+	for _, p := range s.pins {
+		pins = append(pins, *p)
+	}
+	sort.Slice(pins, func(i, j int) bool {
+		a, _ := strconv.Atoi(pins[i].Pin)
+		b, _ := strconv.Atoi(pins[j].Pin)
+		return a < b
+	})
+	return pins
+}
+
+// History returns the recorded transitions, oldest first
+func (s *Sim) History() []Transition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Transition(nil), s.history...)
+}
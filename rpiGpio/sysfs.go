@@ -0,0 +1,121 @@
+package rpiGpio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultSysfsRoot is where the kernel exposes the sysfs GPIO interface
+const DefaultSysfsRoot = "/sys/class/gpio"
+
+// Sysfs is a Backend that uses the kernel sysfs GPIO interface.
+// The root can point to any directory with the same layout so it also works against a fake tree.
+type Sysfs struct {
+	root string
+}
+
+// NewSysfs creates a sysfs backend rooted at the given directory
+func NewSysfs(root string) *Sysfs {
+	return &Sysfs{root: root}
+}
+
+// Root returns the directory of the sysfs tree
+func (s *Sysfs) Root() string {
+	return s.root
+}
+
+func (s *Sysfs) pinPath(pin string, file string) string {
+	return filepath.Join(s.root, "gpio"+pin, file)
+}
+
+// Exported checks if the gpio directory of the pin exists
+func (s *Sysfs) Exported(pin string) bool {
+	_, err := os.Stat(filepath.Join(s.root, "gpio"+pin))
+	return err == nil
+}
+
+// Export enables the pin if not already enabled
+func (s *Sysfs) Export(pin string) error {
+	if s.Exported(pin) {
+		return nil
+	}
+	if _, err := strconv.Atoi(pin); err != nil {
+		return fmt.Errorf("The sysfs backend needs a GPIO pin number, line names like %v need the gpio character device", pin)
+	}
+	f := filepath.Join(s.root, "export")
+	if _, err := os.Stat(f); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f, []byte(pin), 0644)
+}
+
+// Unexport disables the pin if it is enabled
+func (s *Sysfs) Unexport(pin string) error {
+	if !s.Exported(pin) {
+		// it is already disabled so nothing else to do, bail out
+		return nil
+	}
+	return ioutil.WriteFile(filepath.Join(s.root, "unexport"), []byte(pin), 0644)
+}
+
+// SetDirection writes the pin direction
+func (s *Sysfs) SetDirection(pin string, d Direction) error {
+	return ioutil.WriteFile(s.pinPath(pin, "direction"), []byte(d), 0644)
+}
+
+// SetActiveLow sets the active_low attribute so the kernel inverts the value of the pin
+func (s *Sysfs) SetActiveLow(pin string, on bool) error {
+	v := "0"
+	if on {
+		v = "1"
+	}
+	return ioutil.WriteFile(s.pinPath(pin, "active_low"), []byte(v), 0644)
+}
+
+// SetOutput makes the pin an output with the level in one write of high or low to the direction.
+// The direction takes the physical level so an active-low value is inverted,
+// the value is written again for fake trees where nothing updates it.
+func (s *Sysfs) SetOutput(pin string, v int) error {
+	if v != 0 {
+		v = 1
+	}
+	raw := v
+	if a, err := ioutil.ReadFile(s.pinPath(pin, "active_low")); err == nil && strings.TrimSpace(string(a)) == "1" {
+		raw ^= 1
+	}
+	d := "low"
+	if raw == 1 {
+		d = "high"
+	}
+	if err := ioutil.WriteFile(s.pinPath(pin, "direction"), []byte(d), 0644); err != nil {
+		return err
+	}
+	return s.Write(pin, v)
+}
+
+// Read returns the current pin level
+func (s *Sysfs) Read(pin string) (int, error) {
+	d, err := ioutil.ReadFile(s.pinPath(pin, "value"))
+	if err != nil {
+		return 0, err
+	}
+	switch strings.TrimSpace(string(d)) {
+	case "0":
+		return 0, nil
+	case "1":
+		return 1, nil
+	}
+	return 0, fmt.Errorf("Unexpected value for pin %v:%q", pin, d)
+}
+
+// Write sets the pin level
+func (s *Sysfs) Write(pin string, v int) error {
+	if v != 0 {
+		v = 1
+	}
+	return ioutil.WriteFile(s.pinPath(pin, "value"), []byte(fmt.Sprint(v)), 0644)
+}
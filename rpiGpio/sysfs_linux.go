@@ -0,0 +1,65 @@
+package rpiGpio
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"syscall"
+)
+
+// Watch enables edge interrupts for the pin and waits for them with epoll.
+// It fails on fake sysfs trees made of regular files so inputs fall back to polling.
+func (s *Sysfs) Watch(pin string, stop <-chan struct{}) (<-chan struct{}, error) {
+	if err := ioutil.WriteFile(s.pinPath(pin, "edge"), []byte(Both), 0644); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.pinPath(pin, "value"))
+	if err != nil {
+		return nil, err
+	}
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fd := int(f.Fd())
+	ev := syscall.EpollEvent{Events: syscall.EPOLLPRI | syscall.EPOLLERR, Fd: int32(fd)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		syscall.Close(epfd)
+		f.Close()
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer f.Close()
+		defer syscall.Close(epfd)
+		events := make([]syscall.EpollEvent, 1)
+		buf := make([]byte, 8)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// the timeout is how quickly the watch notices that it should stop
+			n, err := syscall.EpollWait(epfd, events, 100)
+			if err == syscall.EINTR || n == 0 {
+				continue
+			}
+			if err != nil {
+				log.Printf("Stopped watching pin %v because %v", pin, err)
+				return
+			}
+			// the interrupt is cleared by reading the value from the start
+			if _, err := f.Seek(0, 0); err == nil {
+				f.Read(buf)
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -0,0 +1,169 @@
+package rpiGpio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSysfs is a /sys/class/gpio tree made of regular files
+func fakeSysfs(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, f := range []string{"export", "unexport"} {
+		if err := ioutil.WriteFile(filepath.Join(root, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// exportPin creates the directory of the pin like the kernel does after a write to export
+func exportPin(t *testing.T, root, pin string) {
+	t.Helper()
+	dir := filepath.Join(root, "gpio"+pin)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for f, v := range map[string]string{"direction": "in\n", "value": "0\n", "active_low": "0\n"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func sysfsFile(t *testing.T, root, name string) string {
+	t.Helper()
+	d, err := ioutil.ReadFile(filepath.Join(root, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(d)
+}
+
+func TestSysfs(t *testing.T) {
+	root := fakeSysfs(t)
+	s := NewSysfs(root)
+
+	if s.Exported("17") {
+		t.Fatal("the pin is exported in an empty tree")
+	}
+	if err := s.Export("17"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sysfsFile(t, root, "export"); got != "17" {
+		t.Fatalf("%q written to export", got)
+	}
+	exportPin(t, root, "17")
+	if !s.Exported("17") {
+		t.Fatal("the exported pin isn't reported as exported")
+	}
+	// an exported pin isn't exported again
+	ioutil.WriteFile(filepath.Join(root, "export"), nil, 0644)
+	if err := s.Export("17"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sysfsFile(t, root, "export"); got != "" {
+		t.Fatalf("the exported pin was exported again:%q", got)
+	}
+
+	if err := s.SetDirection("17", Out); err != nil {
+		t.Fatal(err)
+	}
+	if got := sysfsFile(t, root, "gpio17/direction"); got != "out" {
+		t.Fatalf("%q written to the direction", got)
+	}
+	for _, v := range []int{1, 0, 5} {
+		if err := s.Write("17", v); err != nil {
+			t.Fatal(err)
+		}
+		want := 0
+		if v != 0 {
+			want = 1
+		}
+		if got, err := s.Read("17"); err != nil || got != want {
+			t.Fatalf("read %v:%v after writing %v", got, err, v)
+		}
+	}
+
+	// the direction takes the physical level, which is inverted for active low pins
+	for _, tt := range []struct {
+		activeLow bool
+		v         int
+		direction string
+	}{
+		{false, 1, "high"},
+		{false, 0, "low"},
+		{true, 1, "low"},
+		{true, 0, "high"},
+	} {
+		if err := s.SetActiveLow("17", tt.activeLow); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetOutput("17", tt.v); err != nil {
+			t.Fatal(err)
+		}
+		if got := sysfsFile(t, root, "gpio17/direction"); got != tt.direction {
+			t.Fatalf("active low %v output %v wrote %q to the direction, expected %v", tt.activeLow, tt.v, got, tt.direction)
+		}
+		if got, _ := s.Read("17"); got != tt.v {
+			t.Fatalf("active low %v output %v reads %v", tt.activeLow, tt.v, got)
+		}
+	}
+
+	ioutil.WriteFile(filepath.Join(root, "gpio17", "value"), []byte("x\n"), 0644)
+	if _, err := s.Read("17"); err == nil {
+		t.Fatal("an unexpected value didn't fail")
+	}
+
+	if err := s.Unexport("17"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sysfsFile(t, root, "unexport"); got != "17" {
+		t.Fatalf("%q written to unexport", got)
+	}
+	// a pin that isn't exported isn't unexported
+	if err := s.Unexport("18"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sysfsFile(t, root, "unexport"); got != "17" {
+		t.Fatalf("%q written to unexport for a pin that isn't exported", got)
+	}
+}
+
+func TestSysfsExportErrors(t *testing.T) {
+	root := fakeSysfs(t)
+	s := NewSysfs(root)
+	if err := s.Export("GPIO17"); err == nil || !strings.Contains(err.Error(), "character device") {
+		t.Fatalf("a line name was exported:%v", err)
+	}
+	if got := sysfsFile(t, root, "export"); got != "" {
+		t.Fatalf("%q written to export for a line name", got)
+	}
+	// a missing export file isn't created
+	if err := NewSysfs(filepath.Join(root, "missing")).Export("17"); err == nil {
+		t.Fatal("the export of a missing tree didn't fail")
+	}
+}
+
+// TestSysfsControl switches a pin through a control on the fake tree
+func TestSysfsControl(t *testing.T) {
+	root := fakeSysfs(t)
+	exportPin(t, root, "16")
+	forgetPins(t, "16")
+	b := NewSysfs(root)
+	if err := setLevel(b, "16", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sysfsFile(t, root, "gpio16/value"); got != "1" {
+		t.Fatalf("the value is %q after switching the pin on", got)
+	}
+	if err := setLevel(b, "16", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sysfsFile(t, root, "gpio16/value"); got != "0" {
+		t.Fatalf("the value is %q after switching the pin off", got)
+	}
+}
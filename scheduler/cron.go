@@ -0,0 +1,173 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cron is a parsed cron expression - minute hour day-of-month month day-of-week
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// with a * in one of the day fields only the other one has to match,
+	// when both are set a day matching either of them is enough like in the classic cron
+	domStar, dowStar bool
+}
+
+var shortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dowNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// parseCron parses a 5 field cron expression like "0 18 * * mon-fri" or a shortcut like @daily
+func parseCron(expr string) (*cron, error) {
+	expr = strings.TrimSpace(expr)
+	if s, ok := shortcuts[strings.ToLower(expr)]; ok {
+		expr = s
+	}
+	f := strings.Fields(expr)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("Invalid cron expression:%q, use minute hour day-of-month month day-of-week", expr)
+	}
+	c := &cron{domStar: f[2] == "*", dowStar: f[4] == "*"}
+	var err error
+	if c.minute, err = parseField(f[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("Invalid minute in %q:%v", expr, err)
+	}
+	if c.hour, err = parseField(f[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("Invalid hour in %q:%v", expr, err)
+	}
+	if c.dom, err = parseField(f[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("Invalid day of month in %q:%v", expr, err)
+	}
+	if c.month, err = parseField(f[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("Invalid month in %q:%v", expr, err)
+	}
+	// 7 is also sunday
+	if c.dow, err = parseField(f[4], 0, 7, dowNames); err != nil {
+		return nil, fmt.Errorf("Invalid day of week in %q:%v", expr, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseField returns the bits of the allowed values of a field like "*/15", "1-5" or "mon,wed,fri"
+func parseField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", item[i+1:])
+			}
+			rng = item[:i]
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			p := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = parseValue(p[0], names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(p) == 2 {
+				if hi, err = parseValue(p[1], names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// 5/15 means from 5 to the end every 15
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of the range %v-%v", item, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+// next returns the first time after t that matches, in the location of t.
+// The zero time is returned when nothing matches in the next 5 years like for the 30th of February.
+// Times skipped by a daylight saving change don't run that day and times repeated by it run twice.
+func (c *cron) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.Year() + 5
+
+wrap:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+	for c.month&(1<<uint(t.Month())) == 0 {
+		t = midnight(time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !c.dayMatches(t) {
+		t = midnight(time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	// hours and minutes are stepped in absolute time so a daylight saving change can't hold them back
+	for c.hour&(1<<uint(t.Hour())) == 0 {
+		t = t.Add(time.Hour - time.Duration(t.Minute())*time.Minute)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for c.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	return t
+}
+
+// midnight returns d, the start of a day. In the zones that switch to summer time at midnight
+// the day starts at 1:00 and time.Date can return the last hour of the day before.
+func midnight(d time.Time) time.Time {
+	if d.Hour() == 23 {
+		return d.Add(time.Hour)
+	}
+	return d
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
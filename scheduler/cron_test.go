@@ -0,0 +1,137 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		want cron
+	}{
+		{"0 18 * * mon-fri", cron{minute: 1, hour: 1 << 18, dom: days(1, 31), month: days(1, 12), dow: days(1, 5), domStar: true, dowStar: false}},
+		{"*/15 9-17/4 1,15 jan,JUL 7", cron{minute: bits(0, 15, 30, 45), hour: bits(9, 13, 17), dom: bits(1, 15), month: bits(1, 7), dow: bits(0, 7)}},
+		{"5/20 0 * * 0", cron{minute: bits(5, 25, 45), hour: 1, dom: days(1, 31), month: days(1, 12), dow: 1, domStar: true}},
+		{"@daily", cron{minute: 1, hour: 1, dom: days(1, 31), month: days(1, 12), dow: days(0, 7), domStar: true, dowStar: true}},
+		{" @Weekly ", cron{minute: 1, hour: 1, dom: days(1, 31), month: days(1, 12), dow: 1, domStar: true}},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("%q:%v", tt.expr, err)
+			continue
+		}
+		if *c != tt.want {
+			t.Errorf("%q\ngot  %+v\nwant %+v", tt.expr, *c, tt.want)
+		}
+	}
+
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+		"1,,2 * * * *",
+		"@reboot",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q didn't fail", expr)
+		}
+	}
+}
+
+// bits returns the field bits of the values
+func bits(v ...int) uint64 {
+	var b uint64
+	for _, i := range v {
+		b |= 1 << uint(i)
+	}
+	return b
+}
+
+// days returns the field bits of the range
+func days(from, to int) uint64 {
+	var b uint64
+	for i := from; i <= to; i++ {
+		b |= 1 << uint(i)
+	}
+	return b
+}
+
+func TestCronNext(t *testing.T) {
+	sofia, err := time.LoadLocation("Europe/Sofia")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04:05", s, sofia)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name, expr, from string
+		// want is in Europe/Sofia, empty when the expression never runs
+		want string
+		// wantUTC checks the runs around the daylight saving changes where the local time is ambiguous
+		wantUTC string
+	}{
+		{name: "weekdays over a weekend", expr: "0 18 * * mon-fri", from: "2017-12-22 19:00:00", want: "2017-12-25 18:00:00"},
+		{name: "later the same day", expr: "0 18 * * mon-fri", from: "2017-12-22 17:59:30", want: "2017-12-22 18:00:00"},
+		{name: "never the same minute", expr: "* * * * *", from: "2017-12-22 18:00:00", want: "2017-12-22 18:01:00"},
+		{name: "every 15 minutes", expr: "*/15 * * * *", from: "2017-12-22 23:50:00", want: "2017-12-23 00:00:00"},
+		{name: "next year", expr: "0 0 1 1 *", from: "2017-06-01 00:00:00", want: "2018-01-01 00:00:00"},
+		{name: "day of month or day of week", expr: "0 0 13 * fri", from: "2017-10-01 00:00:00", want: "2017-10-06 00:00:00"},
+		{name: "day of month and any day of week", expr: "0 0 13 * *", from: "2017-10-01 00:00:00", want: "2017-10-13 00:00:00"},
+		{name: "leap day", expr: "0 12 29 2 *", from: "2017-03-01 00:00:00", want: "2020-02-29 12:00:00"},
+		{name: "30th of february", expr: "0 0 30 2 *", from: "2017-01-01 00:00:00"},
+		{name: "summer time after the skipped hour", expr: "0 4 * * *", from: "2018-03-25 00:00:00", want: "2018-03-25 04:00:00", wantUTC: "2018-03-25 01:00:00"},
+		{name: "the skipped hour doesn't run", expr: "30 3 * * *", from: "2018-03-24 12:00:00", want: "2018-03-26 03:30:00"},
+		{name: "the repeated hour runs first in summer time", expr: "30 3 * * *", from: "2018-10-28 00:00:00", wantUTC: "2018-10-28 00:30:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := c.next(at(tt.from))
+			if tt.want == "" && tt.wantUTC == "" {
+				if !got.IsZero() {
+					t.Fatalf("expected no run, got %v", got)
+				}
+				return
+			}
+			if got.Location() != sofia {
+				t.Fatalf("the run is in %v, expected the location of the start time", got.Location())
+			}
+			if tt.want != "" && !got.Equal(at(tt.want)) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if tt.wantUTC != "" && got.UTC().Format("2006-01-02 15:04:05") != tt.wantUTC {
+				t.Fatalf("got %v, want %v UTC", got.UTC(), tt.wantUTC)
+			}
+		})
+	}
+
+	// the clocks go back at 4:00 so 3:30 happens twice, once in summer and once in winter time
+	c, _ := parseCron("30 3 * * *")
+	first := c.next(at("2018-10-28 00:00:00"))
+	second := c.next(first)
+	if second.Sub(first) != time.Hour || second.Hour() != 3 || second.Minute() != 30 {
+		t.Fatalf("the repeated 3:30 runs at %v after %v", second, first)
+	}
+	if third := c.next(second); third.Day() != 29 {
+		t.Fatalf("the run after the repeated hour is %v", third)
+	}
+}
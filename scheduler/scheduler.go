@@ -0,0 +1,526 @@
+// Package scheduler runs device actions at the times of cron expressions, one-off dates and sun events
+//
+//	{"name":"sign lights","device":"lab-sign","action":"on","cron":"0 18 * * mon-fri","timezone":"Europe/Sofia"}
+//	{"name":"heating off","device":"heating","action":"off","at":"2017-12-24T00:00"}
+//	{"name":"garden lights","device":"garden","action":"on","sun":"sunset","offset":"30m"}
+//
+// The sun times are computed locally from the latitude and the longitude, no network service is needed.
+// The schedules are kept in a json file so they survive restarts.
+// Runs missed while the daemon wasn't running are skipped unless the schedule asks for run-once,
+// then a single catch-up run happens at startup however many runs were missed.
+package scheduler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Actions of a schedule, an empty action uses the control type of the device
+const (
+	On     = "on"
+	Off    = "off"
+	Toggle = "toggle"
+	Pulse  = "pulse"
+)
+
+// What happens with the runs missed while the daemon wasn't running
+const (
+	// MissedSkip forgets the missed runs and waits for the next one, the default
+	MissedSkip = "skip"
+	// MissedRunOnce runs the action once at startup
+	MissedRunOnce = "run-once"
+)
+
+// maxWait is the longest the scheduler sleeps before checking the clock again.
+// A Pi without a real time clock gets the time from the network after the boot,
+// the timers don't follow such jumps of the wall clock.
+const maxWait = time.Minute
+
+// ErrUnknown is returned for a schedule id that doesn't exist
+var ErrUnknown = errors.New("No such schedule")
+
+// Schedule is a device action that runs at the times of a cron expression, once at a date or daily at a sun event
+type Schedule struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Device is a device name or a pin number
+	Device string `json:"device"`
+	// Action is on, off, toggle or pulse, empty uses the control type of the device
+	Action string `json:"action,omitempty"`
+	// Delay is how long a pulse keeps the pin on, empty uses the delay of the device
+	Delay string `json:"delay,omitempty"`
+	// Cron is a repeating schedule like "0 18 * * mon-fri", At a single run like 2017-12-24T18:00
+	// and Sun a daily sun event like sunset, only one of them is set
+	Cron string `json:"cron,omitempty"`
+	At   string `json:"at,omitempty"`
+	Sun  string `json:"sun,omitempty"`
+	// Offset moves the sun event, -15m is 15 minutes before it
+	Offset string `json:"offset,omitempty"`
+	// TimeZone is the time zone of Cron and At like Europe/Sofia, empty is the local time zone.
+	// For Sun it sets the days, the sun times don't depend on it.
+	TimeZone string `json:"timezone,omitempty"`
+	// Missed is skip or run-once
+	Missed string `json:"missed,omitempty"`
+	Paused bool   `json:"paused"`
+	// Owner is the user that added the schedule, the action runs with the user's permissions
+	Owner   string    `json:"owner,omitempty"`
+	Created time.Time `json:"created"`
+	// Next is the next run, nil for paused and finished schedules
+	Next      *time.Time `json:"next,omitempty"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	// Done is set once a one-off schedule ran or was missed
+	Done bool `json:"done,omitempty"`
+}
+
+// entry is a schedule with its parsed time settings
+type entry struct {
+	Schedule
+	cron   *cron
+	at     time.Time
+	offset time.Duration
+	loc    *time.Location
+	// where is the location of the sun schedules
+	where *Location
+	// invalid is set for a schedule from the file that doesn't load anymore,
+	// it is kept in the file as it can work again once the config is fixed
+	invalid error
+}
+
+// Scheduler keeps the schedules and runs them on time
+type Scheduler struct {
+	// path is the schedules file, empty keeps them only in memory
+	path string
+	// where is the location for the sun schedules, nil when it isn't configured
+	where *Location
+	run   func(Schedule) error
+
+	mu        sync.Mutex
+	schedules map[string]*entry
+
+	wake    chan struct{}
+	quit    chan struct{}
+	done    chan struct{}
+	started bool
+}
+
+// Open loads the schedules file, the file is created with the first schedule.
+// where is needed for the sun schedules, it can be nil.
+// run executes the action of a schedule, it is called from a single goroutine.
+func Open(path string, where *Location, run func(Schedule) error) (*Scheduler, error) {
+	s := &Scheduler{
+		path:      path,
+		where:     where,
+		run:       run,
+		schedules: make(map[string]*entry),
+		wake:      make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if path == "" {
+		return s, nil
+	}
+	var l []Schedule
+	d, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(d, &l); err != nil {
+			return nil, fmt.Errorf("Invalid schedules file %v:%v", path, err)
+		}
+	}
+	for _, sc := range l {
+		e, err := newEntry(sc, where)
+		if err != nil {
+			log.Printf("Schedule %v won't run:%v", sc.ID, err)
+			e = &entry{Schedule: sc, invalid: err}
+			e.Next, e.LastError = nil, err.Error()
+		}
+		s.schedules[e.ID] = e
+	}
+	return s, nil
+}
+
+// newEntry validates the schedule and parses its time settings
+func newEntry(s Schedule, where *Location) (*entry, error) {
+	e := &entry{Schedule: s, where: where}
+	if s.Device == "" {
+		return nil, errors.New("The device is required")
+	}
+	switch s.Action {
+	case "", On, Off, Toggle, Pulse:
+	default:
+		return nil, fmt.Errorf("Invalid action:%v, use on, off, toggle or pulse", s.Action)
+	}
+	if s.Delay != "" {
+		if _, err := time.ParseDuration(s.Delay); err != nil {
+			return nil, fmt.Errorf("Invalid delay:%v", s.Delay)
+		}
+	}
+	switch s.Missed {
+	case "":
+		e.Missed = MissedSkip
+	case MissedSkip, MissedRunOnce:
+	default:
+		return nil, fmt.Errorf("Invalid missed run policy:%v, use skip or run-once", s.Missed)
+	}
+
+	var err error
+	// LoadLocation returns UTC for an empty name
+	e.loc = time.Local
+	if s.TimeZone != "" {
+		if e.loc, err = time.LoadLocation(s.TimeZone); err != nil {
+			return nil, fmt.Errorf("Invalid time zone:%v", s.TimeZone)
+		}
+	}
+	if s.Offset != "" {
+		if s.Sun == "" {
+			return nil, errors.New("The offset is only for the sun schedules")
+		}
+		if e.offset, err = time.ParseDuration(s.Offset); err != nil {
+			return nil, fmt.Errorf("Invalid offset:%v, use a duration like 30m or -1h", s.Offset)
+		}
+	}
+	switch {
+	case countSet(s.Cron, s.At, s.Sun) > 1:
+		return nil, errors.New("Set only one of a cron expression, a date or a sun event")
+	case s.Sun != "":
+		if _, ok := sunEvents[s.Sun]; !ok {
+			return nil, fmt.Errorf("Invalid sun event:%v, use sunrise, sunset, civil-dawn, civil-dusk, nautical-dawn or nautical-dusk", s.Sun)
+		}
+		if where == nil {
+			return nil, errors.New("The sun schedules need the latitude and the longitude in the [location] of the config file")
+		}
+	case s.Cron != "":
+		if e.cron, err = parseCron(s.Cron); err != nil {
+			return nil, err
+		}
+	case s.At != "":
+		if e.at, err = parseAt(s.At, e.loc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Set a cron expression, a date or a sun event")
+	}
+	return e, nil
+}
+
+func countSet(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// parseAt parses the date of a one-off schedule, a date without a time zone offset is in the schedule time zone
+func parseAt(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid date:%v, use RFC3339 or 2006-01-02T15:04", s)
+}
+
+// advance sets the next run after t
+func (e *entry) advance(t time.Time) {
+	e.Next = nil
+	if e.invalid != nil {
+		return
+	}
+	if e.Sun != "" {
+		if n := nextSun(e.Sun, e.offset, *e.where, t.In(e.loc)); !n.IsZero() {
+			e.Next = &n
+		}
+		return
+	}
+	if e.cron == nil {
+		if e.at.After(t) {
+			at := e.at
+			e.Next = &at
+		} else {
+			e.Done = true
+		}
+		return
+	}
+	if n := e.cron.next(t.In(e.loc)); !n.IsZero() {
+		e.Next = &n
+	}
+}
+
+// Start handles the runs missed while the daemon wasn't running and starts running the schedules
+func (s *Scheduler) Start() {
+	now := time.Now()
+	var missed []Schedule
+	s.mu.Lock()
+	for _, e := range s.sorted() {
+		if e.Paused || e.Done {
+			continue
+		}
+		if e.Next != nil && !e.Next.After(now) {
+			if e.Missed == MissedRunOnce {
+				log.Printf("Schedule %v missed its run at %v, running it now", e.label(), e.Next.Format(time.RFC3339))
+				missed = append(missed, e.Schedule)
+			} else {
+				log.Printf("Schedule %v missed its run at %v, skipping it", e.label(), e.Next.Format(time.RFC3339))
+			}
+		}
+		e.advance(now)
+	}
+	s.started = true
+	s.save()
+	s.mu.Unlock()
+
+	for _, sc := range missed {
+		s.runOne(sc, now)
+	}
+	go s.loop()
+}
+
+// Stop waits for a running action and stops the scheduler
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+	if !started {
+		return
+	}
+	close(s.quit)
+	<-s.done
+}
+
+func (s *Scheduler) loop() {
+	defer close(s.done)
+	for {
+		wait := maxWait
+		s.mu.Lock()
+		for _, e := range s.schedules {
+			if e.Next != nil && !e.Paused && time.Until(*e.Next) < wait {
+				wait = time.Until(*e.Next)
+			}
+		}
+		s.mu.Unlock()
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+			s.runDue(time.Now())
+		case <-s.wake:
+			t.Stop()
+		case <-s.quit:
+			t.Stop()
+			return
+		}
+	}
+}
+
+// runDue runs the schedules with a run before now in the order of their run times
+func (s *Scheduler) runDue(now time.Time) {
+	type due struct {
+		Schedule
+		at time.Time
+	}
+	var l []due
+	s.mu.Lock()
+	for _, e := range s.schedules {
+		if e.Paused || e.Next == nil || e.Next.After(now) {
+			continue
+		}
+		l = append(l, due{e.Schedule, *e.Next})
+		e.advance(now)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(l, func(i, j int) bool { return l[i].at.Before(l[j].at) })
+	for _, d := range l {
+		s.runOne(d.Schedule, now)
+	}
+}
+
+// runOne runs the action and saves the result
+func (s *Scheduler) runOne(sc Schedule, now time.Time) {
+	err := s.run(sc)
+	if err != nil {
+		log.Printf("Schedule %v failed:%v", sc.ID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.schedules[sc.ID]
+	if !ok {
+		return
+	}
+	e.LastRun = &now
+	e.LastError = ""
+	if err != nil {
+		e.LastError = err.Error()
+	}
+	s.save()
+}
+
+// List returns the schedules, the oldest first
+func (s *Scheduler) List() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := []Schedule{}
+	for _, e := range s.sorted() {
+		l = append(l, e.Schedule)
+	}
+	return l
+}
+
+// Get returns the schedule with the id
+func (s *Scheduler) Get(id string) (Schedule, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.schedules[id]
+	if !ok {
+		return Schedule{}, false
+	}
+	return e.Schedule, true
+}
+
+// Add validates and saves a new schedule, the id and the run state are set by the scheduler
+func (s *Scheduler) Add(sc Schedule) (Schedule, error) {
+	b := make([]byte, 4)
+	rand.Read(b)
+	sc.ID = hex.EncodeToString(b)
+	sc.Created = time.Now()
+	sc.Next, sc.LastRun, sc.LastError, sc.Done = nil, nil, "", false
+
+	e, err := newEntry(sc, s.where)
+	if err != nil {
+		return Schedule{}, err
+	}
+	if e.At != "" && !e.at.After(sc.Created) {
+		return Schedule{}, fmt.Errorf("The date %v is in the past", e.at.Format(time.RFC3339))
+	}
+	if !e.Paused {
+		e.advance(sc.Created)
+		if e.Next == nil && e.Sun != "" {
+			return Schedule{}, fmt.Errorf("The sun doesn't reach the %v angle here for a year", e.Sun)
+		}
+		if e.Next == nil {
+			return Schedule{}, fmt.Errorf("The cron expression %q never runs", e.Cron)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules[e.ID] = e
+	s.save()
+	s.notify()
+	return e.Schedule, nil
+}
+
+// SetPaused pauses or resumes the schedule, a resumed schedule continues with its next run from now on
+func (s *Scheduler) SetPaused(id string, paused bool) (Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.schedules[id]
+	if !ok {
+		return Schedule{}, ErrUnknown
+	}
+	if e.Paused == paused {
+		return e.Schedule, nil
+	}
+	e.Paused = paused
+	if paused {
+		e.Next = nil
+	} else if !e.Done {
+		e.advance(time.Now())
+	}
+	s.save()
+	s.notify()
+	return e.Schedule, nil
+}
+
+// Delete removes the schedule
+func (s *Scheduler) Delete(id string) (Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.schedules[id]
+	if !ok {
+		return Schedule{}, ErrUnknown
+	}
+	delete(s.schedules, id)
+	s.save()
+	s.notify()
+	return e.Schedule, nil
+}
+
+// notify wakes the loop to pick up the changed schedules, the caller holds mu
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sorted returns the entries in the order they were added, the caller holds mu
+func (s *Scheduler) sorted() []*entry {
+	l := make([]*entry, 0, len(s.schedules))
+	for _, e := range s.schedules {
+		l = append(l, e)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Created.Before(l[j].Created) })
+	return l
+}
+
+func (e *entry) label() string {
+	if e.Name != "" {
+		return fmt.Sprintf("%v(%v)", e.ID, e.Name)
+	}
+	return e.ID
+}
+
+// save writes the schedules file, a failure is only logged so the schedules keep running, the caller holds mu
+func (s *Scheduler) save() {
+	if s.path == "" {
+		return
+	}
+	l := make([]Schedule, 0, len(s.schedules))
+	for _, e := range s.sorted() {
+		l = append(l, e.Schedule)
+	}
+	d, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		log.Printf("Couldn't encode the schedules:%v", err)
+		return
+	}
+	// write, sync and rename so a power loss leaves either the old or the new file
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err == nil {
+		_, err = f.Write(d)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		log.Printf("Couldn't save the schedules %v:%v", s.path, err)
+	}
+}
@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeZone(t *testing.T) {
+	sofia, err := time.LoadLocation("Europe/Sofia")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	// the local time zone of the Pi
+	defer func(l *time.Location) { time.Local = l }(time.Local)
+	time.Local = sofia
+
+	utc := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name string
+		s    Schedule
+		from string
+		// next is in UTC
+		next string
+	}{
+		{"at in the schedule time zone", Schedule{At: "2030-01-02T18:00", TimeZone: "America/New_York"}, "2029-12-01 00:00", "2030-01-02 23:00"},
+		{"at with summer time", Schedule{At: "2030-07-02 18:00", TimeZone: "America/New_York"}, "2029-12-01 00:00", "2030-07-02 22:00"},
+		{"at in the local time zone", Schedule{At: "2030-01-02T18:00"}, "2029-12-01 00:00", "2030-01-02 16:00"},
+		{"at with an offset ignores the time zone", Schedule{At: "2030-01-02T18:00:00+01:00", TimeZone: "America/New_York"}, "2029-12-01 00:00", "2030-01-02 17:00"},
+		{"cron in the schedule time zone", Schedule{Cron: "0 18 * * *", TimeZone: "America/New_York"}, "2018-01-10 12:00", "2018-01-10 23:00"},
+		{"cron days in the schedule time zone", Schedule{Cron: "0 18 * * sat", TimeZone: "America/New_York"}, "2018-01-13 22:59", "2018-01-13 23:00"},
+		{"cron in the local time zone", Schedule{Cron: "0 18 * * *"}, "2018-01-10 12:00", "2018-01-10 16:00"},
+		{"cron in the local summer time", Schedule{Cron: "0 18 * * *"}, "2018-07-10 12:00", "2018-07-10 15:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.s.Device = "door"
+			e, err := newEntry(tt.s, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := sofia
+			if tt.s.TimeZone != "" {
+				want = newYork
+			}
+			if e.loc.String() != want.String() {
+				t.Fatalf("the time zone is %v, expected %v", e.loc, want)
+			}
+			e.advance(utc(tt.from))
+			if e.Next == nil {
+				t.Fatal("no next run")
+			}
+			if !e.Next.Equal(utc(tt.next)) {
+				t.Fatalf("the next run is %v, expected %v UTC", e.Next.UTC(), tt.next)
+			}
+		})
+	}
+
+	if _, err := newEntry(Schedule{Device: "door", Cron: "@daily", TimeZone: "Europe/Nowhere"}, nil); err == nil {
+		t.Fatal("an unknown time zone didn't fail")
+	}
+}
+
+func TestAtDone(t *testing.T) {
+	e, err := newEntry(Schedule{Device: "door", At: "2018-01-02T18:00", TimeZone: "UTC"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.advance(time.Date(2018, 1, 2, 18, 0, 0, 0, time.UTC))
+	if e.Next != nil || !e.Done {
+		t.Fatalf("a one-off schedule at its run time has the next run %v and done %v", e.Next, e.Done)
+	}
+}
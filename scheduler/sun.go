@@ -0,0 +1,117 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Sun events of the sun schedules, dawn and dusk are when the sun center is 6°(civil) or 12°(nautical) below the horizon
+const (
+	Sunrise      = "sunrise"
+	Sunset       = "sunset"
+	CivilDawn    = "civil-dawn"
+	CivilDusk    = "civil-dusk"
+	NauticalDawn = "nautical-dawn"
+	NauticalDusk = "nautical-dusk"
+)
+
+// sunEvent is the zenith angle of the sun center at the event and if the sun is rising
+type sunEvent struct {
+	zenith float64
+	rising bool
+}
+
+// sunEvents use the zenith of 90°50' for sunrise and sunset to account for the refraction and the size of the sun disk
+var sunEvents = map[string]sunEvent{
+	Sunrise:      {90.833, true},
+	Sunset:       {90.833, false},
+	CivilDawn:    {96, true},
+	CivilDusk:    {96, false},
+	NauticalDawn: {102, true},
+	NauticalDusk: {102, false},
+}
+
+// Location is where the sun times are computed, the latitude is positive to the north and the longitude to the east
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// SunTime returns the UTC time of the sun event on the date, computed with the NOAA solar calculator equations.
+// It is accurate to about a minute between the polar circles.
+// ok is false when the sun doesn't reach the angle that day like during a polar day or night.
+func SunTime(event string, l Location, year int, month time.Month, day int) (t time.Time, ok bool, err error) {
+	e, found := sunEvents[event]
+	if !found {
+		return time.Time{}, false, fmt.Errorf("Invalid sun event:%v, use sunrise, sunset, civil-dawn, civil-dusk, nautical-dawn or nautical-dusk", event)
+	}
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	// start at the local solar noon and compute again with the sun position at the event for a better accuracy
+	t = midnight.Add(minutes(720 - 4*l.Longitude))
+	for i := 0; i < 2; i++ {
+		decl, eqTime := sunPosition(t)
+		cosHA := math.Cos(rad(e.zenith))/(math.Cos(rad(l.Latitude))*math.Cos(decl)) - math.Tan(rad(l.Latitude))*math.Tan(decl)
+		if cosHA < -1 || cosHA > 1 {
+			return time.Time{}, false, nil
+		}
+		ha := deg(math.Acos(cosHA))
+		if e.rising {
+			ha = -ha
+		}
+		t = midnight.Add(minutes(720 - 4*(l.Longitude-ha) - eqTime))
+	}
+	return t, true, nil
+}
+
+// sunPosition returns the declination of the sun in radians and the equation of time in minutes
+func sunPosition(t time.Time) (decl, eqTime float64) {
+	jd := float64(t.Unix())/86400 + 2440587.5
+	c := (jd - 2451545) / 36525
+
+	meanLong := math.Mod(280.46646+c*(36000.76983+c*0.0003032), 360)
+	meanAnomaly := 357.52911 + c*(35999.05029-0.0001537*c)
+	ecc := 0.016708634 - c*(0.000042037+0.0000001267*c)
+	m := rad(meanAnomaly)
+	center := math.Sin(m)*(1.914602-c*(0.004817+0.000014*c)) + math.Sin(2*m)*(0.019993-0.000101*c) + math.Sin(3*m)*0.000289
+	omega := rad(125.04 - 1934.136*c)
+	appLong := rad(meanLong + center - 0.00569 - 0.00478*math.Sin(omega))
+
+	meanObliq := 23 + (26+(21.448-c*(46.815+c*(0.00059-c*0.001813)))/60)/60
+	obliq := rad(meanObliq + 0.00256*math.Cos(omega))
+	decl = math.Asin(math.Sin(obliq) * math.Sin(appLong))
+
+	y := math.Pow(math.Tan(obliq/2), 2)
+	l0 := rad(meanLong)
+	eqTime = 4 * deg(y*math.Sin(2*l0)-2*ecc*math.Sin(m)+4*ecc*y*math.Sin(m)*math.Cos(2*l0)-0.5*y*y*math.Sin(4*l0)-1.25*ecc*ecc*math.Sin(2*m))
+	return decl, eqTime
+}
+
+// nextSun returns the first sun event with the offset after t, the days are the calendar days in the location of t.
+// The zero time is returned when the event doesn't happen for a year.
+func nextSun(event string, offset time.Duration, l Location, t time.Time) time.Time {
+	// start a day earlier as a long offset can move the run of the previous day after t
+	d := t.AddDate(0, 0, -1)
+	for i := 0; i < 368; i++ {
+		s, ok, _ := SunTime(event, l, d.Year(), d.Month(), d.Day())
+		if ok {
+			if r := s.Add(offset).Round(time.Minute).In(t.Location()); r.After(t) {
+				return r
+			}
+		}
+		d = d.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+func minutes(m float64) time.Duration {
+	return time.Duration(m * float64(time.Minute))
+}
+
+func rad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func deg(r float64) float64 {
+	return r * 180 / math.Pi
+}
@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+var burgas = Location{Latitude: 42.5048, Longitude: 27.4626}
+
+// TestSunTime checks the sun times of Burgas against the almanac rounded to the minute, in EET and EEST
+func TestSunTime(t *testing.T) {
+	sofia, err := time.LoadLocation("Europe/Sofia")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	tests := []struct {
+		date  string
+		event string
+		want  string
+	}{
+		{"2024-03-20", Sunrise, "06:13"},
+		{"2024-03-20", Sunset, "18:23"},
+		{"2024-03-20", CivilDawn, "05:45"},
+		{"2024-03-20", CivilDusk, "18:51"},
+		{"2024-06-21", Sunrise, "05:33"},
+		{"2024-06-21", Sunset, "20:51"},
+		{"2024-06-21", CivilDawn, "04:58"},
+		{"2024-06-21", CivilDusk, "21:26"},
+		{"2024-09-22", Sunrise, "06:58"},
+		{"2024-09-22", Sunset, "19:07"},
+		{"2024-09-22", CivilDawn, "06:30"},
+		{"2024-09-22", CivilDusk, "19:35"},
+		{"2024-12-21", Sunrise, "07:36"},
+		{"2024-12-21", Sunset, "16:40"},
+		{"2024-12-21", CivilDawn, "07:05"},
+		{"2024-12-21", CivilDusk, "17:12"},
+	}
+	for _, tt := range tests {
+		want, err := time.ParseInLocation("2006-01-02 15:04", tt.date+" "+tt.want, sofia)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok, err := SunTime(tt.event, burgas, want.Year(), want.Month(), want.Day())
+		if err != nil || !ok {
+			t.Errorf("%v %v:%v %v", tt.date, tt.event, ok, err)
+			continue
+		}
+		if d := got.Sub(want); d < -2*time.Minute || d > 2*time.Minute {
+			t.Errorf("%v %v is %v, the almanac has %v", tt.date, tt.event, got.In(sofia).Format("15:04:05 MST"), tt.want)
+		}
+	}
+}
+
+func TestSunTimePolar(t *testing.T) {
+	tromso := Location{Latitude: 69.6492, Longitude: 18.9553}
+	tests := []struct {
+		name  string
+		event string
+		month time.Month
+		day   int
+		ok    bool
+	}{
+		{"polar day sunrise", Sunrise, time.June, 21, false},
+		{"polar day sunset", Sunset, time.June, 21, false},
+		{"polar night sunrise", Sunrise, time.December, 21, false},
+		{"polar night sunset", Sunset, time.December, 21, false},
+		{"civil dawn during the polar night", CivilDawn, time.December, 21, true},
+		{"nautical dusk during the polar day", NauticalDusk, time.June, 21, false},
+		{"sunrise at the equinox", Sunrise, time.March, 20, true},
+	}
+	for _, tt := range tests {
+		got, ok, err := SunTime(tt.event, tromso, 2024, tt.month, tt.day)
+		if err != nil {
+			t.Fatalf("%v:%v", tt.name, err)
+		}
+		if ok != tt.ok {
+			t.Errorf("%v: ok is %v at %v", tt.name, ok, got)
+		}
+		if !ok && !got.IsZero() {
+			t.Errorf("%v: got %v without an event", tt.name, got)
+		}
+	}
+
+	if _, _, err := SunTime("noon", burgas, 2024, time.June, 21); err == nil {
+		t.Error("an unknown sun event didn't fail")
+	}
+}
+
+func TestNextSun(t *testing.T) {
+	sofia, err := time.LoadLocation("Europe/Sofia")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, sofia)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	// the sunset of the day and of the next day once it passed, rounded to the minute
+	if got := nextSun(Sunset, 0, burgas, at("2024-06-21 12:00")); !got.Equal(at("2024-06-21 20:51")) {
+		t.Errorf("the next sunset is %v", got)
+	}
+	if got := nextSun(Sunset, 0, burgas, at("2024-06-21 20:51")); got.Day() != 22 {
+		t.Errorf("the sunset after the sunset is %v", got)
+	}
+	if got := nextSun(Sunset, 0, burgas, at("2024-06-21 12:00")); got.Location() != sofia {
+		t.Errorf("the sunset is in %v, expected the location of the start time", got.Location())
+	}
+	// an offset can move the run of the previous day after the start time
+	if got := nextSun(Sunset, 4*time.Hour, burgas, at("2024-06-22 00:30")); !got.Equal(at("2024-06-22 00:51")) {
+		t.Errorf("the sunset +4h after midnight is %v", got)
+	}
+	if got := nextSun(Sunrise, -30*time.Minute, burgas, at("2024-12-21 00:00")); !got.Equal(at("2024-12-21 07:07")) {
+		t.Errorf("the sunrise -30m is %v", got)
+	}
+
+	// the sun rises again in the middle of january after the polar night
+	tromso := Location{Latitude: 69.6492, Longitude: 18.9553}
+	got := nextSun(Sunrise, 0, tromso, time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC))
+	if got.IsZero() || got.Year() != 2025 || got.Month() != time.January || got.Day() < 10 || got.Day() > 20 {
+		t.Errorf("the first sunrise after the polar night is %v", got)
+	}
+}
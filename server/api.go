@@ -0,0 +1,700 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krasi-georgiev/rpi-web-control/audit"
+	"github.com/krasi-georgiev/rpi-web-control/config"
+	"github.com/krasi-georgiev/rpi-web-control/rpiGpio"
+	"github.com/krasi-georgiev/rpi-web-control/scheduler"
+)
+
+// APIPrefix is where the json api v1 is served
+const APIPrefix = "/api/v1/"
+
+// Error codes of the api in addition to the rpiGpio codes
+const (
+	CodeUnauthorized     = "unauthorized"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeInvalidBody      = "invalid_body"
+	CodeUnknownDevice    = "unknown_device"
+	CodeRawPinsDisabled  = "raw_pins_disabled"
+	CodeForbidden        = "forbidden"
+	CodeInvalidQuery     = "invalid_query"
+	CodeAuditFailure     = "audit_failure"
+)
+
+// Pin is the state of a pin returned by the api
+type Pin struct {
+	Pin string `json:"pin"`
+	// Device is set for pins declared as a named device
+	Device string `json:"device,omitempty"`
+	// Exported is false for pins that weren't used since the start
+	Exported  bool              `json:"exported"`
+	Direction rpiGpio.Direction `json:"direction,omitempty"`
+	Value     int               `json:"value"`
+	// Deadline is set while a timer is pending for the pin
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// Numbers are the numbers of the pin in every numbering scheme, not set for gpio line names
+	Numbers *rpiGpio.BoardPin `json:"numbers,omitempty"`
+	// PWM is set while the pin is driven with PWM
+	PWM *rpiGpio.PWMState `json:"pwm,omitempty"`
+	// Job is the job started by the request
+	Job *rpiGpio.Job `json:"job,omitempty"`
+}
+
+// Device is a named device with its current state
+type Device struct {
+	config.Device
+	Delay string `json:"delay,omitempty"`
+	State Pin    `json:"state"`
+	// Allowed is true when the user can control the device
+	Allowed bool `json:"allowed"`
+}
+
+// Action is a request to change a pin, Type is one of the rpiGpio control types.
+// Pin can also be a device name, then the empty settings come from the device, for the non admins also the type, delay and sequence.
+type Action struct {
+	Pin   string
+	Type  string
+	Delay string
+	Level string
+	// Conflict is what happens when the pin already has a timer - restart, extend, reject or queue
+	Conflict string
+	// Duty and Frequency of the pwm and ramp types, a ramp takes the Delay to reach the duty cycle
+	Duty      string
+	Frequency string
+	// Sequence are the steps of the sequence type, the pins in it can be device names
+	Sequence string
+}
+
+// APIError is the body of all failed api requests
+type APIError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// API is the versioned json api
+type API struct {
+	config *Config
+	// schedules is nil until OpenSchedules
+	schedules *scheduler.Scheduler
+}
+
+// NewAPI creates the api that authenticates with the given config
+func NewAPI(c *Config) *API {
+	return &API{config: c}
+}
+
+// Run executes the action for the user and returns the pin state after it,
+// every attempt is recorded in the audit log
+func (a *API) Run(id *Identity, act Action) (p Pin, err error) {
+	var device string
+	defer func() { a.record(id, act, device, p.Job, err) }()
+
+	// only admins can change how a device is driven, the others get the type, delay and sequence of the config,
+	// switching it off stays a switch off so it doesn't start the timer of the device
+	if _, ok := a.config.Device(act.Pin); ok && (id == nil || !config.HasRole(id.Role, config.Admin)) {
+		if act.Type != "set" || act.Level != "0" {
+			act.Type = ""
+		}
+		act.Delay, act.Sequence = "", ""
+	}
+	var opts []func(*rpiGpio.Control) error
+	opts, device, err = a.options(&act)
+	if err != nil {
+		return Pin{}, err
+	}
+	if err := a.authorize(id, device); err != nil {
+		// the refusal is recorded with the gpio number like the actuations, whatever numbering the request used
+		if pin, err := rpiGpio.ResolvePin(act.Pin); err == nil {
+			act.Pin = pin
+		}
+		return Pin{}, err
+	}
+	c, err := rpiGpio.NewControl(opts...)
+	if err != nil {
+		return Pin{}, err
+	}
+	// phys:12, bcm:18 and 18 are one pin in the audit log and the metrics
+	act.Pin = c.Pin()
+	// a sequence can change other pins so the user needs to be allowed to control them too
+	for _, pin := range c.SequencePins() {
+		if err := a.authorize(id, a.deviceOf(pin)); err != nil {
+			return Pin{}, err
+		}
+	}
+	job, err := c.Run()
+	if err != nil {
+		return Pin{}, err
+	}
+	p, err = a.pin(c, device)
+	p.Job = &job
+	return p, err
+}
+
+// Jobs returns the pending, active and recently finished jobs
+func (a *API) Jobs() []rpiGpio.Job {
+	return rpiGpio.Jobs()
+}
+
+// Job returns the job with the id
+func (a *API) Job(jobID string) (rpiGpio.Job, error) {
+	j, ok := rpiGpio.GetJob(jobID)
+	if !ok {
+		return j, &rpiGpio.Error{Code: rpiGpio.CodeUnknownJob, Err: fmt.Errorf("No job with id %v", jobID)}
+	}
+	return j, nil
+}
+
+// CancelJob stops the job when the user can control its pin, the attempt is recorded in the audit log
+func (a *API) CancelJob(id *Identity, jobID string) (j rpiGpio.Job, err error) {
+	j, err = a.Job(jobID)
+	if err != nil {
+		return j, err
+	}
+	device := a.deviceOf(j.Pin)
+	defer func() { a.record(id, Action{Pin: j.Pin, Type: "cancel"}, device, &j, err) }()
+	if err := a.authorize(id, device); err != nil {
+		return j, err
+	}
+	return rpiGpio.CancelJob(jobID)
+}
+
+// deviceOf returns the name of the device connected to the pin
+func (a *API) deviceOf(pin string) string {
+	for _, d := range a.config.Devices() {
+		if d.Pin == pin {
+			return d.Name
+		}
+	}
+	return ""
+}
+
+// Get returns the current state of the pin or device
+func (a *API) Get(pin string) (Pin, error) {
+	opts, device, err := a.options(&Action{Pin: pin})
+	if err != nil {
+		return Pin{}, err
+	}
+	c, err := rpiGpio.NewControl(opts...)
+	if err != nil {
+		return Pin{}, err
+	}
+	return a.pin(c, device)
+}
+
+// Devices returns the named devices with their current state
+func (a *API) Devices(id *Identity) []Device {
+	devices := []Device{}
+	for _, d := range a.config.Devices() {
+		dev := Device{Device: d, Allowed: a.authorize(id, d.Name) == nil}
+		if d.Delay > 0 {
+			dev.Delay = d.Delay.String()
+		}
+		if p, err := a.Get(d.Name); err == nil {
+			dev.State = p
+		} else {
+			dev.State = Pin{Pin: d.Pin, Device: d.Name}
+		}
+		devices = append(devices, dev)
+	}
+	return devices
+}
+
+// options builds the control settings for the action, a device name is replaced with the device pin and settings
+func (a *API) options(act *Action) ([]func(*rpiGpio.Control) error, string, error) {
+	d, ok := a.config.Device(act.Pin)
+	if ok {
+		act.Pin = d.Pin
+		if act.Type == "" {
+			act.Type = d.ControlType()
+		}
+		if act.Delay == "" && d.Delay > 0 {
+			act.Delay = d.Delay.String()
+		}
+		if act.Conflict == "" {
+			act.Conflict = d.Conflict
+		}
+		if act.Sequence == "" && act.Type == "sequence" {
+			act.Sequence = d.Sequence
+		}
+	} else if !a.config.AllowRaw() {
+		if _, err := strconv.Atoi(act.Pin); err == nil || act.Pin == "" || strings.Contains(act.Pin, ":") {
+			return nil, "", &rpiGpio.Error{Code: CodeRawPinsDisabled, Err: fmt.Errorf("Only the configured devices can be controlled, pin %q isn't one of them", act.Pin)}
+		}
+		return nil, "", &rpiGpio.Error{Code: CodeUnknownDevice, Err: fmt.Errorf("No device named %v", act.Pin)}
+	}
+
+	opts := []func(*rpiGpio.Control) error{
+		rpiGpio.SetType(act.Type),
+		rpiGpio.SetPin(act.Pin),
+		rpiGpio.SetDelay(act.Delay),
+		rpiGpio.SetInitial(d.Initial),
+		rpiGpio.SetConflict(act.Conflict),
+	}
+	switch act.Type {
+	case "set":
+		opts = append(opts, rpiGpio.SetLevel(act.Level))
+	case "pwm", "ramp":
+		opts = append(opts, rpiGpio.SetDuty(act.Duty), rpiGpio.SetFrequency(act.Frequency))
+	case "sequence":
+		opts = append(opts, rpiGpio.SetSequence(act.Sequence, a.sequencePin))
+	}
+	return opts, d.Name, nil
+}
+
+// sequencePin creates the control of another pin or device named in a sequence with the device settings
+func (a *API) sequencePin(pin string) (*rpiGpio.Control, error) {
+	opts, _, err := a.options(&Action{Pin: pin, Type: "set", Level: "0"})
+	if err != nil {
+		return nil, err
+	}
+	return rpiGpio.NewControl(opts...)
+}
+
+// SafeState sets every device to its safe state, used at startup and on shutdown
+func (a *API) SafeState() {
+	for _, d := range a.config.Devices() {
+		if d.SafeState == config.SafeNone {
+			continue
+		}
+		// a timer restored from the journal decides the level until it expires
+		if rpiGpio.TimerPending(d.Pin) {
+			continue
+		}
+		act := Action{Pin: d.Name, Type: "set", Level: d.SafeState}
+		opts, _, err := a.options(&act)
+		if err == nil {
+			var c *rpiGpio.Control
+			if c, err = rpiGpio.NewControl(opts...); err == nil {
+				_, err = c.Run()
+			}
+		}
+		if err != nil {
+			log.Printf("Couldn't set device %v to its safe state %v:%v", d.Name, d.SafeState, err)
+			continue
+		}
+		log.Printf("Device %v set to its safe state %v", d.Name, d.SafeState)
+	}
+}
+
+// authorize checks if the user can control the device,
+// pins that aren't a named device are only for admins
+func (a *API) authorize(id *Identity, device string) error {
+	if id == nil {
+		return &rpiGpio.Error{Code: CodeForbidden, Err: errors.New("Not logged in")}
+	}
+	// a schedule runs without a role once the user that added it is removed
+	if !config.ValidRole(id.Role) {
+		return &rpiGpio.Error{Code: CodeForbidden, Err: fmt.Errorf("User %v doesn't exist anymore", id.Name)}
+	}
+	if device == "" {
+		if !config.HasRole(id.Role, config.Admin) {
+			return &rpiGpio.Error{Code: CodeForbidden, Err: fmt.Errorf("Only admins can control pins by number, %v is a %v", id.Name, id.Role)}
+		}
+		return nil
+	}
+	d, _ := a.config.Device(device)
+	if !d.Allowed(id.Name, id.Role) {
+		return &rpiGpio.Error{Code: CodeForbidden, Err: fmt.Errorf("Device %v needs the %v role, %v is a %v", d.Name, d.Role, id.Name, id.Role)}
+	}
+	return nil
+}
+
+// record adds the action to the audit log and the metrics
+func (a *API) record(id *Identity, act Action, device string, job *rpiGpio.Job, err error) {
+	e := audit.Entry{Device: device, Pin: act.Pin, Action: act.Type, Params: map[string]string{}, Result: audit.OK}
+	if job != nil {
+		e.Job = job.ID
+	}
+	if id != nil {
+		e.User, e.Auth, e.IP = id.Name, id.Method, id.IP
+	}
+	if e.Pin == "" {
+		e.Pin = rpiGpio.DefaultPin
+	}
+	if e.Action == "" {
+		e.Action = rpiGpio.DefaultType
+	}
+	if act.Delay != "" {
+		e.Params["delay"] = act.Delay
+	}
+	if act.Level != "" {
+		e.Params["level"] = act.Level
+	}
+	if act.Conflict != "" {
+		e.Params["conflict"] = act.Conflict
+	}
+	if act.Duty != "" {
+		e.Params["duty"] = act.Duty
+	}
+	if act.Frequency != "" {
+		e.Params["frequency"] = act.Frequency
+	}
+	if act.Sequence != "" {
+		e.Params["sequence"] = act.Sequence
+	}
+	if err != nil {
+		code := rpiGpio.ErrorCode(err)
+		e.Result = audit.Failed
+		if code == CodeForbidden || code == CodeRawPinsDisabled || code == rpiGpio.CodeLimit {
+			e.Result = audit.Denied
+		}
+		e.Error = err.Error()
+		actuationFailures.Inc(code)
+	} else {
+		actuations.Inc(e.Pin, e.Action)
+	}
+	a.config.Audit().Record(e)
+}
+
+// RecordSafety adds the pins switched off by their maximum on time and every change refused by a limit to the audit log,
+// the background jobs like a queued timer or a sequence included. A refused request is also recorded by Run.
+func (a *API) RecordSafety() {
+	rpiGpio.SetSafetyHook(a.recordSafety)
+}
+
+func (a *API) recordSafety(e rpiGpio.Event) {
+	entry := audit.Entry{
+		Time:   e.Time,
+		User:   "system",
+		Device: a.deviceOf(e.Pin),
+		Pin:    e.Pin,
+		Action: e.Type,
+		Params: map[string]string{"reason": e.Reason},
+		Result: audit.OK,
+	}
+	if e.Type == rpiGpio.EventLimit {
+		entry.Result = audit.Denied
+	}
+	a.config.Audit().Record(entry)
+}
+
+// auditLog answers the audit log queries, only admins can read it
+//
+//	GET /api/v1/audit?from=2017-08-01&to=2017-08-02T18:00&user=alice&device=front-door&limit=100&format=csv
+func (a *API) auditLog(w http.ResponseWriter, r *http.Request, id *Identity) {
+	if !config.HasRole(id.Role, config.Admin) {
+		writeError(w, http.StatusForbidden, CodeForbidden, fmt.Errorf("Only admins can read the audit log, %v is a %v", id.Name, id.Role))
+		return
+	}
+	if a.config.Audit() == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, fmt.Errorf("The audit log is disabled, start with --audit-log"))
+		return
+	}
+	q := r.URL.Query()
+	f := audit.Filter{User: q.Get("user"), Device: q.Get("device")}
+	var err error
+	if f.From, err = parseTime(q.Get("from")); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidQuery, err)
+		return
+	}
+	if f.To, err = parseTime(q.Get("to")); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidQuery, err)
+		return
+	}
+	if l := q.Get("limit"); l != "" {
+		if f.Limit, err = strconv.Atoi(l); err != nil || f.Limit < 0 {
+			writeError(w, http.StatusBadRequest, CodeInvalidQuery, fmt.Errorf("Invalid limit:%v", l))
+			return
+		}
+	}
+	entries, err := a.config.Audit().Query(f)
+	if err != nil {
+		log.Printf("Couldn't read the audit log:%v", err)
+		writeError(w, http.StatusInternalServerError, CodeAuditFailure, err)
+		return
+	}
+	switch q.Get("format") {
+	case "", "json":
+		writeJSON(w, http.StatusOK, entries)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		if err := audit.WriteCSV(w, entries); err != nil {
+			log.Printf("Couldn't write the audit log:%v", err)
+		}
+	default:
+		writeError(w, http.StatusBadRequest, CodeInvalidQuery, fmt.Errorf("Invalid format:%v, use json or csv", q.Get("format")))
+	}
+}
+
+// parseTime accepts RFC3339 times and the local times sent by the html date inputs
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid time:%v, use RFC3339 or 2006-01-02T15:04", s)
+}
+
+func (a *API) pin(c *rpiGpio.Control, device string) (Pin, error) {
+	v, exported, err := c.Level()
+	if err != nil {
+		return Pin{}, err
+	}
+	s, _ := rpiGpio.State(c.Pin())
+	p := Pin{Pin: c.Pin(), Device: device, Exported: exported, Direction: s.Direction, Value: v, Deadline: s.Deadline}
+	if n, ok := rpiGpio.PinNumbers(c.Pin()); ok {
+		p.Numbers = &n
+	}
+	if pwm, ok := rpiGpio.PWM(c.Pin()); ok {
+		p.PWM = &pwm
+	}
+	return p, nil
+}
+
+// ServeHTTP routes the api requests
+//
+//	GET  /api/v1/devices
+//	GET  /api/v1/pins
+//	GET  /api/v1/pins/{pin}
+//	PUT  /api/v1/pins/{pin}         {"level":1}
+//	POST /api/v1/pins/{pin}/pulse   {"delay":"2s"}
+//	POST /api/v1/pins/{pin}/toggle
+//	PUT  /api/v1/pins/{pin}/pwm     {"duty":50,"frequency":1000}
+//	POST /api/v1/pins/{pin}/ramp    {"duty":100,"duration":"5s"}
+//	POST /api/v1/pins/{pin}/sequence {"sequence":"(on 100ms off 100ms)x3"}
+//	GET  /api/v1/jobs
+//	GET  /api/v1/jobs/{id}
+//	POST /api/v1/jobs/{id}/cancel
+//	     /api/v1/schedules...        see serveSchedules
+//	GET  /api/v1/board
+//	GET  /api/v1/audit
+//	GET  /api/v1/openapi.json
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, APIPrefix), "/")
+	if path == "openapi.json" {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, openAPI)
+		return
+	}
+
+	id, err := a.config.Identify(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, err)
+		return
+	}
+	r = WithIdentity(r, id)
+
+	p := strings.Split(path, "/")
+	switch {
+	case len(p) == 1 && p[0] == "devices":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, a.Devices(id))
+	case len(p) == 1 && p[0] == "pins":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		a.listPins(w)
+	case len(p) == 1 && p[0] == "board":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, rpiGpio.CurrentBoard())
+	case len(p) == 1 && p[0] == "audit":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		a.auditLog(w, r, id)
+	case len(p) == 2 && p[0] == "pins":
+		switch r.Method {
+		case http.MethodGet:
+			pin, err := a.Get(p[1])
+			a.respond(w, pin, err)
+		case http.MethodPut:
+			var body struct {
+				Level *int `json:"level"`
+			}
+			if !decode(w, r, &body) {
+				return
+			}
+			if body.Level == nil {
+				writeError(w, http.StatusBadRequest, rpiGpio.CodeInvalidLevel, fmt.Errorf("The level is required"))
+				return
+			}
+			pin, err := a.Run(id, Action{Pin: p[1], Type: "set", Level: fmt.Sprint(*body.Level)})
+			a.respond(w, pin, err)
+		default:
+			allowMethods(w, r, http.MethodGet, http.MethodPut)
+		}
+	case len(p) == 3 && p[0] == "pins" && (p[2] == "pulse" || p[2] == "toggle"):
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		var body struct {
+			Delay    string `json:"delay"`
+			Conflict string `json:"conflict"`
+		}
+		if !decode(w, r, &body) {
+			return
+		}
+		act := Action{Pin: p[1], Type: "toggle"}
+		if p[2] == "pulse" {
+			act = Action{Pin: p[1], Type: "timer", Delay: body.Delay, Conflict: body.Conflict}
+		}
+		pin, err := a.Run(id, act)
+		a.respond(w, pin, err)
+	case len(p) == 3 && p[0] == "pins" && (p[2] == "pwm" || p[2] == "ramp"):
+		method := http.MethodPut
+		if p[2] == "ramp" {
+			method = http.MethodPost
+		}
+		if !allowMethods(w, r, method) {
+			return
+		}
+		var body struct {
+			Duty      *float64 `json:"duty"`
+			Frequency float64  `json:"frequency"`
+			Duration  string   `json:"duration"`
+		}
+		if !decode(w, r, &body) {
+			return
+		}
+		if body.Duty == nil {
+			writeError(w, http.StatusBadRequest, rpiGpio.CodeInvalidPWM, fmt.Errorf("The duty cycle is required"))
+			return
+		}
+		act := Action{Pin: p[1], Type: p[2], Duty: fmt.Sprint(*body.Duty)}
+		if body.Frequency != 0 {
+			act.Frequency = fmt.Sprint(body.Frequency)
+		}
+		if p[2] == "ramp" {
+			act.Delay = body.Duration
+		}
+		pin, err := a.Run(id, act)
+		a.respond(w, pin, err)
+	case len(p) == 3 && p[0] == "pins" && p[2] == "sequence":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		var body struct {
+			Sequence string `json:"sequence"`
+		}
+		if !decode(w, r, &body) {
+			return
+		}
+		pin, err := a.Run(id, Action{Pin: p[1], Type: "sequence", Sequence: body.Sequence})
+		a.respond(w, pin, err)
+	case len(p) == 1 && p[0] == "jobs":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, a.Jobs())
+	case len(p) == 2 && p[0] == "jobs":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		j, err := a.Job(p[1])
+		a.respond(w, j, err)
+	case len(p) == 3 && p[0] == "jobs" && p[2] == "cancel":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		j, err := a.CancelJob(id, p[1])
+		a.respond(w, j, err)
+	case p[0] == "schedules":
+		a.serveSchedules(w, r, id, p)
+	default:
+		writeError(w, http.StatusNotFound, CodeNotFound, fmt.Errorf("No such api resource:%v", r.URL.Path))
+	}
+}
+
+func (a *API) listPins(w http.ResponseWriter) {
+	pins := []Pin{}
+	for _, s := range rpiGpio.States() {
+		name := s.Pin
+		for _, d := range a.config.Devices() {
+			if d.Pin == s.Pin {
+				name = d.Name
+			}
+		}
+		pin, err := a.Get(name)
+		if err != nil {
+			pin = Pin{Pin: s.Pin, Direction: s.Direction, Value: s.Value, Deadline: s.Deadline}
+			if n, ok := rpiGpio.PinNumbers(s.Pin); ok {
+				pin.Numbers = &n
+			}
+		}
+		pins = append(pins, pin)
+	}
+	writeJSON(w, http.StatusOK, pins)
+}
+
+func (a *API) respond(w http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		code := rpiGpio.ErrorCode(err)
+		status := http.StatusBadRequest
+		switch code {
+		case rpiGpio.CodeGPIO:
+			log.Printf("Huston we have a problem : %v", err)
+			status = http.StatusInternalServerError
+		case CodeUnknownDevice, rpiGpio.CodeUnknownJob, CodeUnknownSchedule:
+			status = http.StatusNotFound
+		case rpiGpio.CodePinBusy, rpiGpio.CodeJobFinished, rpiGpio.CodeInterlock, rpiGpio.CodeLimit:
+			status = http.StatusConflict
+		case CodeRawPinsDisabled, CodeForbidden:
+			status = http.StatusForbidden
+		}
+		writeError(w, status, code, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// decode reads the optional json body of the request
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(v)
+	if err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, CodeInvalidBody, fmt.Errorf("Invalid json body:%v", err))
+		return false
+	}
+	return true
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, fmt.Errorf("Method %v is not allowed", r.Method))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Couldn't write the api response:%v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string, err error) {
+	e := APIError{}
+	e.Error.Code = code
+	e.Error.Message = err.Error()
+	writeJSON(w, status, e)
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krasi-georgiev/rpi-web-control/audit"
+	"github.com/krasi-georgiev/rpi-web-control/config"
+	"github.com/krasi-georgiev/rpi-web-control/metrics"
+	"github.com/krasi-georgiev/rpi-web-control/rpiGpio"
+)
+
+// newAPI creates an api on the simulator with the devices of the config and an audit log,
+// without devices any pin can be controlled
+func newAPI(t *testing.T, devices string) (*API, *audit.Log) {
+	t.Helper()
+	old := rpiGpio.DefaultBackend
+	rpiGpio.DefaultBackend = rpiGpio.NewSim()
+	t.Cleanup(func() { rpiGpio.DefaultBackend = old })
+
+	dir := t.TempDir()
+	l, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	c := &Config{audit: l, allowRaw: devices == ""}
+	if devices != "" {
+		path := filepath.Join(dir, "config.toml")
+		if err := ioutil.WriteFile(path, []byte(devices), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if c.devices, err = config.Load(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewAPI(c), l
+}
+
+func TestRecordResolvedPin(t *testing.T) {
+	a, l := newAPI(t, "")
+	admin := &Identity{Name: "alice", Role: config.Admin}
+	before := actuationCount(t, "19", "set")
+	for _, pin := range []string{"phys:35", "bcm:19", "19", "wpi:24"} {
+		if _, err := a.Run(admin, Action{Pin: pin, Type: "set", Level: "1"}); err != nil {
+			t.Fatal(pin, err)
+		}
+	}
+	// a refused request is recorded with the gpio number too
+	if _, err := a.Run(&Identity{Name: "bob", Role: config.Guest}, Action{Pin: "phys:35", Type: "set", Level: "0"}); rpiGpio.ErrorCode(err) != CodeForbidden {
+		t.Fatalf("a guest controlled a raw pin:%v", err)
+	}
+
+	entries, err := l.Query(audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("%v audit entries, expected 5", len(entries))
+	}
+	for _, e := range entries {
+		if e.Pin != "19" {
+			t.Fatalf("the audit entry of %v has the pin %v", e.User, e.Pin)
+		}
+	}
+
+	// the metrics are shared by the tests so the actuations are counted from before the requests
+	if n := actuationCount(t, "19", "set") - before; n != 4 {
+		t.Fatalf("%v actuations counted for the gpio number, expected 4", n)
+	}
+	if strings.Contains(scrape(), `pin="phys:`) || strings.Contains(scrape(), `pin="wpi:`) || strings.Contains(scrape(), `pin="bcm:`) {
+		t.Fatal("an actuation was counted by the pin of the request")
+	}
+}
+
+// scrape returns the current metrics
+func scrape() string {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+// actuationCount is the value of the rpi_actuations_total series of the pin and type
+func actuationCount(t *testing.T, pin, typ string) float64 {
+	t.Helper()
+	series := fmt.Sprintf(`rpi_actuations_total{pin=%q,type=%q} `, pin, typ)
+	for _, line := range strings.Split(scrape(), "\n") {
+		if strings.HasPrefix(line, series) {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(line, series), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+const authDevices = `
+[[device]]
+name = "door"
+pin = 4
+delay = "50ms"
+
+[[device]]
+name = "garage"
+pin = 5
+role = "admin"
+
+[[device]]
+name = "heater"
+pin = 6
+type = "toggle"
+role = "host"
+users = ["dave"]
+
+[[device]]
+name = "open-close"
+pin = 7
+type = "sequence"
+sequence = "on 10ms off"
+`
+
+func TestAuthorize(t *testing.T) {
+	a, _ := newAPI(t, authDevices)
+	a.config.allowRaw = true
+	for _, tt := range []struct {
+		name string
+		id   *Identity
+		act  Action
+		code string
+	}{
+		{"not logged in", nil, Action{Pin: "door"}, CodeForbidden},
+		{"removed user", &Identity{Name: "eve", Role: ""}, Action{Pin: "door"}, CodeForbidden},
+		{"guest", &Identity{Name: "bob", Role: config.Guest}, Action{Pin: "door"}, ""},
+		{"guest admin device", &Identity{Name: "bob", Role: config.Guest}, Action{Pin: "garage"}, CodeForbidden},
+		{"guest host device", &Identity{Name: "bob", Role: config.Guest}, Action{Pin: "heater"}, CodeForbidden},
+		{"guest allowed by name", &Identity{Name: "dave", Role: config.Guest}, Action{Pin: "heater"}, ""},
+		{"host", &Identity{Name: "carol", Role: config.Host}, Action{Pin: "heater"}, ""},
+		{"host admin device", &Identity{Name: "carol", Role: config.Host}, Action{Pin: "garage"}, CodeForbidden},
+		{"host raw pin", &Identity{Name: "carol", Role: config.Host}, Action{Pin: "16", Type: "set", Level: "1"}, CodeForbidden},
+		{"host raw pin of a device", &Identity{Name: "carol", Role: config.Host}, Action{Pin: "phys:7", Type: "set", Level: "1"}, CodeForbidden},
+		{"admin", &Identity{Name: "alice", Role: config.Admin}, Action{Pin: "garage"}, ""},
+		{"admin raw pin", &Identity{Name: "alice", Role: config.Admin}, Action{Pin: "16", Type: "set", Level: "0"}, ""},
+		{"admin sequence", &Identity{Name: "alice", Role: config.Admin}, Action{Pin: "door", Type: "sequence", Sequence: "garage:on 10ms garage:off"}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.Run(tt.id, tt.act)
+			if rpiGpio.ErrorCode(err) != tt.code && !(tt.code == "" && err == nil) {
+				t.Fatalf("%v, expected %q", err, tt.code)
+			}
+		})
+	}
+	// a sequence can't reach the devices the user isn't allowed to control
+	a.config.devices.Devices[3].Sequence = "garage:on 10ms garage:off"
+	if _, err := a.Run(&Identity{Name: "bob", Role: config.Guest}, Action{Pin: "open-close"}); rpiGpio.ErrorCode(err) != CodeForbidden {
+		t.Fatalf("the sequence of a guest switched the garage:%v", err)
+	}
+}
+
+func TestDeviceOverrides(t *testing.T) {
+	a, l := newAPI(t, authDevices)
+	guest := &Identity{Name: "bob", Role: config.Guest}
+
+	// the type and the delay of the request are ignored for a guest
+	p, err := a.Run(guest, Action{Pin: "door", Type: "toggle", Delay: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Job.Type != "timer" || p.Job.Deadline == nil || time.Until(*p.Job.Deadline) > time.Second {
+		t.Fatalf("the guest changed how the door is driven:%+v", p.Job)
+	}
+	// the sequence of the request is ignored for a guest
+	if p, err = a.Run(guest, Action{Pin: "open-close", Type: "sequence", Sequence: "(on 1h)"}); err != nil {
+		t.Fatal(err)
+	}
+	if p.Job.Steps != 2 {
+		t.Fatalf("the guest ran another sequence with %v steps", p.Job.Steps)
+	}
+	// a switch off stays a switch off
+	if p, err = a.Run(guest, Action{Pin: "door", Type: "set", Level: "0"}); err != nil {
+		t.Fatal(err)
+	}
+	if p.Job.Type != "set" || p.Value != 0 {
+		t.Fatalf("switching the door off ran %v and left it at %v", p.Job.Type, p.Value)
+	}
+	if p, err = a.Run(guest, Action{Pin: "door", Type: "set", Level: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if p.Job.Type != "timer" {
+		t.Fatalf("the guest switched the door on with %v", p.Job.Type)
+	}
+
+	// an admin can override the settings of the device
+	if p, err = a.Run(&Identity{Name: "alice", Role: config.Admin}, Action{Pin: "door", Type: "timer", Delay: "1h"}); err != nil {
+		t.Fatal(err)
+	}
+	if p.Job.Deadline == nil || time.Until(*p.Job.Deadline) < 59*time.Minute {
+		t.Fatalf("the admin couldn't change the delay:%+v", p.Job)
+	}
+	rpiGpio.CancelJob(p.Job.ID)
+
+	// the audit log has what was run
+	entries, err := l.Query(audit.Filter{User: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := entries[len(entries)-1]; e.Action != "timer" || e.Params["delay"] != "50ms" {
+		t.Fatalf("the audit log has %v with %v for the ignored override", e.Action, e.Params)
+	}
+}
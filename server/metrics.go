@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/krasi-georgiev/rpi-web-control/metrics"
+	"github.com/krasi-georgiev/rpi-web-control/rpiGpio"
+)
+
+var (
+	actuations = metrics.NewCounter("rpi_actuations_total",
+		"Successful pin actuations by pin and control type", "pin", "type")
+	actuationFailures = metrics.NewCounter("rpi_actuation_failures_total",
+		"Failed or denied actuations by error code", "code")
+	authFailures = metrics.NewCounter("rpi_auth_failures_total",
+		"Requests with wrong credentials by authentication method", "method")
+	requestDuration = metrics.NewHistogram("rpi_http_request_duration_seconds",
+		"Latency of the http requests by handler", metrics.DefBuckets, "handler")
+
+	_ = metrics.NewGaugeFunc("rpi_output_level", "Current level of the output pins", func() []metrics.Sample {
+		var s []metrics.Sample
+		for _, p := range rpiGpio.States() {
+			if p.Direction == rpiGpio.Out {
+				s = append(s, metrics.Sample{Labels: []string{p.Pin}, Value: float64(p.Value)})
+			}
+		}
+		return s
+	}, "pin")
+	_ = metrics.NewGaugeFunc("rpi_pending_timers", "Timers waiting to switch a pin off", func() []metrics.Sample {
+		var n int
+		for _, p := range rpiGpio.States() {
+			if p.Deadline != nil {
+				n++
+			}
+		}
+		return []metrics.Sample{{Value: float64(n)}}
+	})
+)
+
+// Instrument records the latency of the handler, not for the long lived event streams
+func Instrument(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		h.ServeHTTP(w, r)
+		requestDuration.Observe(time.Since(start).Seconds(), name)
+	})
+}
@@ -0,0 +1,455 @@
+package server
+
+// openAPI describes the api v1, it is served at /api/v1/openapi.json
+const openAPI = `{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "Raspberry Pi GPIO web controller",
+    "version": "1"
+  },
+  "servers": [{"url": "/api/v1"}],
+  "security": [{"session": []}, {"basic": []}, {"password": []}],
+  "paths": {
+    "/devices": {
+      "get": {
+        "summary": "The named devices from the config file with their state",
+        "responses": {
+          "200": {"description": "Devices", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Device"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pins": {
+      "get": {
+        "summary": "State of all pins used since the start",
+        "responses": {
+          "200": {"description": "Pin states", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Pin"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pins/{pin}": {
+      "parameters": [{"$ref": "#/components/parameters/pin"}],
+      "get": {
+        "summary": "Current state of a pin",
+        "responses": {
+          "200": {"$ref": "#/components/responses/Pin"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "summary": "Set the level of a pin",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["level"],
+            "properties": {"level": {"type": "integer", "enum": [0, 1]}}
+          }}}
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Pin"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pins/{pin}/pulse": {
+      "parameters": [{"$ref": "#/components/parameters/pin"}],
+      "post": {
+        "summary": "Set the pin to 1 and back to 0 after the delay",
+        "requestBody": {
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "properties": {
+              "delay": {"type": "string", "example": "2s", "description": "Go duration, the default is 2s"},
+              "conflict": {"type": "string", "enum": ["restart", "extend", "reject", "queue"], "description": "what happens when the pin already has a timer, the default is restart"}
+            }
+          }}}
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Pin"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pins/{pin}/toggle": {
+      "parameters": [{"$ref": "#/components/parameters/pin"}],
+      "post": {
+        "summary": "Toggle the pin between 0 and 1",
+        "responses": {
+          "200": {"$ref": "#/components/responses/Pin"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pins/{pin}/pwm": {
+      "parameters": [{"$ref": "#/components/parameters/pin"}],
+      "put": {
+        "summary": "Drive the pin with PWM, the pins of the --pwm-pins use the hardware PWM when the kernel has it and the other pins a software PWM",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["duty"],
+            "properties": {
+              "duty": {"type": "number", "minimum": 0, "maximum": 100, "description": "percent of the period the pin is on"},
+              "frequency": {"type": "number", "example": 1000, "description": "Hz, the default keeps the current frequency or is 1000 for the hardware and 100 for the software PWM which goes up to 500"}
+            }
+          }}}
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Pin"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pins/{pin}/ramp": {
+      "parameters": [{"$ref": "#/components/parameters/pin"}],
+      "post": {
+        "summary": "Change the PWM duty cycle gradually as a job, a running ramp of the pin is cancelled",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["duty"],
+            "properties": {
+              "duty": {"type": "number", "minimum": 0, "maximum": 100, "description": "the duty cycle at the end of the ramp"},
+              "duration": {"type": "string", "example": "5s", "description": "Go duration, the default is the device delay or 2s"},
+              "frequency": {"type": "number", "example": 1000}
+            }
+          }}}
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Pin"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pins/{pin}/sequence": {
+      "parameters": [{"$ref": "#/components/parameters/pin"}],
+      "post": {
+        "summary": "Run a sequence of levels and waits as a job, a running sequence of the pin is cancelled and the pins of the sequence are switched off at its end",
+        "requestBody": {
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "properties": {
+              "sequence": {"type": "string", "example": "(on 100ms off 100ms)x3 1s 23:on 500ms", "description": "levels(on, off, 1, 0, toggle) optionally with another pin or device like 23:on and followed by how long to keep them, waits like 1s and repeated groups like (...)x3, the default is the sequence of the device"}
+            }
+          }}}
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Pin"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/jobs": {
+      "get": {
+        "summary": "The pending, active and recently finished jobs, the newest first",
+        "responses": {
+          "200": {"description": "Jobs", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Job"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/jobs/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/job"}],
+      "get": {
+        "summary": "A job",
+        "responses": {
+          "200": {"$ref": "#/components/responses/Job"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/jobs/{id}/cancel": {
+      "parameters": [{"$ref": "#/components/parameters/job"}],
+      "post": {
+        "summary": "Cancel a pending or active job, an active timer switches its pin off",
+        "responses": {
+          "200": {"$ref": "#/components/responses/Job"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/board": {
+      "get": {
+        "summary": "The detected board model with its usable gpio pins",
+        "responses": {
+          "200": {"description": "Board", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Board"}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/audit": {
+      "get": {
+        "summary": "Query the audit log, only for admins",
+        "parameters": [
+          {"name": "from", "in": "query", "schema": {"type": "string", "example": "2017-08-01T00:00"}, "description": "RFC3339 or local time"},
+          {"name": "to", "in": "query", "schema": {"type": "string", "example": "2017-08-02"}, "description": "RFC3339 or local time"},
+          {"name": "user", "in": "query", "schema": {"type": "string"}},
+          {"name": "device", "in": "query", "schema": {"type": "string"}, "description": "device name or pin"},
+          {"name": "limit", "in": "query", "schema": {"type": "integer"}, "description": "only the newest entries"},
+          {"name": "format", "in": "query", "schema": {"type": "string", "enum": ["json", "csv"]}}
+        ],
+        "responses": {
+          "200": {"description": "Entries, the newest first", "content": {
+            "application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/AuditEntry"}}},
+            "text/csv": {"schema": {"type": "string"}}
+          }},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/schedules": {
+      "get": {
+        "summary": "All schedules",
+        "responses": {
+          "200": {"description": "Schedules, the oldest first", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Schedule"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Add a schedule for a device the user can control, it runs with the permissions of the user",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Schedule"}}}
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Schedule"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/schedules/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/schedule"}],
+      "get": {
+        "summary": "A schedule",
+        "responses": {
+          "200": {"$ref": "#/components/responses/Schedule"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a schedule",
+        "responses": {
+          "200": {"$ref": "#/components/responses/Schedule"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/schedules/{id}/pause": {
+      "parameters": [{"$ref": "#/components/parameters/schedule"}],
+      "post": {
+        "summary": "Pause a schedule",
+        "responses": {
+          "200": {"$ref": "#/components/responses/Schedule"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/schedules/{id}/resume": {
+      "parameters": [{"$ref": "#/components/parameters/schedule"}],
+      "post": {
+        "summary": "Resume a paused schedule from its next run",
+        "responses": {
+          "200": {"$ref": "#/components/responses/Schedule"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "session": {"type": "apiKey", "in": "cookie", "name": "rpi_session", "description": "the session cookie set by /login"},
+      "basic": {"type": "http", "scheme": "basic", "description": "the user name and password from the users file"},
+      "password": {"type": "http", "scheme": "bearer", "description": "the shared server password with admin access, also accepted as the pass query parameter"}
+    },
+    "parameters": {
+      "pin": {"name": "pin", "in": "path", "required": true, "description": "device name, gpio number or a pin like bcm:18, phys:12 or wpi:1", "schema": {"type": "string", "example": "18"}},
+      "job": {"name": "id", "in": "path", "required": true, "description": "job id", "schema": {"type": "string", "example": "1f3a9c2e"}},
+      "schedule": {"name": "id", "in": "path", "required": true, "description": "schedule id", "schema": {"type": "string", "example": "7b2d04aa"}}
+    },
+    "schemas": {
+      "Device": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "label": {"type": "string"},
+          "icon": {"type": "string"},
+          "pin": {"type": "string"},
+          "type": {"type": "string", "enum": ["pulse", "toggle", "sequence"]},
+          "sequence": {"type": "string"},
+          "delay": {"type": "string"},
+          "active_low": {"type": "boolean"},
+          "role": {"type": "string", "enum": ["guest", "host", "admin"], "description": "the lowest role allowed to control the device"},
+          "allowed": {"type": "boolean", "description": "if the user can control the device"},
+          "state": {"$ref": "#/components/schemas/Pin"}
+        }
+      },
+      "Pin": {
+        "type": "object",
+        "properties": {
+          "pin": {"type": "string"},
+          "device": {"type": "string"},
+          "exported": {"type": "boolean"},
+          "direction": {"type": "string", "enum": ["in", "out"]},
+          "value": {"type": "integer", "enum": [0, 1]},
+          "deadline": {"type": "string", "format": "date-time", "description": "when the pending timer expires"},
+          "numbers": {"$ref": "#/components/schemas/BoardPin"},
+          "pwm": {"$ref": "#/components/schemas/PWM"},
+          "job": {"$ref": "#/components/schemas/Job"}
+        }
+      },
+      "Job": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "pin": {"type": "string"},
+          "type": {"type": "string", "enum": ["timer", "toggle", "set", "pwm", "ramp", "sequence"]},
+          "state": {"type": "string", "enum": ["pending", "active", "done", "failed", "cancelled"]},
+          "created": {"type": "string", "format": "date-time"},
+          "started": {"type": "string", "format": "date-time"},
+          "deadline": {"type": "string", "format": "date-time"},
+          "ended": {"type": "string", "format": "date-time"},
+          "error": {"type": "string"},
+          "step": {"type": "integer", "description": "the running step of a sequence"},
+          "steps": {"type": "integer", "description": "the number of steps of a sequence with the repeats expanded"}
+        }
+      },
+      "Board": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string", "example": "3b", "description": "the name used with --board"},
+          "model": {"type": "string", "example": "Raspberry Pi 3 Model B Rev 1.2"},
+          "header": {"type": "string", "enum": ["26-rev1", "26-rev2", "40", "none"]},
+          "pins": {"type": "array", "items": {"$ref": "#/components/schemas/BoardPin"}}
+        }
+      },
+      "BoardPin": {
+        "type": "object",
+        "description": "the numbers of a gpio pin in every numbering scheme, not set for gpio line names",
+        "properties": {
+          "gpio": {"type": "integer", "description": "the bcm number"},
+          "phys": {"type": "integer", "description": "position on the gpio header"},
+          "wpi": {"type": "integer", "description": "the wiringPi number"},
+          "function": {"type": "string", "enum": ["i2c", "spi", "uart", "pwm"], "description": "what the pin is used for when the function is enabled"}
+        }
+      },
+      "PWM": {
+        "type": "object",
+        "description": "set while the pin is driven with PWM",
+        "properties": {
+          "duty": {"type": "number"},
+          "frequency": {"type": "number"},
+          "hardware": {"type": "boolean", "description": "false for the software PWM"}
+        }
+      },
+      "AuditEntry": {
+        "type": "object",
+        "properties": {
+          "time": {"type": "string", "format": "date-time"},
+          "user": {"type": "string"},
+          "auth": {"type": "string", "enum": ["session", "basic", "password", "schedule", "socket"]},
+          "ip": {"type": "string"},
+          "device": {"type": "string"},
+          "pin": {"type": "string"},
+          "action": {"type": "string"},
+          "params": {"type": "object", "additionalProperties": {"type": "string"}},
+          "result": {"type": "string", "enum": ["ok", "denied", "failed"]},
+          "error": {"type": "string"},
+          "job": {"type": "string"}
+        }
+      },
+      "Schedule": {
+        "type": "object",
+        "required": ["device"],
+        "properties": {
+          "id": {"type": "string", "readOnly": true},
+          "name": {"type": "string"},
+          "device": {"type": "string", "description": "device name or pin number"},
+          "action": {"type": "string", "enum": ["on", "off", "toggle", "pulse"], "description": "empty uses the control type of the device"},
+          "delay": {"type": "string", "example": "2s", "description": "how long a pulse keeps the pin on"},
+          "cron": {"type": "string", "example": "0 18 * * mon-fri", "description": "minute hour day-of-month month day-of-week or @hourly, @daily, @weekly, @monthly, @yearly"},
+          "at": {"type": "string", "example": "2017-12-24T18:00", "description": "a single run instead of the cron expression"},
+          "sun": {"type": "string", "enum": ["sunrise", "sunset", "civil-dawn", "civil-dusk", "nautical-dawn", "nautical-dusk"], "description": "a daily run at the sun event instead of the cron expression, needs the location in the config file"},
+          "offset": {"type": "string", "example": "30m", "description": "moves the sun event, negative is before it"},
+          "timezone": {"type": "string", "example": "Europe/Sofia", "description": "time zone of cron and at and the days of sun, the default is the local time zone"},
+          "missed": {"type": "string", "enum": ["skip", "run-once"], "description": "runs missed while the controller wasn't running are skipped or run once at startup, the default is skip"},
+          "paused": {"type": "boolean"},
+          "owner": {"type": "string", "readOnly": true},
+          "created": {"type": "string", "format": "date-time", "readOnly": true},
+          "next": {"type": "string", "format": "date-time", "readOnly": true},
+          "last_run": {"type": "string", "format": "date-time", "readOnly": true},
+          "last_error": {"type": "string", "readOnly": true},
+          "done": {"type": "boolean", "readOnly": true, "description": "a one-off schedule ran or was missed"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": {
+            "type": "object",
+            "properties": {
+              "code": {"type": "string", "enum": ["unauthorized", "not_found", "method_not_allowed", "invalid_body", "unknown_device", "raw_pins_disabled", "forbidden", "invalid_query", "audit_failure", "pin_busy", "invalid_conflict", "unknown_job", "job_finished", "invalid_schedule", "unknown_schedule", "invalid_type", "invalid_pin", "invalid_delay", "invalid_level", "invalid_pwm", "invalid_sequence", "interlock", "limit", "gpio_failure"]},
+              "message": {"type": "string"}
+            }
+          }
+        }
+      }
+    },
+    "responses": {
+      "Pin": {"description": "The pin state after the request", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pin"}}}},
+      "Job": {"description": "The job", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}},
+      "Schedule": {"description": "The schedule", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Schedule"}}}},
+      "Error": {"description": "The request failed", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    }
+  }
+}
+`
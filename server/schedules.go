@@ -0,0 +1,202 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/krasi-georgiev/rpi-web-control/audit"
+	"github.com/krasi-georgiev/rpi-web-control/rpiGpio"
+	"github.com/krasi-georgiev/rpi-web-control/scheduler"
+)
+
+// Error codes of the schedules api
+const (
+	CodeInvalidSchedule = "invalid_schedule"
+	CodeUnknownSchedule = "unknown_schedule"
+)
+
+// OpenSchedules loads the schedules file and starts running them, an empty path keeps the schedules only in memory.
+// It is called after the devices are set to their safe state so a catch-up run isn't undone by it.
+func (a *API) OpenSchedules(path string) error {
+	var where *scheduler.Location
+	if l := a.config.Location(); l != nil {
+		where = &scheduler.Location{Latitude: l.Latitude, Longitude: l.Longitude}
+	}
+	s, err := scheduler.Open(path, where, a.runSchedule)
+	if err != nil {
+		return err
+	}
+	a.schedules = s
+	s.Start()
+	return nil
+}
+
+// StopSchedules stops running the schedules, used before exiting
+func (a *API) StopSchedules() {
+	if a.schedules != nil {
+		a.schedules.Stop()
+	}
+}
+
+// runSchedule runs the action of the schedule with the permissions of the user that added it
+func (a *API) runSchedule(s scheduler.Schedule) error {
+	id := a.config.scheduleIdentity(s.Owner)
+	act := Action{Pin: s.Device, Delay: s.Delay}
+	switch s.Action {
+	case scheduler.On:
+		act.Type, act.Level = "set", "1"
+	case scheduler.Off:
+		act.Type, act.Level = "set", "0"
+	case scheduler.Toggle:
+		act.Type = "toggle"
+	case scheduler.Pulse:
+		act.Type = "timer"
+	}
+	_, err := a.Run(id, act)
+	return err
+}
+
+// Schedules returns all schedules
+func (a *API) Schedules() []scheduler.Schedule {
+	if a.schedules == nil {
+		return []scheduler.Schedule{}
+	}
+	return a.schedules.List()
+}
+
+// Schedule returns the schedule with the id
+func (a *API) Schedule(scheduleID string) (scheduler.Schedule, error) {
+	if a.schedules != nil {
+		if s, ok := a.schedules.Get(scheduleID); ok {
+			return s, nil
+		}
+	}
+	return scheduler.Schedule{}, &rpiGpio.Error{Code: CodeUnknownSchedule, Err: fmt.Errorf("No schedule with id %v", scheduleID)}
+}
+
+// AddSchedule adds a schedule for a device the user can control, the attempt is recorded in the audit log
+func (a *API) AddSchedule(id *Identity, s scheduler.Schedule) (_ scheduler.Schedule, err error) {
+	var device string
+	defer func() { a.recordSchedule(id, "schedule-add", s, device, err) }()
+
+	if _, device, err = a.options(&Action{Pin: s.Device}); err != nil {
+		return s, err
+	}
+	if err := a.authorize(id, device); err != nil {
+		return s, err
+	}
+	if a.schedules == nil {
+		return s, &rpiGpio.Error{Code: CodeInvalidSchedule, Err: fmt.Errorf("The scheduler isn't running")}
+	}
+	s.Owner = id.Name
+	added, err := a.schedules.Add(s)
+	if err != nil {
+		return s, &rpiGpio.Error{Code: CodeInvalidSchedule, Err: err}
+	}
+	s = added
+	return s, nil
+}
+
+// PauseSchedule pauses or resumes the schedule when the user can control its device
+func (a *API) PauseSchedule(id *Identity, scheduleID string, paused bool) (scheduler.Schedule, error) {
+	action := "schedule-resume"
+	if paused {
+		action = "schedule-pause"
+	}
+	return a.changeSchedule(id, scheduleID, action, func() (scheduler.Schedule, error) {
+		return a.schedules.SetPaused(scheduleID, paused)
+	})
+}
+
+// DeleteSchedule removes the schedule when the user can control its device
+func (a *API) DeleteSchedule(id *Identity, scheduleID string) (scheduler.Schedule, error) {
+	return a.changeSchedule(id, scheduleID, "schedule-delete", func() (scheduler.Schedule, error) {
+		return a.schedules.Delete(scheduleID)
+	})
+}
+
+// changeSchedule authorizes and records a change of an existing schedule
+func (a *API) changeSchedule(id *Identity, scheduleID, action string, change func() (scheduler.Schedule, error)) (s scheduler.Schedule, err error) {
+	if s, err = a.Schedule(scheduleID); err != nil {
+		return s, err
+	}
+	_, device, _ := a.options(&Action{Pin: s.Device})
+	defer func() { a.recordSchedule(id, action, s, device, err) }()
+	if err := a.authorize(id, device); err != nil {
+		return s, err
+	}
+	if s, err = change(); err == scheduler.ErrUnknown {
+		return s, &rpiGpio.Error{Code: CodeUnknownSchedule, Err: fmt.Errorf("No schedule with id %v", scheduleID)}
+	}
+	return s, err
+}
+
+// recordSchedule adds a change of the schedules to the audit log
+func (a *API) recordSchedule(id *Identity, action string, s scheduler.Schedule, device string, err error) {
+	e := audit.Entry{Device: device, Pin: s.Device, Action: action, Params: map[string]string{}, Result: audit.OK}
+	if d, ok := a.config.Device(s.Device); ok {
+		e.Pin = d.Pin
+	}
+	if id != nil {
+		e.User, e.Auth, e.IP = id.Name, id.Method, id.IP
+	}
+	for k, v := range map[string]string{"schedule": s.ID, "cron": s.Cron, "at": s.At, "sun": s.Sun, "offset": s.Offset, "timezone": s.TimeZone, "action": s.Action} {
+		if v != "" {
+			e.Params[k] = v
+		}
+	}
+	if err != nil {
+		e.Result = audit.Failed
+		if code := rpiGpio.ErrorCode(err); code == CodeForbidden || code == CodeRawPinsDisabled {
+			e.Result = audit.Denied
+		}
+		e.Error = err.Error()
+	}
+	a.config.Audit().Record(e)
+}
+
+// serveSchedules routes the schedule requests, p is the api path split at the slashes
+//
+//	GET    /api/v1/schedules
+//	POST   /api/v1/schedules              {"device":"lab-sign","action":"on","cron":"0 18 * * mon-fri"}
+//	GET    /api/v1/schedules/{id}
+//	DELETE /api/v1/schedules/{id}
+//	POST   /api/v1/schedules/{id}/pause
+//	POST   /api/v1/schedules/{id}/resume
+func (a *API) serveSchedules(w http.ResponseWriter, r *http.Request, id *Identity, p []string) {
+	switch {
+	case len(p) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, a.Schedules())
+		case http.MethodPost:
+			var s scheduler.Schedule
+			if !decode(w, r, &s) {
+				return
+			}
+			s, err := a.AddSchedule(id, s)
+			a.respond(w, s, err)
+		default:
+			allowMethods(w, r, http.MethodGet, http.MethodPost)
+		}
+	case len(p) == 2:
+		switch r.Method {
+		case http.MethodGet:
+			s, err := a.Schedule(p[1])
+			a.respond(w, s, err)
+		case http.MethodDelete:
+			s, err := a.DeleteSchedule(id, p[1])
+			a.respond(w, s, err)
+		default:
+			allowMethods(w, r, http.MethodGet, http.MethodDelete)
+		}
+	case len(p) == 3 && (p[2] == "pause" || p[2] == "resume"):
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		s, err := a.PauseSchedule(id, p[1], p[2] == "pause")
+		a.respond(w, s, err)
+	default:
+		writeError(w, http.StatusNotFound, CodeNotFound, fmt.Errorf("No such api resource:%v", r.URL.Path))
+	}
+}
@@ -3,8 +3,15 @@ package server
 import (
 	"errors"
 	"fmt"
+	"log"
+	"net"
+	"net/http"
 	"net/url"
+	"strings"
 
+	"github.com/krasi-georgiev/rpi-web-control/audit"
+	"github.com/krasi-georgiev/rpi-web-control/config"
+	"github.com/krasi-georgiev/rpi-web-control/rpiGpio"
 	"github.com/urfave/cli"
 )
 
@@ -17,6 +24,17 @@ func NewConfig() *Config {
 type Config struct {
 	Port string
 	pass string
+	// devices are the named devices from the config file, nil when there is no config file
+	devices *config.Config
+	// allowRaw allows controlling pins that aren't declared as devices
+	allowRaw bool
+	// users and sessions are nil when the users file isn't set and only the shared password is used
+	users    *Users
+	sessions *Sessions
+	// audit is nil when the audit log is disabled
+	audit *audit.Log
+	// trusted are the reverse proxies allowed to set X-Forwarded-For
+	trusted []*net.IPNet
 }
 
 // SetPort is the port setter
@@ -30,19 +48,246 @@ func (c *Config) SetPort(cli *cli.Context) error {
 
 }
 
-// SetPass is the port setter
+// SetPass is the shared password setter, it is optional when there is a users file
 func (c *Config) SetPass(cli *cli.Context) error {
 	if cli.String("password") == "" {
-		return errors.New("Password can't be empty")
+		if c.users != nil {
+			return nil
+		}
+		return errors.New("Password can't be empty, set a password or a users file")
+	}
+	if c.users != nil {
+		log.Print("The shared password is still accepted besides the users, remove it once everybody has own credentials")
 	}
 	c.pass = cli.String("password")
 	return nil
 }
 
-// Authenticate is for protected pages
+// SetUsers loads the users file that gives every lab member own credentials
+func (c *Config) SetUsers(cli *cli.Context) error {
+	if cli.String("users") == "" {
+		return nil
+	}
+	u, err := LoadUsers(cli.String("users"))
+	if err != nil {
+		return err
+	}
+	ttl := cli.Duration("session-ttl")
+	if ttl <= 0 {
+		return errors.New("Invalid session expiry:" + ttl.String())
+	}
+	c.users = u
+	c.sessions = NewSessions(ttl)
+	return nil
+}
+
+// UsesSessions reports if the users log in with own credentials
+func (c *Config) UsesSessions() bool {
+	return c.users != nil
+}
+
+// SetDevices loads the named devices from the config file,
+// without a config file any pin can be controlled
+func (c *Config) SetDevices(cli *cli.Context) error {
+	c.allowRaw = cli.Bool("allow-raw-pins")
+	if cli.String("config") == "" {
+		c.allowRaw = true
+		return nil
+	}
+	d, err := config.Load(cli.String("config"))
+	if err != nil {
+		return err
+	}
+	c.devices = d
+	for _, dev := range d.Devices {
+		if err := rpiGpio.SetLimits(dev.Pin, dev.Limits()); err != nil {
+			return err
+		}
+		if err := rpiGpio.SetPinActiveLow(dev.Pin, dev.ActiveLow); err != nil {
+			return err
+		}
+	}
+	var interlocks []rpiGpio.Interlock
+	for _, l := range d.Interlocks {
+		interlocks = append(interlocks, d.Interlock(l))
+	}
+	return rpiGpio.SetInterlocks(interlocks)
+}
+
+// SetAudit opens the audit log and sets the reverse proxies trusted to report the client address
+func (c *Config) SetAudit(cli *cli.Context) error {
+	for _, p := range cli.StringSlice("trusted-proxy") {
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return errors.New("Invalid trusted proxy:" + p + ", use an ip or a cidr like 10.0.0.0/8")
+		}
+		c.trusted = append(c.trusted, n)
+	}
+	if cli.String("audit-log") == "" {
+		log.Print("Audit log disabled, set it with --audit-log")
+		return nil
+	}
+	l, err := audit.Open(cli.String("audit-log"))
+	if err != nil {
+		return err
+	}
+	c.audit = l
+	return nil
+}
+
+// Audit returns the audit log, nil when it is disabled
+func (c *Config) Audit() *audit.Log {
+	return c.audit
+}
+
+// ClientIP is the address of the client, behind a trusted reverse proxy
+// it is the last address in X-Forwarded-For that isn't a trusted proxy
+func (c *Config) ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !c.isTrusted(ip) {
+		return ip
+	}
+	var hops []string
+	for _, h := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !c.isTrusted(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (c *Config) isTrusted(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range c.trusted {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Devices returns the named devices from the config file
+func (c *Config) Devices() []config.Device {
+	if c.devices == nil {
+		return nil
+	}
+	return c.devices.Devices
+}
+
+// Device finds a named device
+func (c *Config) Device(name string) (config.Device, bool) {
+	if c.devices == nil {
+		return config.Device{}, false
+	}
+	return c.devices.Device(name)
+}
+
+// Location is where the controller is from the config file, nil when it isn't set
+func (c *Config) Location() *config.Location {
+	if c.devices == nil {
+		return nil
+	}
+	return c.devices.Location
+}
+
+// AllowRaw reports if pins that aren't declared as devices can be controlled
+func (c *Config) AllowRaw() bool {
+	return c.allowRaw
+}
+
+// Authenticate checks the shared password from the pass query parameter
 func (c *Config) Authenticate(url url.Values) error {
-	if d, ok := url["pass"]; ok && c.pass == d[0] {
+	if d, ok := url["pass"]; ok && c.pass != "" && c.pass == d[0] {
 		return nil
 	}
 	return errors.New("No accesso amiho")
 }
+
+// Identify authenticates the request and returns who made it. In order it accepts
+// the session cookie, the user credentials with HTTP basic authentication and
+// the shared password as a bearer token or as the pass query parameter.
+// The shared password keeps the full access it always had.
+func (c *Config) Identify(r *http.Request) (*Identity, error) {
+	// the requests of the admin socket come with their identity
+	if id := IdentityFrom(r); id != nil && id.Method == MethodSocket {
+		return id, nil
+	}
+	id, err := c.identify(r)
+	if err != nil {
+		// requests without any credentials like the first visit of the home page aren't failures
+		if _, _, ok := r.BasicAuth(); ok {
+			authFailures.Inc(MethodBasic)
+		} else if r.Header.Get("Authorization") != "" || r.URL.Query().Get("pass") != "" {
+			authFailures.Inc(MethodPassword)
+		}
+		return nil, err
+	}
+	id.IP = c.ClientIP(r)
+	return id, nil
+}
+
+func (c *Config) identify(r *http.Request) (*Identity, error) {
+	if c.users != nil {
+		if cookie, err := r.Cookie(SessionCookie); err == nil {
+			if name, ok := c.sessions.Get(cookie.Value); ok {
+				// revoked users lose their sessions as well
+				if user, ok := c.users.Get(name); ok {
+					return &Identity{Name: name, Role: user.Role, Method: MethodSession}, nil
+				}
+				c.sessions.Delete(cookie.Value)
+			}
+		}
+		if name, password, ok := r.BasicAuth(); ok {
+			user, err := c.users.Check(name, password)
+			if err != nil {
+				return nil, err
+			}
+			return &Identity{Name: name, Role: user.Role, Method: MethodBasic}, nil
+		}
+	}
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		if c.pass != "" && strings.TrimPrefix(h, "Bearer ") == c.pass {
+			return &Identity{Name: MethodPassword, Role: config.Admin, Method: MethodPassword}, nil
+		}
+		return nil, errors.New("No accesso amiho")
+	}
+	if err := c.Authenticate(r.URL.Query()); err != nil {
+		return nil, err
+	}
+	return &Identity{Name: MethodPassword, Role: config.Admin, Method: MethodPassword}, nil
+}
+
+// scheduleIdentity is who a schedule added by the user runs as, it has no role when the user was removed
+func (c *Config) scheduleIdentity(name string) *Identity {
+	id := &Identity{Name: name, Method: MethodSchedule}
+	switch {
+	case name == MethodPassword && c.pass != "":
+		id.Role = config.Admin
+	case c.users != nil:
+		if user, ok := c.users.Get(name); ok {
+			id.Role = user.Role
+		}
+	}
+	return id
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SessionCookie is the name of the cookie with the session token
+const SessionCookie = "rpi_session"
+
+// DefaultSessionTTL is how long a login is valid
+const DefaultSessionTTL = 7 * 24 * time.Hour
+
+// Authentication methods of an Identity
+const (
+	MethodSession  = "session"
+	MethodBasic    = "basic"
+	MethodPassword = "password"
+	// MethodSchedule is an action run by a schedule for the user that added it
+	MethodSchedule = "schedule"
+	// MethodSocket is a request on the admin unix socket, allowed by the socket file permissions
+	MethodSocket = "socket"
+)
+
+// Identity is who made a request
+type Identity struct {
+	Name string
+	// Role is guest, host or admin
+	Role string
+	// Method is how the request was authenticated
+	Method string
+	// IP is the client address
+	IP string
+}
+
+type identityKey struct{}
+
+// WithIdentity returns the request with the identity attached to its context
+func WithIdentity(r *http.Request, id *Identity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+}
+
+// IdentityFrom returns the identity attached by WithIdentity
+func IdentityFrom(r *http.Request) *Identity {
+	id, _ := r.Context().Value(identityKey{}).(*Identity)
+	return id
+}
+
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// Sessions keeps the logged in users in memory, a restart logs everybody out
+type Sessions struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+// NewSessions creates a session store with the given session expiry
+func NewSessions(ttl time.Duration) *Sessions {
+	return &Sessions{ttl: ttl, sessions: make(map[string]session)}
+}
+
+// Create starts a session for the user and returns its token
+func (s *Sessions) Create(user string) (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	expires := time.Now().Add(s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// drop the expired sessions so the map doesn't grow forever
+	for t, ss := range s.sessions {
+		if time.Now().After(ss.expires) {
+			delete(s.sessions, t)
+		}
+	}
+	s.sessions[token] = session{user: user, expires: expires}
+	return token, expires, nil
+}
+
+// Get returns the user of a valid session
+func (s *Sessions) Get(token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.sessions[token]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(ss.expires) {
+		delete(s.sessions, token)
+		return "", false
+	}
+	return ss.user, true
+}
+
+// Delete ends the session
+func (s *Sessions) Delete(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+// Login shows the login form and starts a session for correct credentials
+func (c *Config) Login(w http.ResponseWriter, r *http.Request) {
+	if c.users == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		loginPage(w, http.StatusOK, "")
+		return
+	}
+
+	user, err := c.users.Check(r.PostFormValue("user"), r.PostFormValue("password"))
+	if err != nil {
+		log.Printf("Failed login for user %q from %v", r.PostFormValue("user"), c.ClientIP(r))
+		authFailures.Inc(MethodSession)
+		loginPage(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	token, expires, err := c.sessions.Create(user.Name)
+	if err != nil {
+		loginPage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// strict so that links from other sites can't trigger the GET /control endpoint
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Logout ends the session of the request
+func (c *Config) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(SessionCookie); err == nil && c.sessions != nil {
+		c.sessions.Delete(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func loginPage(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `
+		<html lang='en'>
+		<head>
+				<meta name='viewport' content='width=device-width, initial-scale=1, maximum-scale=1'>
+				<title>RPi Web controller - login</title>
+				<style>
+				form {
+					width: 80%%;
+					margin: 0 auto;
+					max-width: 400px;
+					}
+				body {font-size: 20px;font-family: Arial;}
+				input {padding: 10px;font-size: 14px;width:100%%; margin:10px 0px}
+				input[type=submit] {
+						cursor: pointer;
+						color: #fff;
+						border: 0px;
+						padding: 5px 10px;
+						background-color:#5c9fcd;
+						font-size: 30px;
+				}
+				#result {
+						font-weight:bold;
+						text-align:center;
+				}
+				</style>
+		</head>
+		<body>
+		<form method="post" action="/login">
+		<fieldset>
+			<legend>Login</legend>
+			<input type="text" name="user" placeholder="user" autocomplete="username" autofocus />
+			<input type="password" name="password" placeholder="password" autocomplete="current-password" />
+			<input type="submit" value="Login">
+		</fieldset>
+		</form>
+		<div id="result">%v</div>
+		</body>
+		</html>
+		`, html.EscapeString(msg))
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/krasi-georgiev/rpi-web-control/rpiGpio"
+)
+
+// keepAlive is how often an idle stream sends something so proxies don't close it
+const keepAlive = 30 * time.Second
+
+// EventState is the type of the events that send the current pin states when a stream starts
+const EventState = "state"
+
+// streams is closed on shutdown to end all open streams
+var streams = make(chan struct{})
+
+// CloseStreams ends all open event streams so the http server can shut down
+func CloseStreams() {
+	close(streams)
+}
+
+// snapshot converts the current pin states the user can see to events so new clients start with the actual levels
+func (a *API) snapshot(id *Identity) []rpiGpio.Event {
+	var s []rpiGpio.Event
+	for _, p := range rpiGpio.States() {
+		if a.visible(id, p.Pin) {
+			s = append(s, rpiGpio.Event{Type: EventState, Pin: p.Pin, Value: p.Value, Time: p.Time, Deadline: p.Deadline, Duty: p.Duty})
+		}
+	}
+	return s
+}
+
+// visible reports if the user gets the events of the pin, only those of the devices the user can control
+func (a *API) visible(id *Identity, pin string) bool {
+	return a.authorize(id, a.deviceOf(pin)) == nil
+}
+
+// Events streams the state changes of the pins the user can control as Server-Sent Events
+func (a *API) Events(w http.ResponseWriter, r *http.Request) {
+	id := IdentityFrom(r)
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := rpiGpio.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	send := func(e rpiGpio.Event) error {
+		d, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %v\ndata: %s\n\n", e.Type, d); err != nil {
+			return err
+		}
+		f.Flush()
+		return nil
+	}
+	for _, e := range a.snapshot(id) {
+		if err := send(e); err != nil {
+			return
+		}
+	}
+	f.Flush()
+
+	ping := time.NewTicker(keepAlive)
+	defer ping.Stop()
+	done := r.Context().Done()
+	for {
+		select {
+		case <-done:
+			return
+		case <-streams:
+			return
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			f.Flush()
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if !a.visible(id, e.Pin) {
+				continue
+			}
+			if err := send(e); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// websocketGUID is from RFC 6455 and used to build the handshake accept key
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocket frame opcodes
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// WebSocket streams the state changes of the pins the user can control as json text messages over a websocket
+func (a *API) WebSocket(w http.ResponseWriter, r *http.Request) {
+	id := IdentityFrom(r)
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a websocket upgrade request", http.StatusBadRequest)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported websocket version", http.StatusBadRequest)
+		return
+	}
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Websockets are not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		log.Printf("Websocket hijack error:%v", err)
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	events, unsubscribe := rpiGpio.Subscribe()
+	defer unsubscribe()
+
+	// the reader answers pings and notices when the client goes away,
+	// done stops it when the handler returns first so it doesn't block on a frame nobody reads
+	frames := make(chan frame)
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(closed)
+		for {
+			f, err := readFrame(rw.Reader)
+			if err != nil {
+				return
+			}
+			select {
+			case frames <- f:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	send := func(e rpiGpio.Event) error {
+		d, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return writeFrame(rw.Writer, wsText, d)
+	}
+	for _, e := range a.snapshot(id) {
+		if err := send(e); err != nil {
+			return
+		}
+	}
+
+	ping := time.NewTicker(keepAlive)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-streams:
+			writeFrame(rw.Writer, wsClose, []byte{0x03, 0xE9}) // 1001 going away
+			return
+		case f := <-frames:
+			switch f.opcode {
+			case wsClose:
+				writeFrame(rw.Writer, wsClose, f.payload)
+				return
+			case wsPing:
+				if err := writeFrame(rw.Writer, wsPong, f.payload); err != nil {
+					return
+				}
+			}
+		case <-ping.C:
+			if err := writeFrame(rw.Writer, wsPing, nil); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if !a.visible(id, e.Pin) {
+				continue
+			}
+			if err := send(e); err != nil {
+				return
+			}
+		}
+	}
+}
+
+type frame struct {
+	opcode  byte
+	payload []byte
+}
+
+// maxFrame limits the messages accepted from clients, they are only expected to send control frames
+const maxFrame = 4096
+
+func readFrame(r *bufio.Reader) (frame, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{opcode: h[0] & 0x0F}
+	masked := h[1]&0x80 != 0
+	n := uint64(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return frame{}, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return frame{}, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > maxFrame {
+		return frame{}, errors.New("Websocket frame too big")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return frame{}, err
+		}
+	}
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err
+	}
+	if masked {
+		for i := range f.payload {
+			f.payload[i] ^= mask[i%4]
+		}
+	}
+	return f, nil
+}
+
+func writeFrame(w *bufio.Writer, opcode byte, payload []byte) error {
+	w.WriteByte(0x80 | opcode)
+	switch n := len(payload); {
+	case n < 126:
+		w.WriteByte(byte(n))
+	case n <= 0xFFFF:
+		w.WriteByte(126)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(127)
+		binary.Write(w, binary.BigEndian, uint64(n))
+	}
+	w.Write(payload)
+	return w.Flush()
+}
+
+func headerContains(h http.Header, name, value string) bool {
+	for _, v := range strings.Split(h.Get(name), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
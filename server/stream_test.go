@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krasi-georgiev/rpi-web-control/config"
+	"github.com/krasi-georgiev/rpi-web-control/rpiGpio"
+)
+
+const streamDevices = `
+[[device]]
+name = "door"
+pin = 22
+
+[[device]]
+name = "garage"
+pin = 23
+role = "admin"
+`
+
+// streamAs serves the handler to the user like the authenticated handlers in main
+func streamAs(t *testing.T, id *Identity, h http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(w, WithIdentity(r, id))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestWebSocketDevices(t *testing.T) {
+	a, _ := newAPI(t, streamDevices)
+	admin := &Identity{Name: "alice", Role: config.Admin}
+	for _, d := range []string{"door", "garage"} {
+		if _, err := a.Run(admin, Action{Pin: d, Type: "set", Level: "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := streamAs(t, &Identity{Name: "bob", Role: config.Guest}, a.WebSocket)
+	conn, err := net.Dial("tcp", strings.TrimPrefix(s.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /ws HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake:%v %v", resp.Status, resp.Header)
+	}
+
+	// the garage needs the admin role so the guest doesn't get its events
+	for _, d := range []string{"garage", "door"} {
+		if _, err := a.Run(admin, Action{Pin: d, Type: "set", Level: "0"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var got []string
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			t.Fatalf("%v after the events %v", err, got)
+		}
+		if f.opcode != wsText {
+			continue
+		}
+		var e rpiGpio.Event
+		if err := json.Unmarshal(f.payload, &e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e.Type+":"+e.Pin)
+		if e.Type == rpiGpio.EventOutput {
+			break
+		}
+	}
+	if strings.Join(got, " ") != "state:22 output:22" {
+		t.Fatalf("the guest got the events %v", got)
+	}
+}
+
+func TestEventsDevices(t *testing.T) {
+	a, _ := newAPI(t, streamDevices)
+	a.config.allowRaw = true
+	admin := &Identity{Name: "alice", Role: config.Admin}
+	s := streamAs(t, &Identity{Name: "carol", Role: config.Host}, a.Events)
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	for _, act := range []Action{{Pin: "19", Type: "set", Level: "1"}, {Pin: "garage", Type: "set", Level: "1"}, {Pin: "door", Type: "set", Level: "1"}} {
+		if _, err := a.Run(admin, act); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		if !strings.HasPrefix(lines.Text(), "data: ") {
+			continue
+		}
+		var e rpiGpio.Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines.Text(), "data: ")), &e); err != nil {
+			t.Fatal(err)
+		}
+		// the raw pin is only for admins and the garage needs the admin role
+		if e.Pin != "22" {
+			t.Fatalf("the host got the event %v of pin %v", e.Type, e.Pin)
+		}
+		if e.Type == rpiGpio.EventOutput {
+			return
+		}
+	}
+	t.Fatal("the stream ended before the event of the door:", lines.Err())
+}
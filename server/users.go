@@ -0,0 +1,252 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krasi-georgiev/rpi-web-control/config"
+)
+
+// password hashing settings, changing them doesn't invalidate the existing hashes
+// because every hash records its own settings
+const (
+	hashIterations = 20000
+	hashSaltSize   = 16
+	hashKeySize    = 32
+	hashScheme     = "pbkdf2-sha256"
+)
+
+var userName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.@-]*$`)
+
+// User is a lab member with own credentials
+type User struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+	// Role is guest, host or admin
+	Role string `json:"role"`
+}
+
+// Users is the user store kept in a json file.
+// The file is reloaded when it changes so users added or revoked with the users command apply without a restart.
+type Users struct {
+	path string
+
+	mu      sync.Mutex
+	users   map[string]User
+	modTime time.Time
+}
+
+// LoadUsers opens the user store, the file is created on the first change
+func LoadUsers(path string) (*Users, error) {
+	u := &Users{path: path, users: make(map[string]User)}
+	if err := u.reload(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// reload reads the file when it changed since the last read
+func (u *Users) reload() error {
+	st, err := os.Stat(u.path)
+	if os.IsNotExist(err) {
+		u.users = make(map[string]User)
+		u.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if st.ModTime().Equal(u.modTime) {
+		return nil
+	}
+	d, err := ioutil.ReadFile(u.path)
+	if err != nil {
+		return err
+	}
+	var list []User
+	if err := json.Unmarshal(d, &list); err != nil {
+		return fmt.Errorf("Invalid users file %v:%v", u.path, err)
+	}
+	users := make(map[string]User)
+	for _, user := range list {
+		// users without a valid role get the least privileges
+		if !config.ValidRole(user.Role) {
+			user.Role = config.Guest
+		}
+		users[user.Name] = user
+	}
+	u.users = users
+	u.modTime = st.ModTime()
+	return nil
+}
+
+func (u *Users) save() error {
+	list := make([]User, 0, len(u.users))
+	for _, user := range u.users {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	d, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write and rename so the running daemon never reads a half written file
+	tmp := u.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, d, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, u.path); err != nil {
+		return err
+	}
+	st, err := os.Stat(u.path)
+	if err != nil {
+		return err
+	}
+	u.modTime = st.ModTime()
+	return nil
+}
+
+// Set adds a user or changes the password and the role of an existing one
+func (u *Users) Set(name, password, role string) error {
+	if !userName.MatchString(name) {
+		return errors.New("Invalid user name:" + name)
+	}
+	if !config.ValidRole(role) {
+		return errors.New("Invalid role:" + role + ", use guest, host or admin")
+	}
+	if len(password) < 8 {
+		return errors.New("The password should be at least 8 characters")
+	}
+	h, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.reload(); err != nil {
+		return err
+	}
+	user := u.users[name]
+	user.Name = name
+	user.Hash = h
+	user.Role = role
+	u.users[name] = user
+	return u.save()
+}
+
+// Remove revokes the user, the open sessions of the user stop working too
+func (u *Users) Remove(name string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.reload(); err != nil {
+		return err
+	}
+	if _, ok := u.users[name]; !ok {
+		return errors.New("No such user:" + name)
+	}
+	delete(u.users, name)
+	return u.save()
+}
+
+// List returns all users sorted by name
+func (u *Users) List() ([]User, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.reload(); err != nil {
+		return nil, err
+	}
+	list := make([]User, 0, len(u.users))
+	for _, user := range u.users {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Get returns the user if it still exists
+func (u *Users) Get(name string) (User, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.reload(); err != nil {
+		return User{}, false
+	}
+	user, ok := u.users[name]
+	return user, ok
+}
+
+// Check verifies the user credentials
+func (u *Users) Check(name, password string) (User, error) {
+	user, ok := u.Get(name)
+	if !ok || !checkPassword(user.Hash, password) {
+		return User{}, errors.New("Wrong user name or password")
+	}
+	return user, nil
+}
+
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, hashSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2([]byte(password), salt, hashIterations, hashKeySize)
+	return fmt.Sprintf("%v$%v$%v$%v", hashScheme, hashIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func checkPassword(hash, password string) bool {
+	p := strings.Split(hash, "$")
+	if len(p) != 4 || p[0] != hashScheme {
+		return false
+	}
+	iter, err := strconv.Atoi(p[1])
+	if err != nil || iter < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(p[2])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(p[3])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, pbkdf2([]byte(password), salt, iter, len(key))) == 1
+}
+
+// pbkdf2 derives a key with HMAC-SHA256 as described in RFC 8018
+func pbkdf2(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
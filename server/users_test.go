@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// The vectors are the PBKDF2-HMAC-SHA256 ones from RFC 7914 section 11
+// and the widely published extension of the RFC 6070 vectors to SHA256.
+func TestPBKDF2(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iter           int
+		key            string
+	}{
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+		{"pass\x00word", "sa\x00lt", 4096, "89b69d0516f829893c696226650a8687"},
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		want, err := hex.DecodeString(tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := pbkdf2([]byte(tt.password), []byte(tt.salt), tt.iter, len(want))
+		if hex.EncodeToString(got) != tt.key {
+			t.Errorf("pbkdf2(%q, %q, %v, %v)\ngot  %x\nwant %v", tt.password, tt.salt, tt.iter, len(want), got, tt.key)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	// a stored hash of "correct horse" with the salt 00 01 ... 0f and 1000 iterations,
+	// it has to keep working after any change to the hashing code
+	const stored = "pbkdf2-sha256$1000$AAECAwQFBgcICQoLDA0ODw$yRTMTwbMbo9G0VfjobWqerzuuxe7BETNTErBbKKumGQ"
+	if !checkPassword(stored, "correct horse") {
+		t.Fatal("the stored hash doesn't match its password")
+	}
+	for _, h := range []string{
+		stored,
+		strings.Replace(stored, "$1000$", "$999$", 1),
+		strings.Replace(stored, "pbkdf2-sha256", "pbkdf2-sha1", 1),
+		strings.Replace(stored, "$1000$", "$0$", 1),
+		"pbkdf2-sha256$1000$not base64!$yRTMTwbMbo9G0VfjobWqerzuuxe7BETNTErBbKKumGQ",
+		"",
+	} {
+		if checkPassword(h, "wrong horse") {
+			t.Errorf("%q matched a wrong password", h)
+		}
+	}
+
+	hash, err := hashPassword("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, hashScheme+"$20000$") {
+		t.Fatalf("unexpected hash format:%v", hash)
+	}
+	if !checkPassword(hash, "s3cret") {
+		t.Fatal("a new hash doesn't match its password")
+	}
+	if checkPassword(hash, "s3cret ") {
+		t.Fatal("a new hash matched a wrong password")
+	}
+	other, err := hashPassword("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash {
+		t.Fatal("two hashes of the same password use the same salt")
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/krasi-georgiev/rpi-web-control/config"
+	"github.com/krasi-georgiev/rpi-web-control/server"
+
+	"github.com/coreos/go-systemd/activation"
+	"github.com/urfave/cli"
+)
+
+// Names of the sockets passed by systemd, set with FileDescriptorName= when each socket has its own unit
+const (
+	socketHTTP    = "http"
+	socketMetrics = "metrics"
+	socketAdmin   = "admin"
+)
+
+// unitName is the name of the generated systemd units
+const unitName = "rpi-web-control"
+
+// passwordEnv is the environment variable of the password, the units keep it in an EnvironmentFile
+const passwordEnv = "RPI_WEB_CONTROL_PASSWORD"
+
+// activatedListeners returns the sockets passed by systemd with LISTEN_FDS by their name.
+// The sockets of a single .socket unit share the unit name, then the unix socket is the admin one
+// and the tcp sockets are the http and the metrics ones in the order of the ListenStream= lines.
+func activatedListeners() (map[string]net.Listener, error) {
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return namedListeners(activation.Files(true), names)
+}
+
+// namedListeners names the sockets passed by systemd, names are the FileDescriptorName= of each socket
+func namedListeners(files []*os.File, names []string) (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+	for i, f := range files {
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Socket %v passed by systemd isn't a stream socket:%v", i+3, err)
+		}
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		switch name {
+		case socketHTTP, socketMetrics, socketAdmin:
+		default:
+			switch {
+			case l.Addr().Network() == "unix":
+				name = socketAdmin
+			case listeners[socketHTTP] == nil:
+				name = socketHTTP
+			default:
+				name = socketMetrics
+			}
+		}
+		if listeners[name] != nil {
+			return nil, fmt.Errorf("Systemd passed more than one %v socket, name them with FileDescriptorName=http, metrics or admin", name)
+		}
+		listeners[name] = l
+	}
+	return listeners, nil
+}
+
+// listen uses the sockets passed by systemd and listens on the addresses of the flags for the others,
+// the metrics and the admin sockets are optional
+func listen(c *cli.Context) (map[string]net.Listener, error) {
+	listeners, err := activatedListeners()
+	if err != nil {
+		return nil, err
+	}
+	for name, l := range listeners {
+		log.Printf("Using the %v socket %v passed by systemd", name, l.Addr())
+	}
+	if listeners[socketHTTP] == nil {
+		if listeners[socketHTTP], err = net.Listen("tcp", ":"+srvConfig.Port); err != nil {
+			return nil, err
+		}
+	}
+	if listeners[socketMetrics] == nil && c.String("metrics-addr") != "" {
+		if listeners[socketMetrics], err = net.Listen("tcp", c.String("metrics-addr")); err != nil {
+			return nil, err
+		}
+	}
+	if listeners[socketAdmin] == nil && c.String("admin-socket") != "" {
+		if listeners[socketAdmin], err = listenUnix(c.String("admin-socket")); err != nil {
+			return nil, err
+		}
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on a unix socket that only the owner and the group can use, a stale socket file is removed first
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// admin serves the requests of the admin socket as an admin, the socket file permissions decide who can use it
+func admin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := &server.Identity{Name: socketAdmin, Role: config.Admin, Method: server.MethodSocket}
+		h.ServeHTTP(w, server.WithIdentity(r, id))
+	})
+}
+
+// localClient connects to the listener, used by the watchdog to check that the web server answers
+func localClient(l net.Listener) *http.Client {
+	network, addr := l.Addr().Network(), l.Addr().String()
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+// units writes the systemd service and socket units for the command line before the units command
+// rpi-web-control -pp password --metrics-addr 127.0.0.1:9110 --admin-socket /run/rpi-web-control/admin.sock units --dir /etc/systemd/system
+//
+// The units can be read by every user so the password goes to the --env-file that only root can read.
+func units(c *cli.Context) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var args []string
+	for _, a := range os.Args[1:] {
+		if a == c.Command.Name {
+			break
+		}
+		args = append(args, a)
+	}
+	args, password := withoutPassword(args)
+	exec := []string{quoteUnitArg(exe)}
+	for _, a := range args {
+		exec = append(exec, quoteUnitArg(a))
+	}
+
+	var user string
+	if c.String("user") != "" {
+		// the gpio group can use the sysfs and the character device gpio pins on Raspberry Pi OS
+		user = fmt.Sprintf("User=%v\nSupplementaryGroups=gpio\n", c.String("user"))
+	}
+	var env string
+	if password != "" {
+		if c.String("env-file") == "" {
+			return fmt.Errorf("The password can't be in the units, set the --env-file for it")
+		}
+		if strings.ContainsAny(password, "\r\n") {
+			return fmt.Errorf("The password can't have line breaks in an EnvironmentFile")
+		}
+		user += fmt.Sprintf("EnvironmentFile=%v\n", quoteUnitArg(c.String("env-file")))
+		env = fmt.Sprintf("%v=%v\n", passwordEnv, quoteEnvValue(password))
+	}
+	service := fmt.Sprintf(`[Unit]
+Description=Rpi Web Controller
+Requires=%[1]v.socket
+After=%[1]v.socket
+
+[Service]
+ExecStart=%[2]v
+%[3]vWatchdogSec=10s
+Restart=always
+
+[Install]
+WantedBy=multi-user.target
+`, unitName, strings.Join(exec, " "), user)
+
+	listen := []string{"ListenStream=" + c.GlobalString("port")}
+	if a := c.GlobalString("metrics-addr"); a != "" {
+		// systemd takes a port without the colon for all addresses
+		listen = append(listen, "ListenStream="+strings.TrimPrefix(a, ":"))
+	}
+	if a := c.GlobalString("admin-socket"); a != "" {
+		listen = append(listen, "ListenStream="+a, "SocketMode=0660")
+	}
+	socket := fmt.Sprintf(`[Unit]
+Description=Rpi Web Controller sockets
+
+[Socket]
+%v
+
+[Install]
+WantedBy=sockets.target
+`, strings.Join(listen, "\n"))
+
+	if c.String("dir") == "" {
+		fmt.Printf("# %v.service\n%v\n# %v.socket\n%v", unitName, service, unitName, socket)
+		if env != "" {
+			fmt.Printf("\n# %v, only root can read it(mode 0600)\n%v", c.String("env-file"), env)
+		}
+		return nil
+	}
+	if env != "" {
+		if err := writeSecret(c.String("env-file"), env); err != nil {
+			return err
+		}
+		fmt.Println("Saved", c.String("env-file"))
+	}
+	for _, u := range []struct{ name, unit string }{{unitName + ".service", service}, {unitName + ".socket", socket}} {
+		path := filepath.Join(c.String("dir"), u.name)
+		if err := ioutil.WriteFile(path, []byte(u.unit), 0644); err != nil {
+			return err
+		}
+		fmt.Println("Saved", path)
+	}
+	fmt.Printf("Start it with: systemctl daemon-reload && systemctl enable --now %v.socket && systemctl restart %v.service\n", unitName, unitName)
+	return nil
+}
+
+// withoutPassword removes the password flag from the arguments and returns the password
+func withoutPassword(args []string) ([]string, string) {
+	var l []string
+	var password string
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		if !strings.HasPrefix(args[i], "-") {
+			l = append(l, args[i])
+			continue
+		}
+		if kv := strings.SplitN(name, "=", 2); len(kv) == 2 && (kv[0] == "pp" || kv[0] == "password") {
+			password = kv[1]
+			continue
+		}
+		if name == "pp" || name == "password" {
+			if i+1 < len(args) {
+				password = args[i+1]
+			}
+			i++
+			continue
+		}
+		l = append(l, args[i])
+	}
+	return l, password
+}
+
+// writeSecret writes the file that only the owner can read, also when it exists already
+func writeSecret(path, data string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteString(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// quoteEnvValue quotes a value of an EnvironmentFile, the backslash escapes in double quotes keep $ and ` as they are
+func quoteEnvValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(v) + `"`
+}
+
+// quoteUnitArg quotes an ExecStart argument with spaces or quotes, systemd expands % and $ so they are escaped too
+func quoteUnitArg(a string) string {
+	a = strings.NewReplacer("%", "%%", "$", "$$").Replace(a)
+	if a != "" && !strings.ContainsAny(a, " \t\"'\\") {
+		return a
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(a) + `"`
+}
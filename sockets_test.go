@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+// socketFiles listens on the networks like systemd and returns the files it would pass with their addresses
+func socketFiles(t *testing.T, networks ...string) ([]*os.File, []string) {
+	t.Helper()
+	var files []*os.File
+	var addrs []string
+	for i, n := range networks {
+		var l net.Listener
+		var err error
+		switch n {
+		case "tcp":
+			l, err = net.Listen("tcp", "127.0.0.1:0")
+		case "unix":
+			l, err = net.Listen("unix", filepath.Join(t.TempDir(), "admin.sock"))
+		default:
+			// a file that isn't a socket
+			var f *os.File
+			if f, err = ioutil.TempFile(t.TempDir(), "file"); err == nil {
+				files, addrs = append(files, f), append(addrs, "")
+				continue
+			}
+		}
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		t.Cleanup(func() { l.Close() })
+		f, err := l.(interface{ File() (*os.File, error) }).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, addrs = append(files, f), append(addrs, l.Addr().String())
+	}
+	return files, addrs
+}
+
+func TestNamedListeners(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		networks []string
+		// fdnames is LISTEN_FDNAMES
+		fdnames string
+		// want are the indexes of the sockets by their name
+		want map[string]int
+		err  string
+	}{
+		{"unnamed", []string{"tcp", "tcp", "unix"}, "", map[string]int{socketHTTP: 0, socketMetrics: 1, socketAdmin: 2}, ""},
+		{"one unit", []string{"tcp", "unix", "tcp"}, unitName + ".socket:" + unitName + ".socket:" + unitName + ".socket",
+			map[string]int{socketHTTP: 0, socketAdmin: 1, socketMetrics: 2}, ""},
+		{"http only", []string{"tcp"}, "", map[string]int{socketHTTP: 0}, ""},
+		{"named", []string{"tcp", "tcp", "unix"}, "metrics:http:admin", map[string]int{socketMetrics: 0, socketHTTP: 1, socketAdmin: 2}, ""},
+		{"named unix", []string{"unix", "tcp"}, "http:admin", map[string]int{socketHTTP: 0, socketAdmin: 1}, ""},
+		{"some named", []string{"tcp", "tcp"}, "metrics", map[string]int{socketMetrics: 0, socketHTTP: 1}, ""},
+		{"twice", []string{"tcp", "tcp"}, "http:http", nil, "more than one http socket"},
+		{"two unix", []string{"unix", "unix"}, "", nil, "more than one admin socket"},
+		{"not a socket", []string{"tcp", "file"}, "", nil, "Socket 4 passed by systemd isn't a stream socket"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			files, addrs := socketFiles(t, tt.networks...)
+			listeners, err := namedListeners(files, strings.Split(tt.fdnames, ":"))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error with %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				for _, l := range listeners {
+					l.Close()
+				}
+			}()
+			if len(listeners) != len(tt.want) {
+				t.Fatalf("%v sockets, expected %v", len(listeners), len(tt.want))
+			}
+			for name, i := range tt.want {
+				if l := listeners[name]; l == nil || l.Addr().String() != addrs[i] {
+					t.Fatalf("the %v socket is %v, expected %v", name, l, addrs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQuoteUnitArg(t *testing.T) {
+	for in, want := range map[string]string{
+		"--port":                   "--port",
+		"/usr/bin/rpi-web-control": "/usr/bin/rpi-web-control",
+		"":                         `""`,
+		"a b":                      `"a b"`,
+		"tab\there":                "\"tab\there\"",
+		`say "hi"`:                 `"say \"hi\""`,
+		`it's`:                     `"it's"`,
+		`C:\dir`:                   `"C:\\dir"`,
+		"100%":                     "100%%",
+		"$HOME":                    "$$HOME",
+		"50% of $x":                `"50%% of $$x"`,
+	} {
+		if got := quoteUnitArg(in); got != want {
+			t.Errorf("quoteUnitArg(%q) = %v, expected %v", in, got, want)
+		}
+	}
+}
+
+func TestWithoutPassword(t *testing.T) {
+	for _, tt := range []struct {
+		args     string
+		want     string
+		password string
+	}{
+		{"-pp secret -p 8080", "-p 8080", "secret"},
+		{"--port 8080 --password secret -c a.toml", "--port 8080 -c a.toml", "secret"},
+		{"-pp=secret --simulate", "--simulate", "secret"},
+		{"--password=a=b", "", "a=b"},
+		{"-p 8080 -pp", "-p 8080", ""},
+		{"-p 8080 --users users.json", "-p 8080 --users users.json", ""},
+	} {
+		args, password := withoutPassword(strings.Fields(tt.args))
+		if strings.Join(args, " ") != tt.want || password != tt.password {
+			t.Errorf("%v: %v with the password %q, expected %v with %q", tt.args, args, password, tt.want, tt.password)
+		}
+	}
+}
+
+// unitsContext is the context of the units command with the global flags
+func unitsContext(t *testing.T, global, flags map[string]string) *cli.Context {
+	t.Helper()
+	gset := flag.NewFlagSet("global", flag.ContinueOnError)
+	for _, f := range []string{"port", "metrics-addr", "admin-socket"} {
+		gset.String(f, global[f], "")
+	}
+	set := flag.NewFlagSet("units", flag.ContinueOnError)
+	for _, f := range []string{"dir", "user", "env-file"} {
+		set.String(f, flags[f], "")
+	}
+	app := cli.NewApp()
+	c := cli.NewContext(app, set, cli.NewContext(app, gset, nil))
+	c.Command = cli.Command{Name: "units"}
+	return c
+}
+
+func TestUnits(t *testing.T) {
+	dir := t.TempDir()
+	env := filepath.Join(dir, "env")
+	// an existing file that everybody can read gets the mode too
+	if err := ioutil.WriteFile(env, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"rpi-web-control", "-pp", `hun"ter$2`, "-c", "/etc/rpi web/config.toml", "units", "--dir", dir}
+
+	c := unitsContext(t, map[string]string{"port": "8080", "admin-socket": "/run/rpi-web-control/admin.sock"},
+		map[string]string{"dir": dir, "env-file": env})
+	if err := units(c); err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := ioutil.ReadFile(filepath.Join(dir, unitName+".service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(service), "hun") || strings.Contains(string(service), "-pp") {
+		t.Fatalf("the password is in the service unit:\n%s", service)
+	}
+	if !strings.Contains(string(service), "EnvironmentFile="+env+"\n") {
+		t.Fatalf("the service unit doesn't load the env file:\n%s", service)
+	}
+	if !strings.Contains(string(service), ` -c "/etc/rpi web/config.toml"`) {
+		t.Fatalf("the other arguments aren't in the service unit:\n%s", service)
+	}
+	socket, err := ioutil.ReadFile(filepath.Join(dir, unitName+".socket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(socket), "ListenStream=8080\nListenStream=/run/rpi-web-control/admin.sock\nSocketMode=0660\n") {
+		t.Fatalf("unexpected socket unit:\n%s", socket)
+	}
+
+	fi, err := os.Stat(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("the env file has the mode %v", fi.Mode().Perm())
+	}
+	b, _ := ioutil.ReadFile(env)
+	if string(b) != passwordEnv+`="hun\"ter\$2"`+"\n" {
+		t.Fatalf("unexpected env file:%s", b)
+	}
+
+	// a password that can't be written to the env file isn't written anywhere
+	os.Args = []string{"rpi-web-control", "-pp", "two\nlines", "units", "--dir", dir}
+	if err := units(c); err == nil {
+		t.Fatal("a password with a line break didn't fail")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/krasi-georgiev/rpi-web-control/server"
+	"github.com/urfave/cli"
+)
+
+// usersFile opens the user store from the global --users flag
+func usersFile(c *cli.Context) (*server.Users, error) {
+	if c.GlobalString("users") == "" {
+		return nil, errors.New("Set the users file with --users")
+	}
+	return server.LoadUsers(c.GlobalString("users"))
+}
+
+// usersAdd adds a user or changes the password of an existing one
+// rpi-web-control --users /etc/rpi-web-control/users.json users add --role host alice
+func usersAdd(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		return errors.New("The user name is required")
+	}
+	u, err := usersFile(c)
+	if err != nil {
+		return err
+	}
+	password, err := readPassword("Password for " + name + ": ")
+	if err != nil {
+		return err
+	}
+	confirm, err := readPassword("Repeat the password: ")
+	if err != nil {
+		return err
+	}
+	if password != confirm {
+		return errors.New("The passwords don't match")
+	}
+	if err := u.Set(name, password, c.String("role")); err != nil {
+		return err
+	}
+	fmt.Println("Saved user", name, "with role", c.String("role"))
+	return nil
+}
+
+// usersRemove revokes the user, the running server picks up the change without a restart
+func usersRemove(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		return errors.New("The user name is required")
+	}
+	u, err := usersFile(c)
+	if err != nil {
+		return err
+	}
+	if err := u.Remove(name); err != nil {
+		return err
+	}
+	fmt.Println("Removed user", name)
+	return nil
+}
+
+func usersList(c *cli.Context) error {
+	u, err := usersFile(c)
+	if err != nil {
+		return err
+	}
+	list, err := u.List()
+	if err != nil {
+		return err
+	}
+	for _, user := range list {
+		fmt.Println(user.Name, user.Role)
+	}
+	return nil
+}
+
+// readPassword reads a line from the terminal without echoing it,
+// when stdin isn't a terminal the password can be piped in
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if err := stty("-echo"); err == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}